@@ -0,0 +1,125 @@
+// Package apikey issues and checks the API keys services call the API with.
+//
+// Keys look like cuk_<prefix>_<secret>. The prefix is stored as is and finds
+// the key, the whole key is checked against a SHA-256 hash, so a leaked
+// database doesn't leak working keys.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"crud/user/auth"
+	"crud/user/models"
+	"crud/user/repository"
+)
+
+const (
+	keyTag       = "cuk_"
+	prefixLength = 12
+)
+
+// touchInterval is how stale the recorded last use may get, so a busy key
+// doesn't write on every request.
+const touchInterval = time.Minute
+
+// ErrInvalidKey is returned by Verify for malformed, unknown and revoked keys.
+var ErrInvalidKey = errors.New("the API key is invalid or revoked")
+
+// Manager creates, rotates and verifies API keys.
+type Manager struct {
+	keys repository.APIKeyRepository
+}
+
+func NewManager(keys repository.APIKeyRepository) *Manager {
+	return &Manager{keys: keys}
+}
+
+// Create issues a key named name holding scopes. The key itself is only
+// returned here, it can't be recovered later.
+func (m *Manager) Create(ctx context.Context, name string, scopes []string) (string, *models.APIKey, error) {
+	secret, prefix, err := generate()
+	if err != nil {
+		return "", nil, err
+	}
+	key := models.APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   auth.HashToken(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	if err := m.keys.Create(ctx, &key); err != nil {
+		return "", nil, err
+	}
+	return secret, &key, nil
+}
+
+// Rotate replaces the key with id by a new one with the same name and scopes.
+// The old key stops working at once.
+func (m *Manager) Rotate(ctx context.Context, id uint) (string, *models.APIKey, error) {
+	secret, prefix, err := generate()
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := m.keys.Rotate(ctx, id, prefix, auth.HashToken(secret))
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, key, nil
+}
+
+// Verify returns the key secret belongs to and records it was used.
+func (m *Manager) Verify(ctx context.Context, secret string) (*models.APIKey, error) {
+	prefix, ok := parse(secret)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	key, err := m.keys.FindByPrefix(ctx, prefix)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(key.KeyHash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= touchInterval {
+		if err := m.keys.Touch(ctx, key.ID, now); err != nil {
+			return nil, err
+		}
+		key.LastUsedAt = &now
+	}
+	return key, nil
+}
+
+// generate draws a new key and returns it with its prefix.
+func generate() (string, string, error) {
+	raw := make([]byte, prefixLength/2)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	prefix := hex.EncodeToString(raw)
+	secret, err := auth.NewToken()
+	if err != nil {
+		return "", "", err
+	}
+	return keyTag + prefix + "_" + secret, prefix, nil
+}
+
+// parse returns the prefix of key, and false when key isn't shaped like one.
+func parse(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, keyTag)
+	if !ok || len(rest) <= prefixLength+1 || rest[prefixLength] != '_' {
+		return "", false
+	}
+	return rest[:prefixLength], true
+}
@@ -0,0 +1,67 @@
+package apikey
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"crud/user/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryAPIKeyRepository()
+	m := NewManager(repo)
+
+	secret, key, err := m.Create(ctx, "export", []string{"users:read"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, "cuk_"+key.Prefix+"_"))
+	assert.NotContains(t, key.KeyHash, secret)
+
+	verified, err := m.Verify(ctx, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, verified.ID)
+	assert.NotNil(t, verified.LastUsedAt)
+
+	// A known prefix with the wrong secret, and malformed keys, don't work
+	for _, bad := range []string{"cuk_" + key.Prefix + "_wrong", "cuk_short", "", strings.TrimPrefix(secret, "cuk_")} {
+		_, err := m.Verify(ctx, bad)
+		assert.ErrorIs(t, err, ErrInvalidKey, bad)
+	}
+
+	rotated, rotatedKey, err := m.Rotate(ctx, key.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "export", rotatedKey.Name)
+	_, err = m.Verify(ctx, secret)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = m.Verify(ctx, rotated)
+	assert.NoError(t, err)
+
+	assert.NoError(t, repo.Revoke(ctx, key.ID))
+	_, err = m.Verify(ctx, rotated)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, _, err = m.Rotate(ctx, key.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func TestVerifyThrottlesLastUse(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryAPIKeyRepository()
+	m := NewManager(repo)
+	secret, key, _ := m.Create(ctx, "export", []string{"users:read"})
+
+	recent := time.Now().Add(-time.Second)
+	assert.NoError(t, repo.Touch(ctx, key.ID, recent))
+	verified, err := m.Verify(ctx, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, recent, *verified.LastUsedAt)
+
+	stale := time.Now().Add(-time.Hour)
+	assert.NoError(t, repo.Touch(ctx, key.ID, stale))
+	verified, err = m.Verify(ctx, secret)
+	assert.NoError(t, err)
+	assert.True(t, verified.LastUsedAt.After(stale))
+}
@@ -0,0 +1,174 @@
+// Package audit describes who changes users, from which request, and how,
+// for the audit log the user repositories write along with every change.
+//
+// The actor and request ID travel in the context of the change, set by the
+// HTTP middleware. Changes made outside of a request are made by System.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"crud/user/models"
+)
+
+const (
+	// System makes the changes no request asked for
+	System = "system"
+	// Anonymous makes the changes of unauthenticated requests, like password resets
+	Anonymous = "anonymous"
+)
+
+// redacted stands for a password in the log, which only shows it changed.
+var redacted = json.RawMessage(`"[redacted]"`)
+
+// ignored fields are never part of a diff: the ID doesn't change, version
+// and updatedAt change every time.
+var ignored = map[string]bool{"id": true, "version": true, "updatedAt": true}
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	requestIDKey
+)
+
+// UserActor is the actor of changes made by the user with id.
+func UserActor(id uint) string {
+	return "user:" + strconv.FormatUint(uint64(id), 10)
+}
+
+// APIKeyActor is the actor of changes made with the API key with id.
+func APIKeyActor(id uint) string {
+	return "api_key:" + strconv.FormatUint(uint64(id), 10)
+}
+
+// ClientActor is the actor of changes made with a token issued to a client
+// rather than a user, named by the subject of the token.
+func ClientActor(subject string) string {
+	return "client:" + subject
+}
+
+// WithActor returns a copy of ctx whose changes are made by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// WithRequestID returns a copy of ctx whose changes are made by the request with id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// ActorFrom returns the actor set by WithActor, System when there is none.
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok && actor != "" {
+		return actor
+	}
+	return System
+}
+
+// RequestIDFrom returns the request ID set by WithRequestID, if any.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Entry describes the change of a user from before to after, made in ctx.
+// Before is nil for created users, after for hard-deleted ones.
+func Entry(ctx context.Context, action string, before, after *models.User) (*models.AuditEntry, error) {
+	changes, err := Diff(before, after)
+	if err != nil {
+		return nil, err
+	}
+	entry := &models.AuditEntry{
+		Action:    action,
+		Actor:     ActorFrom(ctx),
+		RequestID: RequestIDFrom(ctx),
+		Changes:   changes,
+	}
+	if after != nil {
+		entry.UserID = after.ID
+	} else if before != nil {
+		entry.UserID = before.ID
+	}
+	return entry, nil
+}
+
+// Diff lists the fields that differ between before and after, by their JSON
+// name, with their JSON values. Either may be nil, all its fields are null.
+// Passwords are only shown to change, never their hash.
+func Diff(before, after *models.User) (models.Changes, error) {
+	old, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := models.Changes{}
+	for name := range merge(old, updated) {
+		if ignored[name] {
+			continue
+		}
+		from, to := orNull(old[name]), orNull(updated[name])
+		if string(from) != string(to) {
+			changes[name] = models.Change{Before: from, After: to}
+		}
+	}
+
+	if hash(before) != hash(after) {
+		changes["password"] = models.Change{Before: password(before), After: password(after)}
+	}
+	return changes, nil
+}
+
+func fields(user *models.User) (map[string]json.RawMessage, error) {
+	if user == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func merge(a, b map[string]json.RawMessage) map[string]bool {
+	names := map[string]bool{}
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+	return names
+}
+
+func orNull(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	return value
+}
+
+func hash(user *models.User) string {
+	if user == nil {
+		return ""
+	}
+	return user.PasswordHash
+}
+
+// password is null for users without one.
+func password(user *models.User) json.RawMessage {
+	if hash(user) == "" {
+		return json.RawMessage("null")
+	}
+	return redacted
+}
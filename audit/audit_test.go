@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"crud/user/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestDiff(t *testing.T) {
+	created := time.Date(2024, 7, 10, 4, 24, 55, 0, time.UTC)
+	before := &models.User{ID: 1, Name: "test", Email: "test@gmail.com", Age: 24, CreatedAt: created, UpdatedAt: created}
+	after := *before
+	after.Email = "new@gmail.com"
+	after.PasswordHash = "$2a$04$hash"
+	after.UpdatedAt = created.Add(time.Hour)
+
+	changes, err := Diff(before, &after)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Changes{
+		"email":    {Before: json.RawMessage(`"test@gmail.com"`), After: json.RawMessage(`"new@gmail.com"`)},
+		"password": {Before: json.RawMessage(`null`), After: json.RawMessage(`"[redacted]"`)},
+	}, changes)
+
+	deleted := after
+	deleted.DeletedAt = gorm.DeletedAt{Time: created, Valid: true}
+	changes, err = Diff(&after, &deleted)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Changes{"deletedAt": {Before: json.RawMessage(`null`), After: json.RawMessage(`"2024-07-10T04:24:55Z"`)}}, changes)
+
+	// Created users start from nothing, fields left null aren't changes
+	changes, err = Diff(nil, before)
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`"test"`), changes["name"].After)
+	assert.Equal(t, json.RawMessage(`null`), changes["name"].Before)
+	assert.NotContains(t, changes, "deletedAt")
+	assert.NotContains(t, changes, "id")
+	assert.NotContains(t, changes, "password")
+}
+
+func TestEntry(t *testing.T) {
+	user := &models.User{ID: 3, Name: "test"}
+
+	entry, err := Entry(context.Background(), models.AuditHardDelete, user, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), entry.UserID)
+	assert.Equal(t, System, entry.Actor)
+	assert.Empty(t, entry.RequestID)
+
+	ctx := WithRequestID(WithActor(context.Background(), APIKeyActor(2)), "req-1")
+	entry, err = Entry(ctx, models.AuditCreate, nil, user)
+	assert.NoError(t, err)
+	assert.Equal(t, "api_key:2", entry.Actor)
+	assert.Equal(t, "req-1", entry.RequestID)
+}
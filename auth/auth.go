@@ -0,0 +1,231 @@
+// Package auth verifies the JSON Web Tokens callers authenticate with, and
+// issues them to users logging in with a password.
+//
+// Tokens are signed either with HS256 and a shared secret, or with RS256 and a
+// private key whose public half is configured as a PEM file or a JWKS file.
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the parts of a token the service acts on.
+// The subject is the ID of the user the token was issued to.
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+	// SessionID is the session a user logged in with, tokens of other clients have none
+	SessionID uint `json:"sid,omitempty"`
+}
+
+// Config tells the Verifier which keys to trust and what to expect in tokens.
+type Config struct {
+	// HMACSecretFile holds the shared secret of HS256 tokens
+	HMACSecretFile string
+	// PublicKeyFile holds the PEM encoded RSA public key of RS256 tokens
+	PublicKeyFile string
+	// JWKSFile holds a JSON Web Key Set of RS256 keys, picked by the kid of the token
+	JWKSFile string
+	// PrivateKeyFile holds the PEM encoded RSA private key tokens are issued with,
+	// its public half is trusted too. KeyID is announced as the kid of issued tokens.
+	PrivateKeyFile string
+	KeyID          string
+
+	// Lifetime of issued tokens
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Issuer and Audience are checked when set
+	Issuer   string
+	Audience string
+	// Leeway absorbs clock skew between the issuer and this service
+	Leeway time.Duration
+}
+
+// Verifier checks tokens and extracts their claims.
+type Verifier struct {
+	secret []byte
+	// publicKeys are tried in turn for tokens without a kid from keySet
+	publicKeys []*rsa.PublicKey
+	keySet     map[string]*rsa.PublicKey
+	parser     *jwt.Parser
+	algorithms []string
+}
+
+// ErrNoKeys is returned by NewVerifier when no key is configured at all.
+var ErrNoKeys = errors.New("no HS256 secret, RS256 public key or JWKS configured")
+
+// NewVerifier loads the keys named in config.
+func NewVerifier(config Config) (*Verifier, error) {
+	v := &Verifier{}
+
+	if config.HMACSecretFile != "" {
+		raw, err := os.ReadFile(config.HMACSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("read HS256 secret: %w", err)
+		}
+		v.secret = []byte(strings.TrimSpace(string(raw)))
+		if len(v.secret) < 32 {
+			return nil, errors.New("HS256 secret should be at least 32 bytes")
+		}
+		v.algorithms = append(v.algorithms, jwt.SigningMethodHS256.Alg())
+	}
+
+	if config.PublicKeyFile != "" {
+		raw, err := os.ReadFile(config.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read RS256 public key: %w", err)
+		}
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse RS256 public key: %w", err)
+		}
+		v.publicKeys = append(v.publicKeys, publicKey)
+	}
+
+	v.keySet = map[string]*rsa.PublicKey{}
+	if config.JWKSFile != "" {
+		raw, err := os.ReadFile(config.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("read JWKS: %w", err)
+		}
+		v.keySet, err = parseJWKS(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse JWKS %s: %w", config.JWKSFile, err)
+		}
+	}
+
+	if config.PrivateKeyFile != "" {
+		privateKey, err := loadPrivateKey(config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if config.KeyID != "" {
+			v.keySet[config.KeyID] = &privateKey.PublicKey
+		} else {
+			v.publicKeys = append(v.publicKeys, &privateKey.PublicKey)
+		}
+	}
+
+	if len(v.publicKeys) > 0 || len(v.keySet) > 0 {
+		v.algorithms = append(v.algorithms, jwt.SigningMethodRS256.Alg())
+	}
+	if len(v.algorithms) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(v.algorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(config.Leeway),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	v.parser = jwt.NewParser(options...)
+
+	return v, nil
+}
+
+// Verify checks the signature and the registered claims of token and returns its claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return claims, nil
+}
+
+// key picks the key a token should be verified with.
+func (v *Verifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return v.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := v.keySet[kid]; ok {
+			return key, nil
+		}
+		if len(v.publicKeys) > 0 {
+			keys := jwt.VerificationKeySet{}
+			for _, key := range v.publicKeys {
+				keys.Keys = append(keys.Keys, key)
+			}
+			return keys, nil
+		}
+		return nil, fmt.Errorf("no key with kid %q", kid)
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
+
+func loadPrivateKey(file string) (*rsa.PrivateKey, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read RS256 private key: %w", err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse RS256 private key: %w", err)
+	}
+	return key, nil
+}
+
+// jwk is the subset of a JSON Web Key needed for RSA signature keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// parseJWKS reads the RSA signature keys of a JSON Web Key Set, keyed by kid.
+// Keys of other types or uses are skipped.
+func parseJWKS(raw []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") || (key.Alg != "" && key.Alg != "RS256") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q has an invalid modulus", key.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("key %q has an invalid exponent", key.Kid)
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RS256 signature keys")
+	}
+	return keys, nil
+}
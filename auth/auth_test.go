@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const secret = "an-hs256-secret-of-at-least-32-bytes"
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, content, 0o600))
+	return path
+}
+
+func claims(subject string, expiresIn time.Duration) Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Issuer:    "https://auth.example.com",
+			Audience:  jwt.ClaimStrings{"crud-user"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		},
+		Roles: []string{"operator"},
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, c Claims) string {
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+	return signed
+}
+
+func TestVerifyHS256(t *testing.T) {
+	v, err := NewVerifier(Config{
+		HMACSecretFile: writeFile(t, "secret", []byte(secret+"\n")),
+		Issuer:         "https://auth.example.com",
+		Audience:       "crud-user",
+	})
+	assert.NoError(t, err)
+
+	got, err := v.Verify(sign(t, jwt.SigningMethodHS256, []byte(secret), "", claims("42", time.Hour)))
+	assert.NoError(t, err)
+	assert.Equal(t, "42", got.Subject)
+	assert.Equal(t, []string{"operator"}, got.Roles)
+
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, []byte(secret), "", claims("42", -time.Hour)))
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, []byte("another-secret-of-at-least-32-bytes"), "", claims("42", time.Hour)))
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+
+	wrongAudience := claims("42", time.Hour)
+	wrongAudience.Audience = jwt.ClaimStrings{"billing"}
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, []byte(secret), "", wrongAudience))
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, []byte(secret), "", claims("", time.Hour)))
+	assert.ErrorContains(t, err, "no subject")
+
+	// RS256 isn't accepted without an RSA key
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, err = v.Verify(sign(t, jwt.SigningMethodRS256, key, "", claims("42", time.Hour)))
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+}
+
+func TestVerifyRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	pemFile := writeFile(t, "public.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "2024-07", "use": "sig", "alg": "RS256", "n": base64.RawURLEncoding.EncodeToString(rotated.N.Bytes()), "e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rotated.E)).Bytes())},
+		{"kty": "EC", "kid": "ignored"},
+	}})
+	assert.NoError(t, err)
+	jwksFile := writeFile(t, "jwks.json", jwks)
+
+	v, err := NewVerifier(Config{PublicKeyFile: pemFile, JWKSFile: jwksFile})
+	assert.NoError(t, err)
+
+	_, err = v.Verify(sign(t, jwt.SigningMethodRS256, key, "", claims("1", time.Hour)))
+	assert.NoError(t, err)
+
+	_, err = v.Verify(sign(t, jwt.SigningMethodRS256, rotated, "2024-07", claims("1", time.Hour)))
+	assert.NoError(t, err)
+
+	// A key from the set only verifies tokens naming it
+	_, err = v.Verify(sign(t, jwt.SigningMethodRS256, rotated, "", claims("1", time.Hour)))
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+
+	// HS256 isn't accepted without a secret, even signed with the public key
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, der, "", claims("1", time.Hour)))
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+}
+
+func TestNewVerifierErrors(t *testing.T) {
+	_, err := NewVerifier(Config{})
+	assert.ErrorIs(t, err, ErrNoKeys)
+
+	_, err = NewVerifier(Config{HMACSecretFile: writeFile(t, "secret", []byte("short"))})
+	assert.ErrorContains(t, err, "at least 32 bytes")
+
+	_, err = NewVerifier(Config{JWKSFile: writeFile(t, "jwks.json", []byte(`{"keys": []}`))})
+	assert.ErrorContains(t, err, "no RS256 signature keys")
+
+	_, err = NewVerifier(Config{PublicKeyFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNoSigningKey is returned by NewIssuer when neither an HS256 secret nor an RS256 private key is configured.
+var ErrNoSigningKey = errors.New("no HS256 secret or RS256 private key to sign tokens with")
+
+// TokenPair is handed to a user logging in. The access token is a JWT that
+// authenticates requests, the refresh token is an opaque random string that
+// gets a new pair once the access token expires.
+type TokenPair struct {
+	AccessToken      string
+	RefreshToken     string
+	ExpiresIn        time.Duration
+	RefreshExpiresAt time.Time
+}
+
+// Issuer signs tokens for users of this service. It prefers the RS256 private
+// key over the HS256 secret when both are configured.
+type Issuer struct {
+	method     jwt.SigningMethod
+	key        interface{}
+	keyID      string
+	issuer     string
+	audience   string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+func NewIssuer(config Config) (*Issuer, error) {
+	i := &Issuer{
+		keyID:      config.KeyID,
+		issuer:     config.Issuer,
+		audience:   config.Audience,
+		accessTTL:  config.AccessTokenTTL,
+		refreshTTL: config.RefreshTokenTTL,
+		now:        time.Now,
+	}
+
+	switch {
+	case config.PrivateKeyFile != "":
+		key, err := loadPrivateKey(config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		i.method, i.key = jwt.SigningMethodRS256, key
+	case config.HMACSecretFile != "":
+		raw, err := os.ReadFile(config.HMACSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("read HS256 secret: %w", err)
+		}
+		secret := []byte(strings.TrimSpace(string(raw)))
+		if len(secret) < 32 {
+			return nil, errors.New("HS256 secret should be at least 32 bytes")
+		}
+		i.method, i.key = jwt.SigningMethodHS256, secret
+	default:
+		return nil, ErrNoSigningKey
+	}
+	return i, nil
+}
+
+// Issue signs a new access token for the session of the user and pairs it with
+// refreshToken, drawn by NewToken. Keeping track of the refresh token is up to
+// the caller, see HashToken.
+func (i *Issuer) Issue(userID, sessionID uint, refreshToken string) (*TokenPair, error) {
+	now := i.now()
+	access, err := i.sign(userID, sessionID, now)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      access,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        i.accessTTL,
+		RefreshExpiresAt: now.Add(i.refreshTTL),
+	}, nil
+}
+
+// RefreshTTL is how long a refresh token lasts.
+func (i *Issuer) RefreshTTL() time.Duration {
+	return i.refreshTTL
+}
+
+func (i *Issuer) sign(userID, sessionID uint, now time.Time) (string, error) {
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			Issuer:    i.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(i.accessTTL)),
+		},
+		SessionID: sessionID,
+	}
+	if i.audience != "" {
+		claims.Audience = jwt.ClaimStrings{i.audience}
+	}
+
+	token := jwt.NewWithClaims(i.method, claims)
+	if i.keyID != "" {
+		token.Header["kid"] = i.keyID
+	}
+	return token.SignedString(i.key)
+}
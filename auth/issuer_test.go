@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssueHS256(t *testing.T) {
+	config := Config{
+		HMACSecretFile:  writeFile(t, "secret", []byte(secret)),
+		Issuer:          "https://users.example.com",
+		Audience:        "crud-user",
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 24 * time.Hour,
+	}
+	issuer, err := NewIssuer(config)
+	assert.NoError(t, err)
+	verifier, err := NewVerifier(config)
+	assert.NoError(t, err)
+
+	refresh, err := NewToken()
+	assert.NoError(t, err)
+	pair, err := issuer.Issue(42, 3, refresh)
+	assert.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, pair.ExpiresIn)
+
+	access, err := verifier.Verify(pair.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "42", access.Subject)
+	assert.Equal(t, uint(3), access.SessionID)
+	assert.Empty(t, access.Roles)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), access.ExpiresAt.Time, time.Minute)
+
+	// Refresh tokens are opaque, they are no JWT and can't stand in for an access token
+	assert.Equal(t, refresh, pair.RefreshToken)
+	assert.Len(t, pair.RefreshToken, 43)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), pair.RefreshExpiresAt, time.Minute)
+	assert.Equal(t, 24*time.Hour, issuer.RefreshTTL())
+	_, err = verifier.Verify(pair.RefreshToken)
+	assert.Error(t, err)
+
+	next, err := NewToken()
+	assert.NoError(t, err)
+	assert.NotEqual(t, refresh, next)
+}
+
+func TestIssueRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	for _, kid := range []string{"", "2024-07"} {
+		config := Config{
+			PrivateKeyFile:  writeFile(t, "private.pem", privatePEM),
+			KeyID:           kid,
+			AccessTokenTTL:  time.Minute,
+			RefreshTokenTTL: time.Hour,
+		}
+		issuer, err := NewIssuer(config)
+		assert.NoError(t, err)
+		// The public half of the private key is trusted without configuring it again
+		verifier, err := NewVerifier(config)
+		assert.NoError(t, err)
+
+		pair, err := issuer.Issue(7, 1, "refresh")
+		assert.NoError(t, err)
+		claims, err := verifier.Verify(pair.AccessToken)
+		assert.NoError(t, err, kid)
+		assert.Equal(t, "7", claims.Subject)
+	}
+}
+
+func TestNewIssuerErrors(t *testing.T) {
+	_, err := NewIssuer(Config{JWKSFile: "/etc/crud-user/jwks.json"})
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+
+	_, err = NewIssuer(Config{HMACSecretFile: writeFile(t, "short", []byte("too short"))})
+	assert.ErrorContains(t, err, "at least 32 bytes")
+
+	_, err = NewIssuer(Config{PrivateKeyFile: writeFile(t, "private.pem", []byte("not a key"))})
+	assert.ErrorContains(t, err, "parse RS256 private key")
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"errors"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MinPasswordLength = 12
+	// MaxPasswordLength is the most bcrypt reads, in bytes
+	MaxPasswordLength = 72
+)
+
+// ErrWrongPassword is returned by Check when the password doesn't match,
+// or when there is no hash to compare with.
+var ErrWrongPassword = errors.New("wrong password")
+
+// ErrWeakPassword is returned by CheckPasswordStrength.
+var ErrWeakPassword = errors.New("password should be 12 to 72 characters long and mix at least three of lowercase letters, uppercase letters, digits and symbols")
+
+// Passwords hashes and checks passwords with bcrypt.
+type Passwords struct {
+	cost int
+	// dummy is compared against when there is no hash, so checking
+	// takes as long whether the user exists and can log in or not
+	dummy []byte
+}
+
+// NewPasswords hashes at the given bcrypt cost, from 4 to 31.
+func NewPasswords(cost int) (*Passwords, error) {
+	dummy, err := bcrypt.GenerateFromPassword([]byte("no password set"), cost)
+	if err != nil {
+		return nil, err
+	}
+	return &Passwords{cost: cost, dummy: dummy}, nil
+}
+
+// Hash returns the bcrypt hash of password.
+func (p *Passwords) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), p.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Check returns ErrWrongPassword unless password matches hash.
+func (p *Passwords) Check(hash, password string) error {
+	if hash == "" {
+		_ = bcrypt.CompareHashAndPassword(p.dummy, []byte(password))
+		return ErrWrongPassword
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// CheckPasswordStrength returns ErrWeakPassword unless password is long enough
+// and mixes at least three kinds of characters.
+func CheckPasswordStrength(password string) error {
+	if len([]rune(password)) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return ErrWeakPassword
+	}
+
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	if lower+upper+digit+symbol < 3 {
+		return ErrWeakPassword
+	}
+	return nil
+}
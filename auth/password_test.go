@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswords(t *testing.T) {
+	passwords, err := NewPasswords(4)
+	assert.NoError(t, err)
+
+	hash, err := passwords.Hash("correct-Horse-battery")
+	assert.NoError(t, err)
+	assert.NotContains(t, hash, "correct-Horse-battery")
+
+	assert.NoError(t, passwords.Check(hash, "correct-Horse-battery"))
+	assert.ErrorIs(t, passwords.Check(hash, "correct-horse-battery"), ErrWrongPassword)
+	// Users without a password can't log in, whatever they send
+	assert.ErrorIs(t, passwords.Check("", ""), ErrWrongPassword)
+	assert.ErrorIs(t, passwords.Check("", "correct-Horse-battery"), ErrWrongPassword)
+
+	_, err = NewPasswords(32)
+	assert.Error(t, err)
+}
+
+func TestCheckPasswordStrength(t *testing.T) {
+	tests := map[string]bool{
+		"correct-Horse-battery": true,
+		"Tr0ub4dor&3x":          true,
+		"CorrectHorseBattery1":  true,  // Three classes are enough
+		"correcthorsebattery":   false, // One class
+		"correct-horse-battery": false, // Two classes
+		"Sh0rt&sweet":           false, // 11 characters
+		"ĉĝĥĵŝŭ-Ĉĝĥĵŝŭ1":        true,  // Length counts characters, not bytes
+	}
+	long := "Aa1-"
+	for len(long) < 73 {
+		long += "x"
+	}
+	tests[long] = false // bcrypt ignores anything past 72 bytes
+
+	for password, strong := range tests {
+		err := CheckPasswordStrength(password)
+		if strong {
+			assert.NoError(t, err, password)
+		} else {
+			assert.ErrorIs(t, err, ErrWeakPassword, password)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// tokenBytes is the entropy of the opaque tokens handed out by the service.
+const tokenBytes = 32
+
+// NewToken draws an opaque random token, like a refresh token or the token
+// of an email verification link.
+func NewToken() (string, error) {
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("draw token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// HashToken is what opaque tokens are stored and looked up as, so a leaked
+// table can't be replayed. The tokens are random enough not to need a salt.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewToken(t *testing.T) {
+	token, err := NewToken()
+	assert.NoError(t, err)
+	assert.Len(t, token, 43)
+
+	other, err := NewToken()
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, other)
+}
+
+func TestHashToken(t *testing.T) {
+	hash := HashToken("token")
+	assert.Equal(t, hash, HashToken("token"))
+	assert.NotEqual(t, hash, HashToken("token2"))
+	assert.NotContains(t, hash, "token")
+	assert.Len(t, hash, 64)
+}
@@ -0,0 +1,415 @@
+// Package config loads the settings of the service from defaults, an optional
+// YAML or JSON file, a .env file and the environment, in that order of precedence.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the service.
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Mail        MailConfig        `yaml:"mail"`
+	Purge       PurgeConfig       `yaml:"purge"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Features    FeaturesConfig    `yaml:"features"`
+}
+
+type ServerConfig struct {
+	// Addr is the address the HTTP server listens on
+	Addr string `yaml:"addr"`
+	// PublicHost is the host clients reach the API on, shown in the swagger docs
+	PublicHost string `yaml:"publicHost"`
+	// HealthCheckTimeout bounds every dependency check of /readyz
+	HealthCheckTimeout time.Duration `yaml:"healthCheckTimeout"`
+	// DrainDelay keeps serving after /readyz starts failing on shutdown,
+	// so load balancers stop sending requests before the listener closes
+	DrainDelay time.Duration `yaml:"drainDelay"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslMode"`
+	TimeZone string `yaml:"timeZone"`
+
+	// Connection pool, zero means no limit
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime"`
+
+	// Connecting at startup is retried ConnectAttempts times, waiting
+	// ConnectBackoff after the first failure and doubling up to ConnectMaxBackoff
+	ConnectAttempts   int           `yaml:"connectAttempts"`
+	ConnectBackoff    time.Duration `yaml:"connectBackoff"`
+	ConnectMaxBackoff time.Duration `yaml:"connectMaxBackoff"`
+}
+
+// AuthConfig names the keys JWTs are verified with, at least one is needed.
+// Logging in needs the HS256 secret or the RS256 private key to sign tokens with.
+type AuthConfig struct {
+	HMACSecretFile string `yaml:"hs256SecretFile"`
+	PublicKeyFile  string `yaml:"rs256PublicKeyFile"`
+	JWKSFile       string `yaml:"jwksFile"`
+	PrivateKeyFile string `yaml:"rs256PrivateKeyFile"`
+	KeyID          string `yaml:"keyID"`
+
+	AccessTokenTTL  time.Duration `yaml:"accessTokenTTL"`
+	RefreshTokenTTL time.Duration `yaml:"refreshTokenTTL"`
+	// BcryptCost is the work factor passwords are hashed with
+	BcryptCost int `yaml:"bcryptCost"`
+	// EmailVerificationTTL is how long a verification link works
+	EmailVerificationTTL time.Duration `yaml:"emailVerificationTTL"`
+	// PasswordResetTTL is how long a password reset link works
+	PasswordResetTTL time.Duration `yaml:"passwordResetTTL"`
+	// PhoneCodeTTL is how long a texted code works. Entering PhoneCodeMaxAttempts
+	// wrong codes locks the user out of phone verification for PhoneCodeLockout.
+	PhoneCodeTTL         time.Duration `yaml:"phoneCodeTTL"`
+	PhoneCodeMaxAttempts int           `yaml:"phoneCodeMaxAttempts"`
+	PhoneCodeLockout     time.Duration `yaml:"phoneCodeLockout"`
+
+	// Issuer and Audience are checked against the iss and aud claims when set
+	Issuer   string        `yaml:"issuer"`
+	Audience string        `yaml:"audience"`
+	Leeway   time.Duration `yaml:"leeway"`
+}
+
+// MailConfig picks how emails are delivered: "log" prints them, "file" writes
+// them to Dir and "smtp" sends them through the SMTP server.
+type MailConfig struct {
+	Driver string `yaml:"driver"`
+	From   string `yaml:"from"`
+	Dir    string `yaml:"dir"`
+
+	SMTPHost     string `yaml:"smtpHost"`
+	SMTPPort     int    `yaml:"smtpPort"`
+	SMTPUsername string `yaml:"smtpUsername"`
+	SMTPPassword string `yaml:"smtpPassword"`
+
+	// VerifyEmailURL and ResetPasswordURL are the pages links in emails point
+	// to, with the token appended as ?token=. Emails hold the bare token when
+	// they are empty.
+	VerifyEmailURL   string `yaml:"verifyEmailURL"`
+	ResetPasswordURL string `yaml:"resetPasswordURL"`
+}
+
+// mailDrivers are the values MailConfig.Driver accepts.
+var mailDrivers = []string{"log", "file", "smtp"}
+
+type PurgeConfig struct {
+	Retention time.Duration `yaml:"retention"`
+	Interval  time.Duration `yaml:"interval"`
+	BatchSize int           `yaml:"batchSize"`
+}
+
+type IdempotencyConfig struct {
+	// KeyTTL is how long the response to a request with an Idempotency-Key is
+	// replayed, the key can be used for another request after that
+	KeyTTL time.Duration `yaml:"keyTTL"`
+	// LockTimeout is how long a request holds its key, a retry after that
+	// runs it again if it never finished, like when the service crashed
+	LockTimeout time.Duration `yaml:"lockTimeout"`
+	// MaxBodySize bounds the bodies, in bytes, of requests with an Idempotency-Key
+	MaxBodySize int `yaml:"maxBodySize"`
+}
+
+// FeaturesConfig switches optional parts of the service on and off.
+type FeaturesConfig struct {
+	Swagger bool `yaml:"swagger"`
+	Purge   bool `yaml:"purge"`
+	// Auth requires a valid JWT on every /v1 endpoint but /v1/ping
+	Auth bool `yaml:"auth"`
+	// AutoMigrate applies pending migrations at startup
+	AutoMigrate bool `yaml:"autoMigrate"`
+}
+
+// Bounds of the bcrypt work factor, see golang.org/x/crypto/bcrypt
+const (
+	bcryptMinCost = 4
+	bcryptMaxCost = 31
+)
+
+// sslModes are the sslmode values libpq and pgx understand.
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// Default returns the settings used for anything left unset.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:               ":8080",
+			PublicHost:         "localhost:8080",
+			HealthCheckTimeout: 2 * time.Second,
+			ShutdownTimeout:    30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			SSLMode:         "disable",
+			TimeZone:        "Asia/Jakarta",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+
+			ConnectAttempts:   10,
+			ConnectBackoff:    time.Second,
+			ConnectMaxBackoff: 30 * time.Second,
+		},
+		Auth: AuthConfig{
+			Leeway:          30 * time.Second,
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+			BcryptCost:      12,
+
+			EmailVerificationTTL: 24 * time.Hour,
+			PasswordResetTTL:     time.Hour,
+			PhoneCodeTTL:         10 * time.Minute,
+			PhoneCodeMaxAttempts: 5,
+			PhoneCodeLockout:     15 * time.Minute,
+		},
+		Mail: MailConfig{
+			Driver:   "log",
+			From:     "crud-user <noreply@localhost>",
+			Dir:      "outbox",
+			SMTPPort: 587,
+		},
+		Purge: PurgeConfig{
+			Retention: 30 * 24 * time.Hour,
+			Interval:  time.Hour,
+			BatchSize: 500,
+		},
+		Idempotency: IdempotencyConfig{
+			KeyTTL:      24 * time.Hour,
+			LockTimeout: time.Minute,
+			MaxBodySize: 1 << 20,
+		},
+		Features: FeaturesConfig{
+			Swagger:     true,
+			Purge:       true,
+			AutoMigrate: true,
+			Auth:        true,
+		},
+	}
+}
+
+// Error lists every problem found while loading the settings,
+// so they can all be fixed before the next start.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load reads the settings. file is an optional YAML or JSON file, variables from
+// .env in the working directory fill in the environment without overriding it,
+// and the environment overrides the file.
+func Load(file string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read .env: %w", err)
+	}
+	return load(file, os.LookupEnv)
+}
+
+func load(file string, lookup func(string) (string, bool)) (*Config, error) {
+	config := Default()
+
+	if file != "" {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read config file: %w", err)
+		}
+		// JSON is valid YAML, one decoder reads both
+		if err := yaml.Unmarshal(raw, &config); err != nil {
+			return nil, fmt.Errorf("parse config file %s: %w", file, err)
+		}
+	}
+
+	env := envReader{lookup: lookup}
+	env.apply(&config)
+
+	problems := append(env.problems, config.validate()...)
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+	return &config, nil
+}
+
+// validate reports every setting the service can't run with.
+func (c *Config) validate() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		add("LISTEN_ADDR is required")
+	}
+	if c.Server.HealthCheckTimeout <= 0 {
+		add("HEALTH_CHECK_TIMEOUT should be positive")
+	}
+	if c.Server.DrainDelay < 0 {
+		add("DRAIN_DELAY should not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("SHUTDOWN_TIMEOUT should be positive")
+	}
+
+	db := c.Database
+	if db.Host == "" {
+		add("HOST is required")
+	}
+	if db.Port < 1 || db.Port > 65535 {
+		add("PORT should be between 1 and 65535, got %d", db.Port)
+	}
+	if db.User == "" {
+		add("USER_DB is required")
+	}
+	if db.Name == "" {
+		add("DB_NAME is required")
+	}
+	if !contains(sslModes, db.SSLMode) {
+		add("DB_SSL_MODE should be one of %s, got %q", strings.Join(sslModes, ", "), db.SSLMode)
+	}
+	if _, err := time.LoadLocation(db.TimeZone); db.TimeZone == "" || err != nil {
+		add("DB_TIMEZONE should be an IANA time zone like Asia/Jakarta, got %q", db.TimeZone)
+	}
+	if db.MaxOpenConns < 0 {
+		add("DB_MAX_OPEN_CONNS should not be negative")
+	}
+	if db.MaxIdleConns < 0 {
+		add("DB_MAX_IDLE_CONNS should not be negative")
+	}
+	if db.MaxOpenConns > 0 && db.MaxIdleConns > db.MaxOpenConns {
+		add("DB_MAX_IDLE_CONNS should not exceed DB_MAX_OPEN_CONNS (%d)", db.MaxOpenConns)
+	}
+	if db.ConnMaxLifetime < 0 {
+		add("DB_CONN_MAX_LIFETIME should not be negative")
+	}
+	if db.ConnMaxIdleTime < 0 {
+		add("DB_CONN_MAX_IDLE_TIME should not be negative")
+	}
+	if db.ConnectAttempts < 1 {
+		add("DB_CONNECT_ATTEMPTS should be at least 1")
+	}
+	if db.ConnectBackoff <= 0 {
+		add("DB_CONNECT_BACKOFF should be positive")
+	}
+	if db.ConnectMaxBackoff < db.ConnectBackoff {
+		add("DB_CONNECT_MAX_BACKOFF should not be shorter than DB_CONNECT_BACKOFF")
+	}
+
+	if c.Features.Auth && c.Auth.HMACSecretFile == "" && c.Auth.PublicKeyFile == "" && c.Auth.JWKSFile == "" && c.Auth.PrivateKeyFile == "" {
+		add("AUTH_HS256_SECRET_FILE, AUTH_RS256_PUBLIC_KEY_FILE, AUTH_JWKS_FILE or AUTH_RS256_PRIVATE_KEY_FILE is required, set FEATURE_AUTH=false to turn authentication off")
+	}
+	if c.Auth.Leeway < 0 {
+		add("AUTH_LEEWAY should not be negative")
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		add("AUTH_ACCESS_TOKEN_TTL should be positive")
+	}
+	if c.Auth.RefreshTokenTTL < c.Auth.AccessTokenTTL {
+		add("AUTH_REFRESH_TOKEN_TTL should not be shorter than AUTH_ACCESS_TOKEN_TTL")
+	}
+	if c.Auth.BcryptCost < bcryptMinCost || c.Auth.BcryptCost > bcryptMaxCost {
+		add("AUTH_BCRYPT_COST should be between %d and %d", bcryptMinCost, bcryptMaxCost)
+	}
+	if c.Auth.EmailVerificationTTL <= 0 {
+		add("AUTH_EMAIL_VERIFICATION_TTL should be positive")
+	}
+	if c.Auth.PasswordResetTTL <= 0 {
+		add("AUTH_PASSWORD_RESET_TTL should be positive")
+	}
+	if c.Auth.PhoneCodeTTL <= 0 {
+		add("AUTH_PHONE_CODE_TTL should be positive")
+	}
+	if c.Auth.PhoneCodeMaxAttempts <= 0 {
+		add("AUTH_PHONE_CODE_MAX_ATTEMPTS should be positive")
+	}
+	if c.Auth.PhoneCodeLockout <= 0 {
+		add("AUTH_PHONE_CODE_LOCKOUT should be positive")
+	}
+
+	mail := c.Mail
+	if !contains(mailDrivers, mail.Driver) {
+		add("MAIL_DRIVER should be one of %s, got %q", strings.Join(mailDrivers, ", "), mail.Driver)
+	}
+	if mail.From == "" {
+		add("MAIL_FROM is required")
+	}
+	if mail.Driver == "file" && mail.Dir == "" {
+		add("MAIL_DIR is required when MAIL_DRIVER is file")
+	}
+	if mail.Driver == "smtp" && mail.SMTPHost == "" {
+		add("SMTP_HOST is required when MAIL_DRIVER is smtp")
+	}
+	if mail.SMTPPort < 1 || mail.SMTPPort > 65535 {
+		add("SMTP_PORT should be between 1 and 65535")
+	}
+
+	if c.Features.Purge {
+		if c.Purge.Retention <= 0 {
+			add("PURGE_RETENTION should be positive")
+		}
+		if c.Purge.Interval <= 0 {
+			add("PURGE_INTERVAL should be positive, set FEATURE_PURGE=false to turn purging off")
+		}
+		if c.Purge.BatchSize < 1 {
+			add("PURGE_BATCH_SIZE should be at least 1")
+		}
+	}
+
+	if c.Idempotency.KeyTTL <= 0 {
+		add("IDEMPOTENCY_KEY_TTL should be positive")
+	}
+	if c.Idempotency.LockTimeout <= 0 || c.Idempotency.LockTimeout > c.Idempotency.KeyTTL {
+		add("IDEMPOTENCY_LOCK_TIMEOUT should be positive and not longer than IDEMPOTENCY_KEY_TTL")
+	}
+	if c.Idempotency.MaxBodySize < 1 {
+		add("IDEMPOTENCY_MAX_BODY_SIZE should be at least 1")
+	}
+
+	return problems
+}
+
+// DSN builds the connection string for the postgres driver.
+func (db DatabaseConfig) DSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s TimeZone=%s",
+		quote(db.Host), db.Port, quote(db.User), quote(db.Password), quote(db.Name), quote(db.SSLMode), quote(db.TimeZone))
+}
+
+// quote escapes a value of a key=value connection string when it needs it.
+func quote(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
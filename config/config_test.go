@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lookupFrom(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadDefaultsAndEnv(t *testing.T) {
+	cfg, err := load("", lookupFrom(map[string]string{
+		"USER_DB":           "test_user",
+		"DB_NAME":           "crud_test",
+		"PASSWORD":          "it's secret",
+		"PORT":              "5433",
+		"DB_MAX_OPEN_CONNS": "10",
+		"PURGE_INTERVAL":    "15m",
+		"FEATURE_SWAGGER":   "false",
+
+		"IDEMPOTENCY_KEY_TTL": "48h",
+
+		"AUTH_HS256_SECRET_FILE": "/run/secrets/jwt",
+		"AUTH_BCRYPT_COST":       "10",
+		"MAIL_DRIVER":            "smtp",
+		"SMTP_HOST":              "smtp.example.com",
+
+		"MAIL_RESET_PASSWORD_URL": "https://app.example.com/reset-password",
+	}))
+	assert.NoError(t, err)
+
+	assert.Equal(t, ":8080", cfg.Server.Addr)
+	assert.Equal(t, 5433, cfg.Database.Port)
+	assert.Equal(t, 10, cfg.Database.MaxOpenConns)
+	assert.Equal(t, 15*time.Minute, cfg.Purge.Interval)
+	assert.Equal(t, 30*24*time.Hour, cfg.Purge.Retention)
+	assert.False(t, cfg.Features.Swagger)
+	assert.True(t, cfg.Features.Purge)
+	assert.Equal(t, 10, cfg.Auth.BcryptCost)
+	assert.Equal(t, 15*time.Minute, cfg.Auth.AccessTokenTTL)
+	assert.Equal(t, 5, cfg.Auth.PhoneCodeMaxAttempts)
+	assert.Equal(t, "smtp.example.com", cfg.Mail.SMTPHost)
+	assert.Equal(t, 587, cfg.Mail.SMTPPort)
+	assert.Equal(t, "https://app.example.com/reset-password", cfg.Mail.ResetPasswordURL)
+	assert.Equal(t, time.Hour, cfg.Auth.PasswordResetTTL)
+	assert.Equal(t, 48*time.Hour, cfg.Idempotency.KeyTTL)
+	assert.Equal(t, time.Minute, cfg.Idempotency.LockTimeout)
+	assert.Equal(t, 1<<20, cfg.Idempotency.MaxBodySize)
+	assert.Equal(t, `host=localhost port=5433 user=test_user password='it\'s secret' dbname=crud_test sslmode=disable TimeZone=Asia/Jakarta`, cfg.Database.DSN())
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", `
+server:
+  addr: ":9000"
+database:
+  host: db
+  user: app
+  name: users
+  sslMode: require
+  connMaxLifetime: 1h
+auth:
+  jwksFile: /etc/crud-user/jwks.json
+purge:
+  batchSize: 50
+`)
+	jsonFile := writeFile(t, "config.json", `{"server": {"addr": ":9000"}, "database": {"host": "db", "user": "app", "name": "users", "sslMode": "require", "connMaxLifetime": "1h"}, "auth": {"jwksFile": "/etc/crud-user/jwks.json"}, "purge": {"batchSize": 50}}`)
+
+	for _, file := range []string{yamlFile, jsonFile} {
+		cfg, err := load(file, lookupFrom(map[string]string{"HOST": "primary"}))
+		assert.NoError(t, err, file)
+
+		assert.Equal(t, ":9000", cfg.Server.Addr)
+		assert.Equal(t, "primary", cfg.Database.Host)
+		assert.Equal(t, "require", cfg.Database.SSLMode)
+		assert.Equal(t, time.Hour, cfg.Database.ConnMaxLifetime)
+		assert.Equal(t, 50, cfg.Purge.BatchSize)
+		assert.Equal(t, "/etc/crud-user/jwks.json", cfg.Auth.JWKSFile)
+		// Untouched settings keep their default
+		assert.Equal(t, 25, cfg.Database.MaxOpenConns)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	_, err := load("", lookupFrom(map[string]string{
+		"PORT":              "five",
+		"DB_SSL_MODE":       "sometimes",
+		"DB_TIMEZONE":       "Mars/Olympus",
+		"DB_MAX_OPEN_CONNS": "2",
+		"DB_MAX_IDLE_CONNS": "4",
+		"PURGE_INTERVAL":    "0",
+		"FEATURE_PURGE":     "yes please",
+		"AUTH_BCRYPT_COST":  "40",
+		"MAIL_DRIVER":       "pigeon",
+
+		"AUTH_PHONE_CODE_MAX_ATTEMPTS": "0",
+		"IDEMPOTENCY_KEY_TTL":          "-1h",
+		"IDEMPOTENCY_MAX_BODY_SIZE":    "0",
+	}))
+
+	var cfgErr *Error
+	assert.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, []string{
+		`PORT should be a whole number, got "five"`,
+		`FEATURE_PURGE should be true or false, got "yes please"`,
+		"USER_DB is required",
+		"DB_NAME is required",
+		`DB_SSL_MODE should be one of disable, allow, prefer, require, verify-ca, verify-full, got "sometimes"`,
+		`DB_TIMEZONE should be an IANA time zone like Asia/Jakarta, got "Mars/Olympus"`,
+		"DB_MAX_IDLE_CONNS should not exceed DB_MAX_OPEN_CONNS (2)",
+		"AUTH_HS256_SECRET_FILE, AUTH_RS256_PUBLIC_KEY_FILE, AUTH_JWKS_FILE or AUTH_RS256_PRIVATE_KEY_FILE is required, set FEATURE_AUTH=false to turn authentication off",
+		"AUTH_BCRYPT_COST should be between 4 and 31",
+		"AUTH_PHONE_CODE_MAX_ATTEMPTS should be positive",
+		`MAIL_DRIVER should be one of log, file, smtp, got "pigeon"`,
+		"PURGE_INTERVAL should be positive, set FEATURE_PURGE=false to turn purging off",
+		"IDEMPOTENCY_KEY_TTL should be positive",
+		"IDEMPOTENCY_LOCK_TIMEOUT should be positive and not longer than IDEMPOTENCY_KEY_TTL",
+		"IDEMPOTENCY_MAX_BODY_SIZE should be at least 1",
+	}, cfgErr.Problems)
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	_, err := load(filepath.Join(t.TempDir(), "missing.yaml"), lookupFrom(nil))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = load(writeFile(t, "config.yaml", "database: ["), lookupFrom(nil))
+	assert.ErrorContains(t, err, "parse config file")
+}
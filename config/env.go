@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// envReader overrides settings with environment variables,
+// collecting the ones that can't be parsed instead of stopping at the first.
+type envReader struct {
+	lookup   func(string) (string, bool)
+	problems []string
+}
+
+func (env *envReader) apply(c *Config) {
+	env.str("LISTEN_ADDR", &c.Server.Addr)
+	env.str("PUBLIC_HOST", &c.Server.PublicHost)
+	env.duration("HEALTH_CHECK_TIMEOUT", &c.Server.HealthCheckTimeout)
+	env.duration("DRAIN_DELAY", &c.Server.DrainDelay)
+	env.duration("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+
+	// The database variables keep the names the service has always used
+	env.str("HOST", &c.Database.Host)
+	env.integer("PORT", &c.Database.Port)
+	env.str("USER_DB", &c.Database.User)
+	env.str("PASSWORD", &c.Database.Password)
+	env.str("DB_NAME", &c.Database.Name)
+	env.str("DB_SSL_MODE", &c.Database.SSLMode)
+	env.str("DB_TIMEZONE", &c.Database.TimeZone)
+	env.integer("DB_MAX_OPEN_CONNS", &c.Database.MaxOpenConns)
+	env.integer("DB_MAX_IDLE_CONNS", &c.Database.MaxIdleConns)
+	env.duration("DB_CONN_MAX_LIFETIME", &c.Database.ConnMaxLifetime)
+	env.duration("DB_CONN_MAX_IDLE_TIME", &c.Database.ConnMaxIdleTime)
+	env.integer("DB_CONNECT_ATTEMPTS", &c.Database.ConnectAttempts)
+	env.duration("DB_CONNECT_BACKOFF", &c.Database.ConnectBackoff)
+	env.duration("DB_CONNECT_MAX_BACKOFF", &c.Database.ConnectMaxBackoff)
+
+	env.str("AUTH_HS256_SECRET_FILE", &c.Auth.HMACSecretFile)
+	env.str("AUTH_RS256_PUBLIC_KEY_FILE", &c.Auth.PublicKeyFile)
+	env.str("AUTH_JWKS_FILE", &c.Auth.JWKSFile)
+	env.str("AUTH_RS256_PRIVATE_KEY_FILE", &c.Auth.PrivateKeyFile)
+	env.str("AUTH_KEY_ID", &c.Auth.KeyID)
+	env.str("AUTH_ISSUER", &c.Auth.Issuer)
+	env.str("AUTH_AUDIENCE", &c.Auth.Audience)
+	env.duration("AUTH_LEEWAY", &c.Auth.Leeway)
+	env.duration("AUTH_ACCESS_TOKEN_TTL", &c.Auth.AccessTokenTTL)
+	env.duration("AUTH_REFRESH_TOKEN_TTL", &c.Auth.RefreshTokenTTL)
+	env.integer("AUTH_BCRYPT_COST", &c.Auth.BcryptCost)
+	env.duration("AUTH_EMAIL_VERIFICATION_TTL", &c.Auth.EmailVerificationTTL)
+	env.duration("AUTH_PASSWORD_RESET_TTL", &c.Auth.PasswordResetTTL)
+	env.duration("AUTH_PHONE_CODE_TTL", &c.Auth.PhoneCodeTTL)
+	env.integer("AUTH_PHONE_CODE_MAX_ATTEMPTS", &c.Auth.PhoneCodeMaxAttempts)
+	env.duration("AUTH_PHONE_CODE_LOCKOUT", &c.Auth.PhoneCodeLockout)
+
+	env.str("MAIL_DRIVER", &c.Mail.Driver)
+	env.str("MAIL_FROM", &c.Mail.From)
+	env.str("MAIL_DIR", &c.Mail.Dir)
+	env.str("MAIL_VERIFY_EMAIL_URL", &c.Mail.VerifyEmailURL)
+	env.str("MAIL_RESET_PASSWORD_URL", &c.Mail.ResetPasswordURL)
+	env.str("SMTP_HOST", &c.Mail.SMTPHost)
+	env.integer("SMTP_PORT", &c.Mail.SMTPPort)
+	env.str("SMTP_USERNAME", &c.Mail.SMTPUsername)
+	env.str("SMTP_PASSWORD", &c.Mail.SMTPPassword)
+
+	env.duration("PURGE_RETENTION", &c.Purge.Retention)
+	env.duration("PURGE_INTERVAL", &c.Purge.Interval)
+	env.integer("PURGE_BATCH_SIZE", &c.Purge.BatchSize)
+
+	env.duration("IDEMPOTENCY_KEY_TTL", &c.Idempotency.KeyTTL)
+	env.duration("IDEMPOTENCY_LOCK_TIMEOUT", &c.Idempotency.LockTimeout)
+	env.integer("IDEMPOTENCY_MAX_BODY_SIZE", &c.Idempotency.MaxBodySize)
+
+	env.boolean("FEATURE_SWAGGER", &c.Features.Swagger)
+	env.boolean("FEATURE_PURGE", &c.Features.Purge)
+	env.boolean("FEATURE_AUTO_MIGRATE", &c.Features.AutoMigrate)
+	env.boolean("FEATURE_AUTH", &c.Features.Auth)
+}
+
+func (env *envReader) str(name string, target *string) {
+	if value, ok := env.lookup(name); ok {
+		*target = value
+	}
+}
+
+func (env *envReader) integer(name string, target *int) {
+	value, ok := env.lookup(name)
+	if !ok || value == "" {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		env.problems = append(env.problems, fmt.Sprintf("%s should be a whole number, got %q", name, value))
+		return
+	}
+	*target = n
+}
+
+func (env *envReader) duration(name string, target *time.Duration) {
+	value, ok := env.lookup(name)
+	if !ok || value == "" {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		env.problems = append(env.problems, fmt.Sprintf("%s should be a duration like 90s or 1h30m, got %q", name, value))
+		return
+	}
+	*target = d
+}
+
+func (env *envReader) boolean(name string, target *bool) {
+	value, ok := env.lookup(name)
+	if !ok || value == "" {
+		return
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		env.problems = append(env.problems, fmt.Sprintf("%s should be true or false, got %q", name, value))
+		return
+	}
+	*target = b
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"crud/user/policy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Purger removes users that stayed in the trash past the retention window.
+type Purger interface {
+	RunOnce(ctx context.Context) (int64, error)
+}
+
+// PurgeResult reports a manual purge.
+type PurgeResult struct {
+	Purged int64 `json:"purged" example:"42"`
+}
+
+type AdminController struct {
+	purger Purger
+}
+
+func NewAdminController(purger Purger) *AdminController {
+	return &AdminController{purger: purger}
+}
+
+// PurgeDeletedUsers godoc
+// @Summary      Purge expired soft-deleted users now
+// @Description  hard-delete users deleted longer ago than the retention window, without waiting for the schedule
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  controllers.PurgeResult
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/admin/purge [post]
+func (ctl *AdminController) PurgeDeletedUsers(c *gin.Context) {
+	if !authorize(c, policy.PurgeUsers, 0) {
+		return
+	}
+
+	purged, err := ctl.purger.RunOnce(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": PurgeResult{Purged: purged}})
+}
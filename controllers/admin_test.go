@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type purgerFunc func(ctx context.Context) (int64, error)
+
+func (f purgerFunc) RunOnce(ctx context.Context) (int64, error) {
+	return f(ctx)
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	calls := 0
+	ctl := NewAdminController(purgerFunc(func(ctx context.Context) (int64, error) {
+		calls++
+		if calls > 1 {
+			return 0, repository.ErrUnavailable
+		}
+		return 3, nil
+	}))
+	r := gin.New()
+	r.Use(Authenticate(testTokens, nil))
+	r.POST("/v1/admin/purge", ctl.PurgeDeletedUsers)
+
+	// Only administrators
+	req, _ := http.NewRequest("POST", "/v1/admin/purge", nil)
+	req.Header.Set("Authorization", "Bearer operator")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, 0, calls)
+
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]PurgeResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, int64(3), response["data"].Purged)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"crud/user/apikey"
+	"crud/user/models"
+	"crud/user/policy"
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateAPIKeyInput struct {
+	Name   string   `json:"name" binding:"required,max=100" example:"nightly-export"`
+	Scopes []string `json:"scopes" binding:"required,min=1,dive,scope" example:"users:read"`
+}
+
+// CreatedAPIKey is an API key with the key itself, which is only shown once.
+type CreatedAPIKey struct {
+	models.APIKey
+	Key string `json:"key" example:"cuk_3f9a1c0d7b2e_VGhpcyBpcyBub3QgYSByZWFsIGtleSwgaXQncyBhbiBleA"`
+}
+
+type APIKeyController struct {
+	manager *apikey.Manager
+	keys    repository.APIKeyRepository
+}
+
+func NewAPIKeyController(manager *apikey.Manager, keys repository.APIKeyRepository) *APIKeyController {
+	return &APIKeyController{manager: manager, keys: keys}
+}
+
+// ListAPIKeys godoc
+// @Summary      List API keys
+// @Description  list every API key, revoked ones included. Only their prefix is shown.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.APIKey
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/admin/api-keys [get]
+func (ctl *APIKeyController) ListAPIKeys(c *gin.Context) {
+	if !authorize(c, policy.ManageAPIKeys, 0) {
+		return
+	}
+
+	keys, err := ctl.keys.List(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+// CreateAPIKey godoc
+// @Summary      Create API key
+// @Description  issue an API key for a service, sent in the X-API-Key header. The key is only shown in this response.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param 			 request body controllers.CreateAPIKeyInput true "body"
+// @Security     BearerAuth
+// @Success      200  {object}  controllers.CreatedAPIKey
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/admin/api-keys [post]
+func (ctl *APIKeyController) CreateAPIKey(c *gin.Context) {
+	if !authorize(c, policy.ManageAPIKeys, 0) {
+		return
+	}
+	var input CreateAPIKeyInput
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	secret, key, err := ctl.manager.Create(c.Request.Context(), input.Name, input.Scopes)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": CreatedAPIKey{APIKey: *key, Key: secret}})
+}
+
+// RotateAPIKey godoc
+// @Summary      Rotate API key
+// @Description  replace an API key by a new one with the same name and scopes, the old key stops working at once
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "API key ID"
+// @Security     BearerAuth
+// @Success      200  {object}  controllers.CreatedAPIKey
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/admin/api-keys/{id}/rotate [post]
+func (ctl *APIKeyController) RotateAPIKey(c *gin.Context) {
+	if !authorize(c, policy.ManageAPIKeys, 0) {
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, repository.ErrNotFound)
+		return
+	}
+
+	secret, key, err := ctl.manager.Rotate(c.Request.Context(), uint(id))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": CreatedAPIKey{APIKey: *key, Key: secret}})
+}
+
+// RevokeAPIKey godoc
+// @Summary      Revoke API key
+// @Description  stop an API key from working, it stays listed
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "API key ID"
+// @Security     BearerAuth
+// @Success      200  {object}  boolean
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/admin/api-keys/{id} [delete]
+func (ctl *APIKeyController) RevokeAPIKey(c *gin.Context) {
+	if !authorize(c, policy.ManageAPIKeys, 0) {
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, repository.ErrNotFound)
+		return
+	}
+
+	if err := ctl.keys.Revoke(c.Request.Context(), uint(id)); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"crud/user/apikey"
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useValidators()
+	keys := repository.NewMemoryAPIKeyRepository()
+	manager := apikey.NewManager(keys)
+	ctl := NewAPIKeyController(manager, keys)
+	users := NewUserController(repository.NewMemoryUserRepository(), repository.NewMemorySessionRepository(), testPasswords, nil)
+
+	r := gin.New()
+	r.Use(Authenticate(testTokens, manager))
+	r.GET("/v1/admin/api-keys", ctl.ListAPIKeys)
+	r.POST("/v1/admin/api-keys", ctl.CreateAPIKey)
+	r.POST("/v1/admin/api-keys/:id/rotate", ctl.RotateAPIKey)
+	r.DELETE("/v1/admin/api-keys/:id", ctl.RevokeAPIKey)
+	r.GET("/v1/users", users.FindUsers)
+	r.PATCH("/v1/users/:id", users.UpdateUser)
+	r.DELETE("/v1/users/:id", users.DeleteUser)
+	r.POST("/v1/users/:id/restore", users.RestoreUser)
+
+	request := func(method, path string, header http.Header, body interface{}) *httptest.ResponseRecorder {
+		return sendJSONWithHeader(r, method, path, header, body)
+	}
+	admin := http.Header{"Authorization": {"Bearer admin"}}
+	withKey := func(key string) http.Header {
+		header := http.Header{}
+		header.Set(APIKeyHeader, key)
+		return header
+	}
+	var created struct {
+		Data CreatedAPIKey `json:"data"`
+	}
+
+	// Only administrators manage keys
+	operator := http.Header{"Authorization": {"Bearer operator"}}
+	assert.Equal(t, http.StatusForbidden, request("POST", "/v1/admin/api-keys", operator, CreateAPIKeyInput{Name: "export", Scopes: []string{"users:read"}}).Code)
+
+	w := request("POST", "/v1/admin/api-keys", admin, CreateAPIKeyInput{Name: "export", Scopes: []string{"users:read", "users:admin"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"scopes[1]","code":"unknown_scope"`)
+
+	w = request("POST", "/v1/admin/api-keys", admin, CreateAPIKeyInput{Name: "export", Scopes: []string{"users:read"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "export", created.Data.Name)
+	assert.NotContains(t, w.Body.String(), "keyHash")
+	readKey := created.Data.Key
+
+	// The key reads users but can't change them
+	assert.Equal(t, http.StatusOK, request("GET", "/v1/users", withKey(readKey), nil).Code)
+	assert.Equal(t, http.StatusForbidden, request("PATCH", "/v1/users/1", withKey(readKey), UpdateUserInput{Name: "test"}).Code)
+	assert.Equal(t, http.StatusForbidden, request("GET", "/v1/admin/api-keys", withKey(readKey), nil).Code)
+
+	w = request("GET", "/v1/admin/api-keys", admin, nil)
+	var listed struct {
+		Data []models.APIKey `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed.Data, 1)
+	assert.NotNil(t, listed.Data[0].LastUsedAt)
+
+	// Rotating retires the old key
+	w = request("POST", "/v1/admin/api-keys/1/rotate", admin, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/v1/users", withKey(readKey), nil).Code)
+	assert.Equal(t, http.StatusOK, request("GET", "/v1/users", withKey(created.Data.Key), nil).Code)
+
+	assert.Equal(t, http.StatusOK, request("DELETE", "/v1/admin/api-keys/1", admin, nil).Code)
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "/v1/admin/api-keys/1", admin, nil).Code)
+	assert.Equal(t, http.StatusNotFound, request("POST", "/v1/admin/api-keys/1/rotate", admin, nil).Code)
+	w = request("GET", "/v1/users", withKey(created.Data.Key), nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"code":401,"error":"invalid_api_key","message":"the API key is invalid or revoked"}`, w.Body.String())
+
+	keysLeft, _ := keys.List(context.Background())
+	assert.NotNil(t, keysLeft[0].RevokedAt)
+
+	// Writing users doesn't extend to deleting or restoring them, that is for administrators
+	w = request("POST", "/v1/admin/api-keys", admin, CreateAPIKeyInput{Name: "sync", Scopes: []string{"users:write"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, http.StatusForbidden, request("DELETE", "/v1/users/1", withKey(created.Data.Key), nil).Code)
+	assert.Equal(t, http.StatusForbidden, request("POST", "/v1/users/1/restore", withKey(created.Data.Key), nil).Code)
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"crud/user/apikey"
+	"crud/user/audit"
+	"crud/user/auth"
+	"crud/user/models"
+	"crud/user/policy"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	claimsContextKey    = "claims"
+	principalContextKey = "principal"
+)
+
+// authRealm is announced in WWW-Authenticate.
+const authRealm = "crud-user"
+
+// APIKeyHeader carries the API key of services calling without a JWT.
+const APIKeyHeader = "X-API-Key"
+
+// TokenVerifier checks a bearer token and returns its claims.
+type TokenVerifier interface {
+	Verify(token string) (*auth.Claims, error)
+}
+
+// KeyVerifier checks an API key and returns it, see apikey.Manager.
+type KeyVerifier interface {
+	Verify(ctx context.Context, key string) (*models.APIKey, error)
+}
+
+// Authenticate requires a valid JWT in the Authorization header and exposes
+// its claims and the principal they describe to the handlers, see Claims.
+// Unless keys is nil, an API key in the X-API-Key header is accepted instead,
+// its principal holds the scopes of the key. Anything else is answered with 401.
+func Authenticate(verifier TokenVerifier, keys KeyVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader(APIKeyHeader); key != "" && keys != nil {
+			authenticateKey(c, keys, key)
+			return
+		}
+
+		scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="`+authRealm+`"`)
+			abortWithStatus(c, http.StatusUnauthorized, "unauthorized", errors.New("a bearer token is required"))
+			return
+		}
+
+		claims, err := verifier.Verify(strings.TrimSpace(token))
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="`+authRealm+`", error="invalid_token"`)
+			abortWithStatus(c, http.StatusUnauthorized, "invalid_token", errors.New("the bearer token is invalid or expired"))
+			return
+		}
+
+		c.Set(claimsContextKey, claims)
+		setPrincipal(c, principalFromClaims(claims), claims.Subject)
+		c.Next()
+	}
+}
+
+func authenticateKey(c *gin.Context, keys KeyVerifier, key string) {
+	apiKey, err := keys.Verify(c.Request.Context(), key)
+	if errors.Is(err, apikey.ErrInvalidKey) {
+		abortWithStatus(c, http.StatusUnauthorized, "invalid_api_key", err)
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	principal := policy.Principal{APIKeyID: apiKey.ID}
+	for _, scope := range apiKey.Scopes {
+		principal.Scopes = append(principal.Scopes, policy.Scope(scope))
+	}
+	setPrincipal(c, principal, "")
+	c.Next()
+}
+
+// AnonymousAdmin treats every request as coming from an administrator.
+// It stands in for Authenticate when authentication is turned off for local development.
+func AnonymousAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setPrincipal(c, policy.Principal{Roles: []policy.Role{policy.Admin}}, "")
+		c.Next()
+	}
+}
+
+// setPrincipal exposes the caller to the handlers, and makes it the actor of
+// the changes the request makes. Subject is that of the caller's token, if any.
+func setPrincipal(c *gin.Context, principal policy.Principal, subject string) {
+	c.Set(principalContextKey, principal)
+
+	actor := audit.Anonymous
+	switch {
+	case principal.APIKeyID != 0:
+		actor = audit.APIKeyActor(principal.APIKeyID)
+	case principal.UserID != 0:
+		actor = audit.UserActor(principal.UserID)
+	case subject != "":
+		actor = audit.ClientActor(subject)
+	}
+	c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), actor))
+}
+
+// principalFromClaims maps a token to the caller it was issued to. A subject that
+// isn't a user ID, like a client ID, makes a principal that owns no user record.
+func principalFromClaims(claims *auth.Claims) policy.Principal {
+	var principal policy.Principal
+	if id, err := strconv.ParseUint(claims.Subject, 10, 64); err == nil {
+		principal.UserID = uint(id)
+	}
+	for _, role := range claims.Roles {
+		principal.Roles = append(principal.Roles, policy.Role(role))
+	}
+	return principal
+}
+
+// authorize aborts with 403 and returns false unless the caller may perform action
+// on the user with the target ID, see policy.Authorize.
+func authorize(c *gin.Context, action policy.Action, target uint) bool {
+	principal, ok := c.Get(principalContextKey)
+	if !ok {
+		// Only reachable when a route misses its authentication middleware
+		abortWithStatus(c, http.StatusUnauthorized, "unauthorized", errors.New("a bearer token is required"))
+		return false
+	}
+	if err := policy.Authorize(principal.(policy.Principal), action, target); err != nil {
+		abortWithStatus(c, http.StatusForbidden, "forbidden", errors.New("you are not allowed to do this"))
+		return false
+	}
+	return true
+}
+
+// Claims returns the claims of the token the request was authenticated with.
+func Claims(c *gin.Context) (*auth.Claims, bool) {
+	claims, ok := c.Get(claimsContextKey)
+	if !ok {
+		return nil, false
+	}
+	typed, ok := claims.(*auth.Claims)
+	return typed, ok
+}
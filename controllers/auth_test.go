@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"crud/user/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type verifierFunc func(token string) (*auth.Claims, error)
+
+func (f verifierFunc) Verify(token string) (*auth.Claims, error) {
+	return f(token)
+}
+
+func TestAuthenticate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	verifier := verifierFunc(func(token string) (*auth.Claims, error) {
+		if token != "good" {
+			return nil, errors.New("token is malformed")
+		}
+		return &auth.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "7"}}, nil
+	})
+	r := gin.New()
+	r.Use(Authenticate(verifier, nil))
+	r.GET("/v1/users", func(c *gin.Context) {
+		claims, ok := Claims(c)
+		assert.True(t, ok)
+		c.String(http.StatusOK, claims.Subject)
+	})
+
+	request := func(authorization string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/v1/users", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="crud-user"`, w.Header().Get("WWW-Authenticate"))
+
+	w = request("Basic dXNlcjpwYXNz")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = request("Bearer bad")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="crud-user", error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+	assert.JSONEq(t, `{"code":401,"error":"invalid_token","message":"the bearer token is invalid or expired"}`, w.Body.String())
+
+	w = request("Bearer good")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "7", w.Body.String())
+}
+
+func TestAuthenticateWithoutAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Authenticate(testTokens, nil))
+	r.GET("/v1/users", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// API keys are only accepted when the service checks them
+	req, _ := http.NewRequest("GET", "/v1/users", nil)
+	req.Header.Set(APIKeyHeader, "cuk_3f9a1c0d7b2e_secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "a bearer token is required")
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorResponse is the JSON body of every failed request.
+// Error is a machine-readable code, Message is meant for humans.
+type ErrorResponse struct {
+	Code    int    `json:"code" example:"400"`
+	Error   string `json:"error" example:"bad_request"`
+	Message string `json:"message" example:"status bad request"`
+	Field   string `json:"field,omitempty" example:"email"`
+	// Fields lists every invalid field when the request body fails validation
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// abortWithStatus writes an ErrorResponse with the given status and stops the handler chain.
+func abortWithStatus(c *gin.Context, status int, code string, err error) {
+	c.AbortWithStatusJSON(status, ErrorResponse{
+		Code:    status,
+		Error:   code,
+		Message: err.Error(),
+	})
+}
+
+// abortWithBadRequest reports input the client has to fix.
+func abortWithBadRequest(c *gin.Context, err error) {
+	abortWithStatus(c, http.StatusBadRequest, "bad_request", err)
+}
+
+// abortWithError translates an error returned by a repository into the matching response.
+// Unexpected errors are logged and answered with a generic 500 so internals don't leak.
+func abortWithError(c *gin.Context, err error) {
+	var conflict *repository.ConflictError
+	var invalid *repository.InvalidQueryError
+
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		abortWithStatus(c, http.StatusNotFound, "not_found", err)
+	case errors.As(err, &conflict):
+		c.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{
+			Code:    http.StatusConflict,
+			Error:   "conflict",
+			Message: conflict.Error(),
+			Field:   conflict.Field,
+		})
+	case errors.Is(err, repository.ErrVersionMismatch):
+		abortWithStatus(c, http.StatusConflict, "version_conflict", err)
+	case errors.As(err, &invalid):
+		abortWithBadRequest(c, err)
+	case errors.Is(err, repository.ErrTimeout):
+		_ = c.Error(err)
+		abortWithStatus(c, http.StatusServiceUnavailable, "database_timeout", errors.New("the database did not respond in time, try again later"))
+	case errors.Is(err, repository.ErrUnavailable):
+		_ = c.Error(err)
+		abortWithStatus(c, http.StatusServiceUnavailable, "database_unavailable", errors.New("the database is unavailable, try again later"))
+	default:
+		_ = c.Error(err)
+		abortWithStatus(c, http.StatusInternalServerError, "internal_error", errors.New("internal server error"))
+	}
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"crud/user/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userETag is the entity tag of the current version of user.
+func userETag(user *models.User) string {
+	return `"` + strconv.FormatUint(uint64(user.Version), 10) + `"`
+}
+
+// checkIfMatch aborts with 412 and returns false when the request has an
+// If-Match header that doesn't name the current version of user.
+func checkIfMatch(c *gin.Context, user *models.User) bool {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch != "" && !matchesETag(ifMatch, userETag(user), false) {
+		abortWithPreconditionFailed(c)
+		return false
+	}
+	return true
+}
+
+// abortWithPreconditionFailed answers an If-Match naming a version of the user
+// that isn't the current one anymore.
+func abortWithPreconditionFailed(c *gin.Context) {
+	abortWithStatus(c, http.StatusPreconditionFailed, "precondition_failed",
+		errors.New("the user was changed since you read it, read it again and retry"))
+}
+
+// matchesETag reports whether the If-Match or If-None-Match header value lists
+// etag or is "*". If-None-Match compares weakly, ignoring W/ prefixes, If-Match
+// strongly, so a weak tag never matches there.
+func matchesETag(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchesETag(t *testing.T) {
+	assert.True(t, matchesETag(`"2"`, `"2"`, false))
+	assert.True(t, matchesETag(`"1", "2"`, `"2"`, false))
+	assert.True(t, matchesETag(`*`, `"2"`, false))
+	assert.False(t, matchesETag(`"1"`, `"2"`, false))
+	assert.False(t, matchesETag(``, `"2"`, false))
+	// Weak tags only match when compared weakly
+	assert.False(t, matchesETag(`W/"2"`, `"2"`, false))
+	assert.True(t, matchesETag(`W/"2"`, `"2"`, true))
+}
+
+func (suite *UserTestSuite) TestFindUserNotModified() {
+	suite.seedUser()
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/v1/users/1", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		suite.r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("")
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Equal(suite.T(), `"1"`, etag)
+
+	w = get(etag)
+	assert.Equal(suite.T(), http.StatusNotModified, w.Code)
+	assert.Empty(suite.T(), w.Body.String())
+	assert.Equal(suite.T(), etag, w.Header().Get("ETag"))
+
+	w = patchJSON(suite.r, "/v1/users/1", UpdateUserInput{Name: "test 2"})
+	assert.Equal(suite.T(), `"2"`, w.Header().Get("ETag"))
+
+	w = get(etag)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), `"2"`, w.Header().Get("ETag"))
+}
+
+func (suite *UserTestSuite) TestUpdateUserIfMatch() {
+	suite.seedUser()
+
+	patch := func(ifMatch, name string) *httptest.ResponseRecorder {
+		header := http.Header{}
+		header.Set("If-Match", ifMatch)
+		return sendJSONWithHeader(suite.r, "PATCH", "/v1/users/1", header, UpdateUserInput{Name: name})
+	}
+
+	// Both operators read version 1, the second one to write loses
+	w := patch(`"1"`, "first")
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), `"2"`, w.Header().Get("ETag"))
+	var response map[string]models.User
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(suite.T(), uint(2), response["data"].Version)
+
+	w = patch(`"1"`, "second")
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), "precondition_failed")
+	stored, _ := suite.repo.Find(context.Background(), 1)
+	assert.Equal(suite.T(), "first", stored.Name)
+
+	w = patch(`"2"`, "second")
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+}
+
+// staleRepository reads users as they were before their last update, like a
+// request losing the race against another one.
+type staleRepository struct {
+	*repository.MemoryUserRepository
+}
+
+func (r staleRepository) Find(ctx context.Context, id uint) (*models.User, error) {
+	user, err := r.MemoryUserRepository.Find(ctx, id)
+	if err == nil {
+		user.Version--
+	}
+	return user, err
+}
+
+func TestUpdateUserLosingTheRace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useValidators()
+	users := repository.NewMemoryUserRepository()
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, users.Create(context.Background(), &user))
+	assert.NoError(t, users.Update(context.Background(), &user))
+
+	ctl := NewUserController(staleRepository{users}, repository.NewMemorySessionRepository(), testPasswords, nil)
+	r := gin.New()
+	r.Use(AnonymousAdmin())
+	r.PATCH("/v1/users/:id", ctl.UpdateUser)
+
+	header := http.Header{}
+	header.Set("If-Match", `"1"`)
+	w := sendJSONWithHeader(r, "PATCH", "/v1/users/1", header, UpdateUserInput{Name: "late"})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = patchJSON(r, "/v1/users/1", UpdateUserInput{Name: "late"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "version_conflict")
+}
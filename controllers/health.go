@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"crud/user/health"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthResponse is the body of the probes. Dependencies is only filled by /readyz.
+type HealthResponse struct {
+	Status       string                      `json:"status" example:"ready"`
+	Dependencies map[string]DependencyStatus `json:"dependencies,omitempty"`
+}
+
+// DependencyStatus is the last known condition of one dependency. Why it
+// isn't ready is only logged, errors may tell too much about the internals.
+type DependencyStatus struct {
+	Ready bool `json:"ready" example:"true"`
+}
+
+type HealthController struct {
+	readiness *health.Readiness
+	timeout   time.Duration
+}
+
+func NewHealthController(readiness *health.Readiness, timeout time.Duration) *HealthController {
+	return &HealthController{readiness: readiness, timeout: timeout}
+}
+
+// Liveness godoc
+// @Summary      Liveness probe
+// @Description  answers as long as the process can serve requests, whatever the state of its dependencies
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  controllers.HealthResponse
+// @Router       /healthz [get]
+func (ctl *HealthController) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// Readiness godoc
+// @Summary      Readiness probe
+// @Description  checks the database, the schema and the server itself, answering 503 when any of them can't take traffic
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  controllers.HealthResponse
+// @Failure      503  {object}  controllers.HealthResponse
+// @Router       /readyz [get]
+func (ctl *HealthController) Readiness(c *gin.Context) {
+	ready, states := ctl.readiness.Check(c.Request.Context(), ctl.timeout)
+
+	response := HealthResponse{Status: "ready", Dependencies: map[string]DependencyStatus{}}
+	for name, state := range states {
+		response.Dependencies[name] = DependencyStatus{Ready: state.Ready}
+		if !state.Ready {
+			_ = c.Error(fmt.Errorf("%s not ready since %s: %s", name, state.Since.Format(time.RFC3339), state.Message))
+		}
+	}
+
+	status := http.StatusOK
+	if !ready {
+		response.Status = "not_ready"
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, response)
+}
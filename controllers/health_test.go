@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"crud/user/health"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthProbes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var dbErr error
+	readiness := health.NewReadiness()
+	readiness.Serving()
+	readiness.AddCheck("database", func(ctx context.Context) error { return dbErr })
+
+	ctl := NewHealthController(readiness, time.Second)
+	r := gin.New()
+	r.GET("/healthz", ctl.Liveness)
+
+	var logged []string
+	r.Use(func(c *gin.Context) {
+		c.Next()
+		logged = append(logged, c.Errors.Errors()...)
+	})
+	r.GET("/readyz", ctl.Readiness)
+
+	probe := func(path string) (int, HealthResponse) {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response HealthResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	code, response := probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", response.Status)
+	assert.Equal(t, []string{"database", "server"}, keys(response.Dependencies))
+
+	// The database going away fails readiness only
+	dbErr = errors.New("connection refused")
+	code, response = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not_ready", response.Status)
+	assert.Equal(t, map[string]DependencyStatus{"database": {Ready: false}, "server": {Ready: true}}, response.Dependencies)
+	// Only the log tells why
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.NotContains(t, w.Body.String(), "connection refused")
+	assert.Len(t, logged, 2)
+	assert.Contains(t, logged[0], "database not ready since")
+	assert.Contains(t, logged[0], "connection refused")
+
+	code, response = probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", response.Status)
+}
+
+func keys(m map[string]DependencyStatus) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
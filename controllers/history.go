@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"net/http"
+
+	"crud/user/policy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FindUserHistory godoc
+// @Summary      Audit log of a user, paginated with a cursor
+// @Description  list every change made to a user, newest first: who made it, from which request, and each changed field before and after. The log outlives the user.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        id      path      int     true   "User ID"
+// @Param        limit   query     int     false  "Page size (1-100)"  default(20)
+// @Param        cursor  query     string  false  "nextCursor of a previous page"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Success      200  {object}  controllers.HistoryResponse
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id}/history [get]
+func (ctl *UserController) FindUserHistory(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.ReadUserHistory, id) {
+		return
+	}
+
+	query, err := parseHistoryQuery(c)
+	if err != nil {
+		abortWithBadRequest(c, err)
+		return
+	}
+
+	page, err := ctl.users.History(c.Request.Context(), id, *query)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newHistoryResponse(page))
+}
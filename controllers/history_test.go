@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"crud/user/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *UserTestSuite) TestFindUserHistory() {
+	w := postJSON(suite.r, "/v1/users", CreateUserInput{Name: "test", Email: "test@gmail.com", Address: "jalan 123", Age: 24, PhoneNumber: "+62234567890"})
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer user-1")
+	header.Set(RequestIDHeader, "req-rename")
+	w = sendJSONWithHeader(suite.r, "PATCH", "/v1/users/1", header, UpdateUserInput{Name: "renamed"})
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	req, _ := http.NewRequest("DELETE", "/v1/users/1", nil)
+	suite.r.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("GET", "/v1/users/1/history?limit=2", nil)
+	w = httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var response HistoryResponse
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(suite.T(), response.Data, 2)
+	assert.True(suite.T(), response.Paging.HasNext)
+	deleted, updated := response.Data[0], response.Data[1]
+	assert.Equal(suite.T(), models.AuditDelete, deleted.Action)
+	assert.Equal(suite.T(), "user:100", deleted.Actor)
+	assert.Equal(suite.T(), models.AuditUpdate, updated.Action)
+	assert.Equal(suite.T(), "user:1", updated.Actor)
+	assert.Equal(suite.T(), "req-rename", updated.RequestID)
+	assert.Equal(suite.T(), models.Changes{"name": {Before: json.RawMessage(`"test"`), After: json.RawMessage(`"renamed"`)}}, updated.Changes)
+
+	req, _ = http.NewRequest("GET", "/v1/users/1/history?cursor="+response.Paging.NextCursor, nil)
+	w = httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(suite.T(), response.Data, 1)
+	assert.Equal(suite.T(), models.AuditCreate, response.Data[0].Action)
+	assert.False(suite.T(), response.Paging.HasNext)
+}
+
+func (suite *UserTestSuite) TestFindUserHistoryRefused() {
+	suite.seedUser()
+
+	// Users can't read their own history
+
+	req, _ := http.NewRequest("GET", "/v1/users/1/history", nil)
+	req.Header.Set("Authorization", "Bearer user-1")
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+	assert.Equal(suite.T(), http.StatusForbidden, w.Code)
+
+	req, _ = http.NewRequest("GET", "/v1/users/1/history?limit=0", nil)
+	req.Header.Set("Authorization", "Bearer operator")
+	w = httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+
+	req, _ = http.NewRequest("GET", "/v1/users/1/history?cursor=nope", nil)
+	w = httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"crud/user/audit"
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Headers of requests made idempotent by Idempotent.
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// replayedHeaders are the headers of responses stored with their key, those
+// describing the response rather than how it was sent.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// maxIdempotencyKeyLength bounds the keys accepted from callers.
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyConfig tunes Idempotent.
+type IdempotencyConfig struct {
+	// KeyTTL is how long responses are replayed
+	KeyTTL time.Duration
+	// LockTimeout is how long a request holds its key before a retry may run it again
+	LockTimeout time.Duration
+	// MaxBodySize bounds, in bytes, the bodies read to tell requests apart
+	MaxBodySize int64
+}
+
+// Idempotent lets clients retry a request safely by sending the same
+// Idempotency-Key header: the response to the first attempt is stored and
+// replayed to the retries for KeyTTL, rather than running the request again.
+// Keys are scoped to the caller, so it runs after authentication. Reusing a
+// key for another request answers 422, retrying while the first attempt still
+// runs answers 409. A first attempt that doesn't finish within LockTimeout,
+// whose process died, is run again by the next retry. Requests failing with
+// 5xx aren't stored, retrying them runs them again. Bodies over MaxBodySize
+// answer 413. Requests without the header aren't affected.
+func Idempotent(keys repository.IdempotencyKeyRepository, config IdempotencyConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength || !printable(key) {
+			abortWithBadRequest(c, fmt.Errorf("%s should be at most %d printable characters", IdempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, config.MaxBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			abortWithStatus(c, http.StatusRequestEntityTooLarge, "request_too_large",
+				fmt.Errorf("request body should be at most %d bytes", tooLarge.Limit))
+			return
+		}
+		if err != nil {
+			abortWithBadRequest(c, errors.New("request body could not be read"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		reserved := &models.IdempotencyKey{
+			Scope:       audit.ActorFrom(c.Request.Context()),
+			Key:         key,
+			Fingerprint: fingerprint(c.Request, body),
+			CreatedAt:   now,
+			// Postgres keeps microseconds, the lock is told apart by it
+			LockedUntil: now.Add(config.LockTimeout).Truncate(time.Microsecond),
+			ExpiresAt:   now.Add(config.KeyTTL),
+		}
+		taken, err := keys.Reserve(c.Request.Context(), reserved)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if taken != nil {
+			replay(c, taken, reserved.Fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// The response is stored even if the client went away meanwhile
+		ctx := context.WithoutCancel(c.Request.Context())
+		if !recorder.Written() || recorder.Status() >= http.StatusInternalServerError {
+			err = keys.Release(ctx, reserved)
+		} else {
+			reserved.StatusCode, reserved.Response = recorder.Status(), recorder.body.Bytes()
+			reserved.Headers = models.ResponseHeaders{}
+			for _, name := range replayedHeaders {
+				if value := recorder.Header().Get(name); value != "" {
+					reserved.Headers[name] = value
+				}
+			}
+			err = keys.Complete(ctx, reserved)
+		}
+		if err != nil {
+			_ = c.Error(err)
+		}
+	}
+}
+
+// replay answers a request whose key was taken already.
+func replay(c *gin.Context, taken *models.IdempotencyKey, fingerprint string) {
+	switch {
+	case taken.Fingerprint != fingerprint:
+		abortWithStatus(c, http.StatusUnprocessableEntity, "idempotency_key_reused",
+			fmt.Errorf("%s was already used for another request", IdempotencyKeyHeader))
+	case !taken.Done():
+		if wait := time.Until(taken.LockedUntil); wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		}
+		abortWithStatus(c, http.StatusConflict, "idempotency_key_in_use",
+			fmt.Errorf("a request with this %s is still running, try again later", IdempotencyKeyHeader))
+	default:
+		for name, value := range taken.Headers {
+			c.Header(name, value)
+		}
+		c.Header(IdempotentReplayedHeader, "true")
+		contentType := taken.Headers["Content-Type"]
+		if contentType == "" {
+			contentType = binding.MIMEJSON + "; charset=utf-8"
+		}
+		c.Data(taken.StatusCode, contentType, taken.Response)
+		c.Abort()
+	}
+}
+
+// fingerprint tells requests apart by method, path and body. JSON bodies are
+// compared by value, so retries don't have to serialise them the same way.
+func fingerprint(r *http.Request, body []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err == nil && !decoder.More() {
+		// Maps are encoded with sorted keys
+		if canonical, err := json.Marshal(value); err == nil {
+			body = canonical
+		}
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.Path)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response body it writes.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"crud/user/audit"
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var idempotentInput = CreateUserInput{
+	Name:        "test",
+	Email:       "test@gmail.com",
+	Address:     "jalan 123",
+	Age:         24,
+	PhoneNumber: "+62234567890",
+}
+
+func withIdempotencyKey(key string) http.Header {
+	header := http.Header{}
+	header.Set(IdempotencyKeyHeader, key)
+	return header
+}
+
+func (suite *UserTestSuite) TestCreateUserIdempotencyKey() {
+	first := sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), idempotentInput)
+	assert.Equal(suite.T(), http.StatusOK, first.Code)
+	assert.Empty(suite.T(), first.Header().Get(IdempotentReplayedHeader))
+
+	// The same user serialised another way is the same request
+	retry := sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), map[string]interface{}{
+		"phoneNumber": "+62234567890",
+		"age":         24,
+		"address":     "jalan 123",
+		"email":       "test@gmail.com",
+		"name":        "test",
+		"password":    "",
+	})
+	assert.Equal(suite.T(), http.StatusOK, retry.Code)
+	assert.Equal(suite.T(), "true", retry.Header().Get(IdempotentReplayedHeader))
+	assert.JSONEq(suite.T(), first.Body.String(), retry.Body.String())
+	// Headers describing the response are replayed too
+	assert.Equal(suite.T(), `"1"`, first.Header().Get("ETag"))
+	assert.Equal(suite.T(), first.Header().Get("ETag"), retry.Header().Get("ETag"))
+	assert.Equal(suite.T(), first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+
+	page, _ := suite.repo.List(context.Background(), repository.UserQuery{})
+	assert.Len(suite.T(), page.Users, 1)
+	assert.Len(suite.T(), suite.outbox.messages, 1)
+
+	changed := idempotentInput
+	changed.Name = "someone else"
+	w := sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), changed)
+	assert.Equal(suite.T(), http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), `"error":"idempotency_key_reused"`)
+
+	// Without a key nothing is replayed, the duplicate is refused
+	w = postJSON(suite.r, "/v1/users", idempotentInput)
+	assert.Equal(suite.T(), http.StatusConflict, w.Code)
+}
+
+func (suite *UserTestSuite) TestCreateUserIdempotencyKeyReplaysFailures() {
+	invalid := idempotentInput
+	invalid.Email = "not an email"
+	w := sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), invalid)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+
+	w = sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), invalid)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	assert.Equal(suite.T(), "true", w.Header().Get(IdempotentReplayedHeader))
+}
+
+// reserveKey reserves the key of a request creating idempotentInput, as the
+// admin, like an attempt holding it until lockedUntil.
+func (suite *UserTestSuite) reserveKey(lockedUntil time.Time) {
+	req, _ := http.NewRequest("POST", "/v1/users", nil)
+	body, _ := json.Marshal(idempotentInput)
+	_, err := suite.keys.Reserve(context.Background(), &models.IdempotencyKey{
+		Scope:       audit.UserActor(100),
+		Key:         "retry-me",
+		Fingerprint: fingerprint(req, body),
+		CreatedAt:   time.Now().Add(-time.Minute),
+		LockedUntil: lockedUntil,
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *UserTestSuite) TestCreateUserIdempotencyKeyInUse() {
+	suite.reserveKey(time.Now().Add(30 * time.Second))
+
+	w := sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), idempotentInput)
+	assert.Equal(suite.T(), http.StatusConflict, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), `"error":"idempotency_key_in_use"`)
+	assert.Equal(suite.T(), "30", w.Header().Get("Retry-After"))
+}
+
+func (suite *UserTestSuite) TestCreateUserIdempotencyKeyOfDeadAttempt() {
+	// The first attempt never finished, its lock lapsed
+	suite.reserveKey(time.Now().Add(-time.Second))
+
+	w := sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), idempotentInput)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	w = sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), idempotentInput)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), "true", w.Header().Get(IdempotentReplayedHeader))
+}
+
+func (suite *UserTestSuite) TestCreateUserInvalidIdempotencyKey() {
+	for _, key := range []string{strings.Repeat("a", 256), "with space"} {
+		w := sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey(key), idempotentInput)
+		assert.Equal(suite.T(), http.StatusBadRequest, w.Code, key)
+	}
+
+	// Bodies are read whole to fingerprint them, up to a limit
+	large := idempotentInput
+	large.Address = strings.Repeat("a", 1024)
+	w := sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), large)
+	assert.Equal(suite.T(), http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), `"error":"request_too_large"`)
+}
+
+// flakyRepository fails to create the first user, like a database outage.
+type flakyRepository struct {
+	*repository.MemoryUserRepository
+	failed bool
+}
+
+func (r *flakyRepository) Create(ctx context.Context, user *models.User) error {
+	if !r.failed {
+		r.failed = true
+		return repository.ErrUnavailable
+	}
+	return r.MemoryUserRepository.Create(ctx, user)
+}
+
+func (suite *UserTestSuite) TestCreateUserIdempotencyKeyRetriesServerErrors() {
+	suite.ctl.users = &flakyRepository{MemoryUserRepository: suite.repo}
+
+	w := sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), idempotentInput)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, w.Code)
+
+	w = sendJSONWithHeader(suite.r, "POST", "/v1/users", withIdempotencyKey("retry-me"), idempotentInput)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Empty(suite.T(), w.Header().Get(IdempotentReplayedHeader))
+}
+
+func TestFingerprint(t *testing.T) {
+	post, _ := http.NewRequest("POST", "/v1/users", nil)
+	put, _ := http.NewRequest("PUT", "/v1/users", nil)
+
+	assert.Equal(t, fingerprint(post, []byte(`{"a": 1, "b": [2.50]}`)), fingerprint(post, []byte(`{"b":[2.50],"a":1}`)))
+	assert.NotEqual(t, fingerprint(post, []byte(`{"a":1}`)), fingerprint(post, []byte(`{"a":2}`)))
+	assert.NotEqual(t, fingerprint(post, []byte(`{"a":1}`)), fingerprint(put, []byte(`{"a":1}`)))
+	// Bodies that aren't JSON are compared as they are
+	assert.NotEqual(t, fingerprint(post, []byte(`a=1`)), fingerprint(post, []byte(`a=2`)))
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"crud/user/auth"
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenIssuer signs the tokens handed to a user logging in.
+type TokenIssuer interface {
+	Issue(userID, sessionID uint, refreshToken string) (*auth.TokenPair, error)
+	RefreshTTL() time.Duration
+}
+
+type LoginInput struct {
+	Email    string `json:"email" binding:"required,email" example:"testName@gmail.com"`
+	Password string `json:"password" binding:"required" example:"correct-Horse-battery"`
+}
+
+type RefreshInput struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// TokenResponse carries the tokens of a logged in user.
+type TokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType" example:"Bearer"`
+	// ExpiresIn is the lifetime of the access token in seconds
+	ExpiresIn int64 `json:"expiresIn" example:"900"`
+}
+
+var (
+	errInvalidCredentials  = errors.New("the email or password is wrong")
+	errInvalidRefreshToken = errors.New("the refresh token is invalid or expired")
+)
+
+type AuthController struct {
+	users     repository.UserRepository
+	sessions  repository.SessionRepository
+	passwords *auth.Passwords
+	issuer    TokenIssuer
+}
+
+func NewAuthController(users repository.UserRepository, sessions repository.SessionRepository, passwords *auth.Passwords, issuer TokenIssuer) *AuthController {
+	return &AuthController{users: users, sessions: sessions, passwords: passwords, issuer: issuer}
+}
+
+// Login godoc
+// @Summary      Log in
+// @Description  trade the email and password of a user for an access and a refresh token, starting a session
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param 			 request body controllers.LoginInput true "body"
+// @Success      200  {object}  controllers.TokenResponse
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/auth/login [post]
+func (ctl *AuthController) Login(c *gin.Context) {
+	var input LoginInput
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	user, err := ctl.users.FindByEmail(c.Request.Context(), input.Email)
+	if errors.Is(err, repository.ErrNotFound) {
+		// Spend the time a wrong password would, so response times don't tell which emails exist
+		_ = ctl.passwords.Check("", input.Password)
+		abortWithStatus(c, http.StatusUnauthorized, "invalid_credentials", errInvalidCredentials)
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := ctl.passwords.Check(user.PasswordHash, input.Password); err != nil {
+		abortWithStatus(c, http.StatusUnauthorized, "invalid_credentials", errInvalidCredentials)
+		return
+	}
+
+	// The session is stored before the access token is signed, which names it
+	refreshToken, err := auth.NewToken()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	now := time.Now()
+	session := models.Session{
+		UserID:     user.ID,
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(ctl.issuer.RefreshTTL()),
+	}
+	if err := ctl.sessions.Create(c.Request.Context(), &session, auth.HashToken(refreshToken)); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	pair, err := ctl.issuer.Issue(user.ID, session.ID, refreshToken)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	respondWithTokens(c, pair)
+}
+
+// Refresh godoc
+// @Summary      Refresh tokens
+// @Description  trade a refresh token for a new access and refresh token. Every refresh token works once,
+// @Description  presenting a used one again revokes its session
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param 			 request body controllers.RefreshInput true "body"
+// @Success      200  {object}  controllers.TokenResponse
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/auth/refresh [post]
+func (ctl *AuthController) Refresh(c *gin.Context) {
+	var input RefreshInput
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	tokenHash := auth.HashToken(input.RefreshToken)
+	session, err := ctl.sessions.FindByToken(c.Request.Context(), tokenHash)
+	if errors.Is(err, repository.ErrNotFound) {
+		abortWithStatus(c, http.StatusUnauthorized, "invalid_token", errInvalidRefreshToken)
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	// Users deleted since logging in don't get new tokens
+	if _, err := ctl.users.Find(c.Request.Context(), session.UserID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			abortWithStatus(c, http.StatusUnauthorized, "invalid_token", errInvalidRefreshToken)
+		} else {
+			abortWithError(c, err)
+		}
+		return
+	}
+
+	refreshToken, err := auth.NewToken()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	pair, err := ctl.issuer.Issue(session.UserID, session.ID, refreshToken)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	// Rotate has the last word, the token may have been used or revoked since it was looked up
+	err = ctl.sessions.Rotate(c.Request.Context(), tokenHash, auth.HashToken(pair.RefreshToken), pair.RefreshExpiresAt)
+	switch {
+	case errors.Is(err, repository.ErrTokenReused):
+		_ = c.Error(fmt.Errorf("session %d of user %d revoked: %w", session.ID, session.UserID, err))
+		abortWithStatus(c, http.StatusUnauthorized, "invalid_token", errInvalidRefreshToken)
+		return
+	case errors.Is(err, repository.ErrNotFound):
+		abortWithStatus(c, http.StatusUnauthorized, "invalid_token", errInvalidRefreshToken)
+		return
+	case err != nil:
+		abortWithError(c, err)
+		return
+	}
+
+	respondWithTokens(c, pair)
+}
+
+func respondWithTokens(c *gin.Context, pair *auth.TokenPair) {
+	c.JSON(http.StatusOK, gin.H{"data": TokenResponse{
+		AccessToken:  pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(pair.ExpiresIn.Seconds()),
+	}})
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crud/user/auth"
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type tokenBody struct {
+	Data TokenResponse `json:"data"`
+}
+
+func loginRouter(t *testing.T) (*gin.Engine, *repository.MemoryUserRepository, *repository.MemorySessionRepository, *auth.Verifier) {
+	secret := filepath.Join(t.TempDir(), "jwt.secret")
+	assert.NoError(t, os.WriteFile(secret, []byte("an-hs256-secret-of-at-least-32-bytes"), 0o600))
+	config := auth.Config{HMACSecretFile: secret, AccessTokenTTL: 15 * time.Minute, RefreshTokenTTL: time.Hour}
+	issuer, err := auth.NewIssuer(config)
+	assert.NoError(t, err)
+	verifier, err := auth.NewVerifier(config)
+	assert.NoError(t, err)
+
+	repo := repository.NewMemoryUserRepository()
+	sessions := repository.NewMemorySessionRepository()
+	ctl := NewAuthController(repo, sessions, testPasswords, issuer)
+
+	gin.SetMode(gin.TestMode)
+	useValidators()
+	r := gin.New()
+	r.POST("/v1/auth/login", ctl.Login)
+	r.POST("/v1/auth/refresh", ctl.Refresh)
+	return r, repo, sessions, verifier
+}
+
+func postJSON(r http.Handler, path string, body interface{}) *httptest.ResponseRecorder {
+	return sendJSON(r, "POST", path, body)
+}
+
+func patchJSON(r http.Handler, path string, body interface{}) *httptest.ResponseRecorder {
+	return sendJSON(r, "PATCH", path, body)
+}
+
+func sendJSON(r http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	return sendJSONWithHeader(r, method, path, http.Header{}, body)
+}
+
+func sendJSONWithHeader(r http.Handler, method, path string, header http.Header, body interface{}) *httptest.ResponseRecorder {
+	raw, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(raw))
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLogin(t *testing.T) {
+	r, repo, sessions, verifier := loginRouter(t)
+
+	hash, _ := testPasswords.Hash("correct-Horse-battery")
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890", PasswordHash: hash}
+	assert.NoError(t, repo.Create(context.Background(), &user))
+	withoutPassword := models.User{Name: "other", Email: "other@gmail.com", PhoneNumber: "+62234567891"}
+	assert.NoError(t, repo.Create(context.Background(), &withoutPassword))
+
+	w := postJSON(r, "/v1/auth/login", LoginInput{Email: "TEST@gmail.com", Password: "correct-Horse-battery"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body tokenBody
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "Bearer", body.Data.TokenType)
+	assert.Equal(t, int64(900), body.Data.ExpiresIn)
+
+	claims, err := verifier.Verify(body.Data.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", claims.Subject)
+
+	// The session remembers the device, and only the hash of the refresh token
+	_, err = sessions.FindByToken(context.Background(), body.Data.RefreshToken)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	session, err := sessions.FindByToken(context.Background(), auth.HashToken(body.Data.RefreshToken))
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, session.UserID)
+	assert.Equal(t, session.ID, claims.SessionID)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), session.ExpiresAt, time.Minute)
+
+	// Wrong passwords, unknown emails and users without a password look the same
+	for _, input := range []LoginInput{
+		{Email: "test@gmail.com", Password: "wrong-Horse-battery"},
+		{Email: "nobody@gmail.com", Password: "correct-Horse-battery"},
+		{Email: "other@gmail.com", Password: "correct-Horse-battery"},
+	} {
+		w := postJSON(r, "/v1/auth/login", input)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, input.Email)
+		assert.JSONEq(t, `{"code":401,"error":"invalid_credentials","message":"the email or password is wrong"}`, w.Body.String())
+	}
+
+	w = postJSON(r, "/v1/auth/login", LoginInput{Email: "test@gmail.com"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRefresh(t *testing.T) {
+	r, repo, sessions, verifier := loginRouter(t)
+
+	hash, _ := testPasswords.Hash("correct-Horse-battery")
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890", PasswordHash: hash}
+	assert.NoError(t, repo.Create(context.Background(), &user))
+
+	login := func() tokenBody {
+		var body tokenBody
+		w := postJSON(r, "/v1/auth/login", LoginInput{Email: "test@gmail.com", Password: "correct-Horse-battery"})
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body
+	}
+	first, other := login(), login()
+
+	var refreshed tokenBody
+	w := postJSON(r, "/v1/auth/refresh", RefreshInput{RefreshToken: first.Data.RefreshToken})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &refreshed))
+	assert.NotEqual(t, first.Data.RefreshToken, refreshed.Data.RefreshToken)
+	claims, err := verifier.Verify(refreshed.Data.AccessToken)
+	assert.NoError(t, err)
+	session, err := sessions.FindByToken(context.Background(), auth.HashToken(refreshed.Data.RefreshToken))
+	assert.NoError(t, err)
+	assert.Equal(t, session.ID, claims.SessionID)
+
+	// An access token can't be used to refresh
+	w = postJSON(r, "/v1/auth/refresh", RefreshInput{RefreshToken: first.Data.AccessToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Replaying a used token revokes the session, the token it was traded for stops working too
+	w = postJSON(r, "/v1/auth/refresh", RefreshInput{RefreshToken: first.Data.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postJSON(r, "/v1/auth/refresh", RefreshInput{RefreshToken: refreshed.Data.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	active, _ := sessions.List(context.Background(), user.ID)
+	assert.Len(t, active, 1)
+
+	// Deleted users don't get new tokens
+	assert.NoError(t, repo.SoftDelete(context.Background(), user.ID))
+	w = postJSON(r, "/v1/auth/refresh", RefreshInput{RefreshToken: other.Data.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	r, repo, sessions, verifier := loginRouter(t)
+	users := NewUserController(repo, sessions, testPasswords, nil)
+	r.POST("/v1/users/:id/password", Authenticate(verifier, nil), users.ChangePassword)
+
+	hash, _ := testPasswords.Hash("correct-Horse-battery")
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890", PasswordHash: hash}
+	assert.NoError(t, repo.Create(context.Background(), &user))
+
+	login := func() tokenBody {
+		var body tokenBody
+		w := postJSON(r, "/v1/auth/login", LoginInput{Email: "test@gmail.com", Password: "correct-Horse-battery"})
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body
+	}
+	current, stolen := login(), login()
+
+	header := http.Header{"Authorization": {"Bearer " + current.Data.AccessToken}}
+	w := sendJSONWithHeader(r, "POST", "/v1/users/1/password", header,
+		ChangePasswordInput{CurrentPassword: "correct-Horse-battery", NewPassword: "Tr0ub4dor&3-staple"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Whoever learned the old password loses their session, the user keeps theirs
+	w = postJSON(r, "/v1/auth/refresh", RefreshInput{RefreshToken: stolen.Data.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postJSON(r, "/v1/auth/refresh", RefreshInput{RefreshToken: current.Data.RefreshToken})
+	assert.Equal(t, http.StatusOK, w.Code)
+	active, _ := sessions.List(context.Background(), user.ID)
+	assert.Len(t, active, 1)
+}
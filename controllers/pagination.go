@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Paging is the metadata returned next to "data" on list endpoints.
+type Paging struct {
+	Limit      int    `json:"limit" example:"20"`
+	Sort       string `json:"sort" example:"-createdAt"`
+	NextCursor string `json:"nextCursor,omitempty" example:"eyJzIjoiLWNyZWF0ZWRBdCIsInYiOiIyMDI0LTA3LTEwVDA0OjI0OjU1LjQwNTkxNSswNzowMCIsImkiOjF9"`
+	PrevCursor string `json:"prevCursor,omitempty"`
+	HasNext    bool   `json:"hasNext" example:"true"`
+	HasPrev    bool   `json:"hasPrev" example:"false"`
+}
+
+type UserListResponse struct {
+	Data   []models.User `json:"data"`
+	Paging Paging        `json:"paging"`
+}
+
+func newUserListResponse(page *repository.UserPage) UserListResponse {
+	return UserListResponse{
+		Data: page.Users,
+		Paging: Paging{
+			Limit:      page.Limit,
+			Sort:       page.Sort,
+			NextCursor: page.NextCursor,
+			PrevCursor: page.PrevCursor,
+			HasNext:    page.HasNext,
+			HasPrev:    page.HasPrev,
+		},
+	}
+}
+
+// HistoryPaging is the metadata returned next to "data" by the history endpoint.
+type HistoryPaging struct {
+	Limit      int    `json:"limit" example:"20"`
+	NextCursor string `json:"nextCursor,omitempty" example:"eyJzIjoiLWlkIiwidiI6IiIsImkiOjQyfQ"`
+	HasNext    bool   `json:"hasNext" example:"true"`
+}
+
+type HistoryResponse struct {
+	Data   []models.AuditEntry `json:"data"`
+	Paging HistoryPaging       `json:"paging"`
+}
+
+func newHistoryResponse(page *repository.HistoryPage) HistoryResponse {
+	return HistoryResponse{
+		Data: page.Entries,
+		Paging: HistoryPaging{
+			Limit:      page.Limit,
+			NextCursor: page.NextCursor,
+			HasNext:    page.HasNext,
+		},
+	}
+}
+
+// parseUserQuery reads the paging, sort and filter query parameters of a list request.
+// Sort and cursor are checked by the repository.
+func parseUserQuery(c *gin.Context) (*repository.UserQuery, error) {
+	query := &repository.UserQuery{
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+		Name:   c.Query("name"),
+		Email:  c.Query("email"),
+	}
+
+	var err error
+	if query.Limit, err = parseLimitParam(c); err != nil {
+		return nil, err
+	}
+
+	if query.MinAge, err = parseAgeParam(c, "minAge"); err != nil {
+		return nil, err
+	}
+	if query.MaxAge, err = parseAgeParam(c, "maxAge"); err != nil {
+		return nil, err
+	}
+	if query.MinAge != nil && query.MaxAge != nil && *query.MinAge > *query.MaxAge {
+		return nil, errors.New("minAge should not be greater than maxAge")
+	}
+
+	if query.CreatedFrom, err = parseTimeParam(c, "createdFrom"); err != nil {
+		return nil, err
+	}
+	if query.CreatedTo, err = parseTimeParam(c, "createdTo"); err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// parseHistoryQuery reads the paging query parameters of a history request.
+// The cursor is checked by the repository.
+func parseHistoryQuery(c *gin.Context) (*repository.HistoryQuery, error) {
+	limit, err := parseLimitParam(c)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.HistoryQuery{Limit: limit, Cursor: c.Query("cursor")}, nil
+}
+
+// parseLimitParam reads the page size, 0 when it is left to the repository.
+func parseLimitParam(c *gin.Context) (int, error) {
+	limit := c.Query("limit")
+	if limit == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 || n > repository.MaxPageLimit {
+		return 0, fmt.Errorf("limit should be between 1 and %d", repository.MaxPageLimit)
+	}
+	return n, nil
+}
+
+func parseAgeParam(c *gin.Context, name string) (*int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > 127 {
+		return nil, fmt.Errorf("%s should be a number between 0 and 127", name)
+	}
+	return &n, nil
+}
+
+func parseTimeParam(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s should be an RFC 3339 timestamp", name)
+	}
+	return &t, nil
+}
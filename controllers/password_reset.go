@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"crud/user/verification"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ForgotPasswordInput struct {
+	Email string `json:"email" binding:"required,email" example:"testName@gmail.com"`
+}
+
+type ResetPasswordInput struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,password" example:"new-Horse-battery"`
+}
+
+type PasswordResetController struct {
+	resets   *verification.PasswordResetter
+	requests *verification.ResetQueue
+}
+
+func NewPasswordResetController(resets *verification.PasswordResetter, requests *verification.ResetQueue) *PasswordResetController {
+	return &PasswordResetController{resets: resets, requests: requests}
+}
+
+// ForgotPassword godoc
+// @Summary      Forgot password
+// @Description  mail a password reset token to the user with the email, in the background. The answer is the same whether or not a user has it.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param 			 request body controllers.ForgotPasswordInput true "body"
+// @Success      200  {object}  boolean
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Router       /v1/auth/forgot-password [post]
+func (ctl *PasswordResetController) ForgotPassword(c *gin.Context) {
+	var input ForgotPasswordInput
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	// Handled in the background, how long it takes or whether it fails would
+	// tell which emails exist
+	ctl.requests.Enqueue(input.Email)
+
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
+
+// ResetPassword godoc
+// @Summary      Reset password
+// @Description  set a new password with the token mailed by forgot-password, logging the user out everywhere. Every token works once.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param 			 request body controllers.ResetPasswordInput true "body"
+// @Success      200  {object}  boolean
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/auth/reset-password [post]
+func (ctl *PasswordResetController) ResetPassword(c *gin.Context) {
+	var input ResetPasswordInput
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	_, err := ctl.resets.Reset(c.Request.Context(), input.Token, input.Password)
+	if errors.Is(err, verification.ErrInvalidResetToken) {
+		abortWithStatus(c, http.StatusBadRequest, "invalid_token", err)
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"crud/user/models"
+	"crud/user/repository"
+	"crud/user/verification"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordReset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useValidators()
+	users := repository.NewMemoryUserRepository()
+	sessions := repository.NewMemorySessionRepository()
+	inbox := &outbox{}
+	resets := verification.NewPasswordResetter(users, repository.NewMemoryTokenRepository(), sessions, testPasswords, inbox, time.Hour, "https://app.example.com/reset-password")
+	queue := verification.NewResetQueue(resets, 10, log.New(io.Discard, "", 0))
+	queue.Start(context.Background())
+	ctl := NewPasswordResetController(resets, queue)
+
+	r := gin.New()
+	r.POST("/v1/auth/forgot-password", ctl.ForgotPassword)
+	r.POST("/v1/auth/reset-password", ctl.ResetPassword)
+
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, users.Create(context.Background(), &user))
+	session := models.Session{UserID: user.ID, LastUsedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	assert.NoError(t, sessions.Create(context.Background(), &session, "refresh"))
+
+	// Known and unknown emails get the same answer
+	unknown := postJSON(r, "/v1/auth/forgot-password", ForgotPasswordInput{Email: "nobody@gmail.com"})
+	known := postJSON(r, "/v1/auth/forgot-password", ForgotPasswordInput{Email: "test@gmail.com"})
+	assert.Equal(t, http.StatusOK, known.Code)
+	assert.Equal(t, unknown.Code, known.Code)
+	assert.Equal(t, unknown.Body.String(), known.Body.String())
+	// The email is only sent in the background
+	queue.Stop()
+	assert.Len(t, inbox.messages, 1)
+	token := inbox.lastToken(t)
+
+	w := postJSON(r, "/v1/auth/reset-password", ResetPasswordInput{Token: token, Password: "password"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "weak_password")
+
+	w = postJSON(r, "/v1/auth/reset-password", ResetPasswordInput{Token: token, Password: "new-Horse-battery"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	stored, _ := users.Find(context.Background(), user.ID)
+	assert.NoError(t, testPasswords.Check(stored.PasswordHash, "new-Horse-battery"))
+	active, _ := sessions.List(context.Background(), user.ID)
+	assert.Empty(t, active)
+
+	w = postJSON(r, "/v1/auth/reset-password", ResetPasswordInput{Token: token, Password: "other-Horse-battery"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"code":400,"error":"invalid_token","message":"the password reset token is invalid or expired"}`, w.Body.String())
+}
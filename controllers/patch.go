@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"crud/user/models"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Media types of the patches PATCH /v1/users/:id accepts. Plain JSON is
+// taken as a merge patch.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// acceptPatch is announced in the Accept-Patch header.
+const acceptPatch = MergePatchType + ", " + JSONPatchType
+
+// userDocument is the editable part of a user, the document patches apply to.
+// The patched document is validated as a whole, so required fields can't be
+// removed while address and age can be cleared.
+type userDocument struct {
+	Name        string `json:"name" binding:"required,min=2,max=100"`
+	Email       string `json:"email" binding:"required,email"`
+	Address     string `json:"address" binding:"omitempty,min=2,max=255"`
+	Age         int8   `json:"age" binding:"min=0,max=120"`
+	PhoneNumber string `json:"phoneNumber" binding:"required,e164"`
+}
+
+func newUserDocument(user *models.User) userDocument {
+	return userDocument{
+		Name:        user.Name,
+		Email:       user.Email,
+		Address:     user.Address,
+		Age:         user.Age,
+		PhoneNumber: user.PhoneNumber,
+	}
+}
+
+func (doc userDocument) applyTo(user *models.User) {
+	user.Name = doc.Name
+	user.Email = doc.Email
+	user.Address = doc.Address
+	user.Age = doc.Age
+	user.PhoneNumber = doc.PhoneNumber
+}
+
+// patchUser applies the patch in the request body to the editable fields of
+// user: a JSON Merge Patch (RFC 7396), or a JSON Patch (RFC 6902) when sent as
+// application/json-patch+json. When the patch can't be applied or leaves user
+// invalid, it aborts and returns false, user is left untouched.
+func patchUser(c *gin.Context, user *models.User) bool {
+	patch, err := c.GetRawData()
+	if err != nil {
+		abortWithBadRequest(c, errors.New("request body could not be read"))
+		return false
+	}
+	current, err := json.Marshal(newUserDocument(user))
+	if err != nil {
+		abortWithError(c, err)
+		return false
+	}
+
+	var patched []byte
+	switch c.ContentType() {
+	case JSONPatchType:
+		if patched, err = applyJSONPatch(current, patch); err != nil {
+			abortWithPatchError(c, err)
+			return false
+		}
+	case MergePatchType, binding.MIMEJSON, "":
+		// Merging anything but an object would replace the whole document
+		if !bytes.HasPrefix(bytes.TrimSpace(patch), []byte("{")) {
+			abortWithBadRequest(c, errors.New("request body should be a JSON object"))
+			return false
+		}
+		if patched, err = jsonpatch.MergePatch(current, patch); err != nil {
+			abortWithBadRequest(c, errors.New("request body should be a JSON object"))
+			return false
+		}
+	default:
+		c.Header("Accept-Patch", acceptPatch)
+		abortWithStatus(c, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			fmt.Errorf("send the patch as %s or %s", MergePatchType, JSONPatchType))
+		return false
+	}
+
+	var doc userDocument
+	if !bindDocument(c, patched, &doc) {
+		return false
+	}
+	doc.applyTo(user)
+	return true
+}
+
+func applyJSONPatch(document, raw []byte) ([]byte, error) {
+	patch, err := jsonpatch.DecodePatch(raw)
+	if err != nil {
+		return nil, &invalidPatchError{err: err}
+	}
+	return patch.Apply(document)
+}
+
+// invalidPatchError is a JSON Patch that isn't one.
+type invalidPatchError struct {
+	err error
+}
+
+func (e *invalidPatchError) Error() string {
+	return "request body should be a JSON Patch: " + e.err.Error()
+}
+
+// abortWithPatchError answers a JSON Patch that failed: 409 when one of its test
+// operations did, the user isn't in the state the client expected, 400 otherwise.
+func abortWithPatchError(c *gin.Context, err error) {
+	var invalid *invalidPatchError
+	switch {
+	case errors.As(err, &invalid):
+		abortWithBadRequest(c, err)
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		abortWithStatus(c, http.StatusConflict, "patch_test_failed", errors.New("a test operation of the patch failed"))
+	default:
+		abortWithStatus(c, http.StatusBadRequest, "invalid_patch", fmt.Errorf("the patch could not be applied: %w", err))
+	}
+}
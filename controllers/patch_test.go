@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"crud/user/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sendPatch patches the user with id 1 with a raw body of the given content type
+func (suite *UserTestSuite) sendPatch(contentType, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("PATCH", "/v1/users/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+	return w
+}
+
+func (suite *UserTestSuite) TestMergePatchClearsAndZeroesFields() {
+	existingUser := suite.seedUser()
+
+	w := suite.sendPatch(MergePatchType, `{"address": null, "age": 0}`)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var response map[string]models.User
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(suite.T(), "", response["data"].Address)
+	assert.Equal(suite.T(), int8(0), response["data"].Age)
+	assert.Equal(suite.T(), existingUser.Name, response["data"].Name) // Left out, kept
+	// The response is the user as stored, not as it was read
+	assert.Equal(suite.T(), uint(2), response["data"].Version)
+
+	stored, _ := suite.repo.Find(context.Background(), existingUser.ID)
+	assert.Equal(suite.T(), "", stored.Address)
+	assert.Equal(suite.T(), int8(0), stored.Age)
+	assert.Equal(suite.T(), stored.UpdatedAt.UnixNano(), response["data"].UpdatedAt.UnixNano())
+}
+
+func (suite *UserTestSuite) TestMergePatchRefusals() {
+	suite.seedUser()
+
+	// Required fields can't be removed
+	w := suite.sendPatch(MergePatchType, `{"name": null}`)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), `"field":"name","code":"required"`)
+
+	// Only editable fields can be patched
+	w = suite.sendPatch("application/json", `{"version": 7}`)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), `"field":"version","code":"unknown_field"`)
+
+	w = suite.sendPatch(MergePatchType, `["name"]`)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+
+	w = suite.sendPatch("text/plain", `name=test`)
+	assert.Equal(suite.T(), http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal(suite.T(), acceptPatch, w.Header().Get("Accept-Patch"))
+
+	stored, _ := suite.repo.Find(context.Background(), 1)
+	assert.Equal(suite.T(), "test", stored.Name)
+	assert.Equal(suite.T(), uint(1), stored.Version)
+}
+
+func (suite *UserTestSuite) TestJSONPatch() {
+	suite.seedUser()
+
+	w := suite.sendPatch(JSONPatchType, `[
+		{"op": "test", "path": "/name", "value": "test"},
+		{"op": "replace", "path": "/name", "value": "test 2"},
+		{"op": "remove", "path": "/address"}
+	]`)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	stored, _ := suite.repo.Find(context.Background(), 1)
+	assert.Equal(suite.T(), "test 2", stored.Name)
+	assert.Equal(suite.T(), "", stored.Address)
+
+	// The name isn't what the client expected anymore
+	w = suite.sendPatch(JSONPatchType, `[
+		{"op": "test", "path": "/name", "value": "test"},
+		{"op": "replace", "path": "/name", "value": "test 3"}
+	]`)
+	assert.Equal(suite.T(), http.StatusConflict, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), "patch_test_failed")
+
+	w = suite.sendPatch(JSONPatchType, `[{"op": "replace", "path": "/age", "value": 500}]`)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), `"field":"age","code":"invalid_type"`)
+
+	w = suite.sendPatch(JSONPatchType, `{"op": "remove"}`)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+
+	w = suite.sendPatch(JSONPatchType, `[{"op": "replace", "path": "/nickname", "value": "x"}]`)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), "invalid_patch")
+
+	stored, _ = suite.repo.Find(context.Background(), 1)
+	assert.Equal(suite.T(), "test 2", stored.Name)
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"crud/user/models"
+	"crud/user/policy"
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxExternalIDLength bounds the external IDs clients upsert users by.
+const maxExternalIDLength = 255
+
+// ReplaceUserInput is a whole user, validated like CreateUserInput. The password
+// isn't part of it, see ChangePassword.
+type ReplaceUserInput struct {
+	Name string `json:"name" binding:"required,min=2,max=100" example:"testName" minLength:"2" maxLength:"100"`
+	// Check if it's email
+	Email   string `json:"email" binding:"required,email" example:"testName@gmail.com"`
+	Address string `json:"address" binding:"required,min=2,max=255" example:"purworejo, jawa tengah, indonesia" minLength:"2" maxLength:"255"`
+	Age     int8   `json:"age" binding:"required,min=1,max=120" example:"24" minimum:"1" maximum:"120"`
+	// Check if it's phoneNumber
+	PhoneNumber string `json:"phoneNumber" binding:"required,e164" example:"+6285155678965"`
+}
+
+func (input *ReplaceUserInput) document() userDocument {
+	return userDocument{
+		Name:        input.Name,
+		Email:       input.Email,
+		Address:     input.Address,
+		Age:         input.Age,
+		PhoneNumber: input.PhoneNumber,
+	}
+}
+
+// ReplaceUser godoc
+// @Summary      Replace user
+// @Description  replace every field of a user, validated like a new user. Sending what is stored already changes nothing. With If-Match set to the ETag the user was read with, the replacement is refused with 412 if someone else updated it since.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        id        path    int     true   "User ID"
+// @Param        If-Match  header  string  false  "ETag the user was read with"
+// @Param 			 request body controllers.ReplaceUserInput true "body"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Success      200  {object}  models.User
+// @Header       200  {string}  ETag  "version of the user"
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      409  {object}  controllers.ErrorResponse
+// @Failure      412  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id} [put]
+func (ctl *UserController) ReplaceUser(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.UpdateUser, id) {
+		return
+	}
+
+	user, err := ctl.users.Find(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !checkIfMatch(c, user) {
+		return
+	}
+
+	var input ReplaceUserInput
+	if !bindJSON(c, &input) {
+		return
+	}
+	ctl.replaceUser(c, user, &input)
+}
+
+// UpsertUser godoc
+// @Summary      Create or replace user by external ID
+// @Description  replace every field of the user with the external ID, or create it when there is none, so sync jobs can push the same state again safely. The new user has no password. If-Match works like on replace, and fails with 412 when there is no user to match.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        externalId  path    string  true   "ID of the user in the client's system"
+// @Param        If-Match    header  string  false  "ETag the user was read with"
+// @Param 			 request body controllers.ReplaceUserInput true "body"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Success      200  {object}  models.User
+// @Success      201  {object}  models.User
+// @Header       200,201  {string}  ETag  "version of the user"
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      409  {object}  controllers.ErrorResponse
+// @Failure      412  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/external/{externalId} [put]
+func (ctl *UserController) UpsertUser(c *gin.Context) {
+	externalID := c.Param("externalId")
+	if len(externalID) > maxExternalIDLength {
+		abortWithBadRequest(c, fmt.Errorf("externalId should be at most %d characters", maxExternalIDLength))
+		return
+	}
+	if !authorize(c, policy.CreateUser, 0) {
+		return
+	}
+
+	var input ReplaceUserInput
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	// A concurrent upsert may create the user between the lookup and the
+	// insert, that one is replaced instead
+	for retried := false; ; retried = true {
+		user, err := ctl.users.FindByExternalID(c.Request.Context(), externalID)
+		if errors.Is(err, repository.ErrNotFound) {
+			if c.GetHeader("If-Match") != "" {
+				abortWithPreconditionFailed(c)
+				return
+			}
+			err = ctl.createByExternalID(c, externalID, &input)
+			var conflict *repository.ConflictError
+			if errors.As(err, &conflict) && conflict.Field == "externalId" && !retried {
+				continue
+			}
+			if err != nil {
+				abortWithError(c, err)
+			}
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		if !authorize(c, policy.UpdateUser, user.ID) || !checkIfMatch(c, user) {
+			return
+		}
+		ctl.replaceUser(c, user, &input)
+		return
+	}
+}
+
+// createByExternalID creates the user upserted by externalID and answers 201.
+func (ctl *UserController) createByExternalID(c *gin.Context, externalID string, input *ReplaceUserInput) error {
+	user := models.User{ExternalID: &externalID, CreatedAt: time.Now()}
+	input.document().applyTo(&user)
+	if err := ctl.users.Create(c.Request.Context(), &user); err != nil {
+		return err
+	}
+	ctl.sendVerification(c, &user)
+
+	c.Header("Location", "/v1/users/"+strconv.FormatUint(uint64(user.ID), 10))
+	respondWithUser(c, http.StatusCreated, &user)
+	return nil
+}
+
+// replaceUser replaces the fields of user by input. Nothing is written when
+// they are the same, so pushing a user again doesn't bump its version.
+func (ctl *UserController) replaceUser(c *gin.Context, user *models.User, input *ReplaceUserInput) {
+	if input.document() == newUserDocument(user) {
+		respondWithUser(c, http.StatusOK, user)
+		return
+	}
+
+	previous := *user
+	input.document().applyTo(user)
+	ctl.saveUser(c, &previous, user)
+}
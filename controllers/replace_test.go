@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var replacement = ReplaceUserInput{
+	Name:        "replaced",
+	Email:       "replaced@gmail.com",
+	Address:     "jalan 456",
+	Age:         30,
+	PhoneNumber: "+62234567899",
+}
+
+func (suite *UserTestSuite) TestReplaceUser() {
+	suite.seedUser()
+
+	w := sendJSON(suite.r, "PUT", "/v1/users/1", replacement)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), `"2"`, w.Header().Get("ETag"))
+
+	var response map[string]models.User
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(suite.T(), "replaced", response["data"].Name)
+	assert.Equal(suite.T(), "+62234567899", response["data"].PhoneNumber)
+	// The new email address needs verifying
+	assert.Len(suite.T(), suite.outbox.messages, 1)
+
+	// Sending the same user again writes nothing
+	w = sendJSON(suite.r, "PUT", "/v1/users/1", replacement)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), `"2"`, w.Header().Get("ETag"))
+	history, _ := suite.repo.History(context.Background(), 1, repository.HistoryQuery{})
+	assert.Len(suite.T(), history.Entries, 2)
+}
+
+func (suite *UserTestSuite) TestReplaceUserValidatesEveryField() {
+	suite.seedUser()
+
+	w := sendJSON(suite.r, "PUT", "/v1/users/1", ReplaceUserInput{Name: "replaced", Email: "replaced@gmail.com"})
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	for _, field := range []string{"address", "age", "phoneNumber"} {
+		assert.Contains(suite.T(), w.Body.String(), `"field":"`+field+`","code":"required"`)
+	}
+
+	header := http.Header{}
+	header.Set("If-Match", `"7"`)
+	w = sendJSONWithHeader(suite.r, "PUT", "/v1/users/1", header, replacement)
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, w.Code)
+
+	w = sendJSON(suite.r, "PUT", "/v1/users/999", replacement)
+	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+
+func (suite *UserTestSuite) TestUpsertUser() {
+	w := sendJSON(suite.r, "PUT", "/v1/users/external/crm-1", replacement)
+	assert.Equal(suite.T(), http.StatusCreated, w.Code)
+	assert.Equal(suite.T(), "/v1/users/1", w.Header().Get("Location"))
+	assert.Equal(suite.T(), `"1"`, w.Header().Get("ETag"))
+
+	var response map[string]models.User
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(suite.T(), "crm-1", *response["data"].ExternalID)
+
+	// Pushing the same state again is a no-op
+	w = sendJSON(suite.r, "PUT", "/v1/users/external/crm-1", replacement)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), `"1"`, w.Header().Get("ETag"))
+
+	changed := replacement
+	changed.Age = 31
+	w = sendJSON(suite.r, "PUT", "/v1/users/external/crm-1", changed)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), `"2"`, w.Header().Get("ETag"))
+
+	page, _ := suite.repo.List(context.Background(), repository.UserQuery{})
+	assert.Len(suite.T(), page.Users, 1)
+	assert.Equal(suite.T(), int8(31), page.Users[0].Age)
+}
+
+func (suite *UserTestSuite) TestUpsertUserRefusals() {
+	// Matching a version of a user that doesn't exist fails
+	header := http.Header{}
+	header.Set("If-Match", `"1"`)
+	w := sendJSONWithHeader(suite.r, "PUT", "/v1/users/external/crm-1", header, replacement)
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, w.Code)
+
+	w = sendJSON(suite.r, "PUT", "/v1/users/external/"+strings.Repeat("a", 256), replacement)
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+
+	// Users can't create users, even by upserting
+	header = http.Header{}
+	header.Set("Authorization", "Bearer user-1")
+	w = sendJSONWithHeader(suite.r, "PUT", "/v1/users/external/crm-1", header, replacement)
+	assert.Equal(suite.T(), http.StatusForbidden, w.Code)
+
+	// Another user's contact details are taken
+	suite.seedUser()
+	taken := replacement
+	taken.Email = "test@gmail.com"
+	w = sendJSON(suite.r, "PUT", "/v1/users/external/crm-1", taken)
+	assert.Equal(suite.T(), http.StatusConflict, w.Code)
+}
+
+// racingRepository misses the user on the first lookup, like an upsert racing
+// another one that creates it in between.
+type racingRepository struct {
+	*repository.MemoryUserRepository
+	missed bool
+}
+
+func (r *racingRepository) FindByExternalID(ctx context.Context, externalID string) (*models.User, error) {
+	if !r.missed {
+		r.missed = true
+		return nil, repository.ErrNotFound
+	}
+	return r.MemoryUserRepository.FindByExternalID(ctx, externalID)
+}
+
+func (suite *UserTestSuite) TestUpsertUserLosingTheRace() {
+	externalID := "crm-1"
+	existing := models.User{ExternalID: &externalID, Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(suite.T(), suite.repo.Create(context.Background(), &existing))
+	suite.ctl.users = &racingRepository{MemoryUserRepository: suite.repo}
+
+	w := sendJSON(suite.r, "PUT", "/v1/users/external/crm-1", replacement)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	stored, _ := suite.repo.Find(context.Background(), existing.ID)
+	assert.Equal(suite.T(), "replaced", stored.Name)
+}
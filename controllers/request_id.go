@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"crud/user/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader names each request, in the request and the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs accepted from callers.
+const maxRequestIDLength = 128
+
+// RequestID names every request with the X-Request-ID header the caller sent,
+// or a random one, and echoes it in the response. The ID is recorded in the
+// audit log along with the changes the request makes, whose actor is
+// anonymous until the request is authenticated.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		c.Header(RequestIDHeader, id)
+		ctx := audit.WithRequestID(c.Request.Context(), id)
+		c.Request = c.Request.WithContext(audit.WithActor(ctx, audit.Anonymous))
+		c.Next()
+	}
+}
+
+// validRequestID accepts IDs short enough and of printable ASCII only, so they
+// are safe to log and echo.
+func validRequestID(id string) bool {
+	return id != "" && len(id) <= maxRequestIDLength && printable(id)
+}
+
+// printable reports whether s is made of printable ASCII only, spaces excluded.
+func printable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	raw := make([]byte, 16)
+	// crypto/rand never fails on the platforms we run on, an empty ID would do anyway
+	_, _ = rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
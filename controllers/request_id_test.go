@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"crud/user/audit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/v1/ping", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.String(http.StatusOK, audit.RequestIDFrom(ctx)+" "+audit.ActorFrom(ctx))
+	})
+
+	request := func(id string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/v1/ping", nil)
+		if id != "" {
+			req.Header.Set(RequestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("req-1")
+	assert.Equal(t, "req-1", w.Header().Get(RequestIDHeader))
+	assert.Equal(t, "req-1 anonymous", w.Body.String())
+
+	// Missing, oversized or unprintable IDs are replaced
+	for _, id := range []string{"", strings.Repeat("a", 129), "two words"} {
+		w = request(id)
+		generated := w.Header().Get(RequestIDHeader)
+		assert.Len(t, generated, 32)
+		assert.NotEqual(t, id, generated)
+	}
+}
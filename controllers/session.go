@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"crud/user/policy"
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionController struct {
+	sessions repository.SessionRepository
+}
+
+func NewSessionController(sessions repository.SessionRepository) *SessionController {
+	return &SessionController{sessions: sessions}
+}
+
+// ListSessions godoc
+// @Summary      List sessions
+// @Description  list the devices a user is logged in on, most recently used first
+// @Tags         sessions
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Security     BearerAuth
+// @Success      200  {array}   models.Session
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id}/sessions [get]
+func (ctl *SessionController) ListSessions(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.ListSessions, id) {
+		return
+	}
+
+	sessions, err := ctl.sessions.List(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+// RevokeSession godoc
+// @Summary      Revoke session
+// @Description  log a user out of one device, its refresh token stops working
+// @Tags         sessions
+// @Accept       json
+// @Produce      json
+// @Param        id         path      int  true  "User ID"
+// @Param        sessionId  path      int  true  "Session ID"
+// @Security     BearerAuth
+// @Success      200  {object}  boolean
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id}/sessions/{sessionId} [delete]
+func (ctl *SessionController) RevokeSession(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.RevokeSessions, id) {
+		return
+	}
+	sessionID, err := strconv.ParseUint(c.Param("sessionId"), 10, 64)
+	if err != nil {
+		abortWithError(c, repository.ErrNotFound)
+		return
+	}
+
+	if err := ctl.sessions.Revoke(c.Request.Context(), id, uint(sessionID)); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
+
+// RevokeSessions godoc
+// @Summary      Revoke every session
+// @Description  log a user out everywhere, answering how many sessions were revoked. Access tokens already issued stay valid until they expire.
+// @Tags         sessions
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Security     BearerAuth
+// @Success      200  {object}  integer
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id}/sessions [delete]
+func (ctl *SessionController) RevokeSessions(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.RevokeSessions, id) {
+		return
+	}
+
+	revoked, err := ctl.sessions.RevokeAll(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": revoked})
+}
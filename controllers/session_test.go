@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemorySessionRepository()
+	ctl := NewSessionController(repo)
+
+	r := gin.New()
+	r.Use(Authenticate(testTokens, nil))
+	r.GET("/v1/users/:id/sessions", ctl.ListSessions)
+	r.DELETE("/v1/users/:id/sessions", ctl.RevokeSessions)
+	r.DELETE("/v1/users/:id/sessions/:sessionId", ctl.RevokeSession)
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, device := range []string{"phone", "laptop", "tablet"} {
+		session := models.Session{UserID: 1, UserAgent: device, LastUsedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		assert.NoError(t, repo.Create(context.Background(), &session, device))
+	}
+
+	w := request("GET", "/v1/users/1/sessions", "user-1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed struct {
+		Data []models.Session `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed.Data, 3)
+
+	// Sessions are private, even to operators
+	assert.Equal(t, http.StatusForbidden, request("GET", "/v1/users/1/sessions", "user-2").Code)
+	assert.Equal(t, http.StatusForbidden, request("DELETE", "/v1/users/1/sessions/1", "operator").Code)
+
+	assert.Equal(t, http.StatusOK, request("DELETE", "/v1/users/1/sessions/1", "user-1").Code)
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "/v1/users/1/sessions/1", "user-1").Code)
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "/v1/users/1/sessions/abc", "user-1").Code)
+
+	w = request("DELETE", "/v1/users/1/sessions", "admin")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"data":2}`, w.Body.String())
+
+	w = request("GET", "/v1/users/1/sessions", "user-1")
+	assert.JSONEq(t, `{"data":[]}`, w.Body.String())
+}
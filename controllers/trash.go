@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"net/http"
+
+	"crud/user/policy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FindDeletedUsers godoc
+// @Summary      Find soft-deleted users, paginated with a cursor
+// @Description  list the trash, most recently deleted first by default
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        limit        query     int     false  "Page size (1-100)"  default(20)
+// @Param        cursor       query     string  false  "nextCursor or prevCursor of a previous page"
+// @Param        sort         query     string  false  "Sort field, prefix with - for descending"  Enums(id, -id, name, -name, email, -email, age, -age, createdAt, -createdAt, updatedAt, -updatedAt, deletedAt, -deletedAt)  default(-deletedAt)
+// @Param        name         query     string  false  "Name contains (case insensitive)"
+// @Param        email        query     string  false  "Email equals (case insensitive)"
+// @Param        minAge       query     int     false  "Minimum age"
+// @Param        maxAge       query     int     false  "Maximum age"
+// @Param        createdFrom  query     string  false  "Created at or after (RFC 3339)"
+// @Param        createdTo    query     string  false  "Created before (RFC 3339)"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Success      200  {object}  controllers.UserListResponse
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/trash [get]
+func (ctl *UserController) FindDeletedUsers(c *gin.Context) {
+	if !authorize(c, policy.ListDeletedUsers, 0) {
+		return
+	}
+
+	query, err := parseUserQuery(c)
+	if err != nil {
+		abortWithBadRequest(c, err)
+		return
+	}
+
+	page, err := ctl.users.ListDeleted(c.Request.Context(), *query)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newUserListResponse(page))
+}
+
+// RestoreUser godoc
+// @Summary      Restore a soft-deleted user
+// @Description  undelete user, refused when a live user took its email or phone number meanwhile
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Security     BearerAuth
+// @Success      200  {object}  models.User
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      409  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id}/restore [post]
+func (ctl *UserController) RestoreUser(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.RestoreUser, id) {
+		return
+	}
+
+	if err := ctl.users.Restore(c.Request.Context(), id); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user, err := ctl.users.Find(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
@@ -1,74 +1,145 @@
 package controllers
 
 import (
+	"crud/user/auth"
 	"crud/user/models"
+	"crud/user/policy"
+	"crud/user/repository"
+	"crud/user/verification"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
-	"github.com/swaggo/swag/example/celler/httputil"
 )
 
 type CreateUserInput struct {
-	Name string `json:"name" binding:"required" example:"testName"`
+	Name string `json:"name" binding:"required,min=2,max=100" example:"testName" minLength:"2" maxLength:"100"`
 	// Check if it's email
 	Email   string `json:"email" binding:"required,email" example:"testName@gmail.com"`
-	Address string `json:"address" binding:"required" example:"purworejo, jawa tengah, indonesia"`
-	Age     int8   `json:"age" binding:"required" example:"24"`
+	Address string `json:"address" binding:"required,min=2,max=255" example:"purworejo, jawa tengah, indonesia" minLength:"2" maxLength:"255"`
+	Age     int8   `json:"age" binding:"required,min=1,max=120" example:"24" minimum:"1" maximum:"120"`
 	// Check if it's phoneNumber
 	PhoneNumber string `json:"phoneNumber" binding:"required,e164" example:"+6285155678965"`
+	// Users created without a password can't log in
+	Password string `json:"password" binding:"omitempty,password" example:"correct-Horse-battery"`
 }
 
+// UpdateUserInput is a merge patch of a user: fields left out are kept, address
+// and age are cleared by null. The patched user is validated like CreateUserInput,
+// except that age may be 0.
 type UpdateUserInput struct {
-	Name string `json:"name" example:"testName"`
+	Name string `json:"name,omitempty" example:"testName" minLength:"2" maxLength:"100"`
 	// Check if it's email
-	Email   string `json:"email" binding:"email" example:"testName@gmail.com"`
-	Address string `json:"address" example:"purworejo, jawa tengah, indonesia"`
-	Age     int8   `json:"age" example:"24"`
+	Email   string `json:"email,omitempty" example:"testName@gmail.com"`
+	Address string `json:"address,omitempty" example:"purworejo, jawa tengah, indonesia" minLength:"2" maxLength:"255"`
+	Age     int8   `json:"age,omitempty" example:"24" minimum:"0" maximum:"120"`
 	// Check if it's phoneNumber
-	PhoneNumber string `json:"phoneNumber" binding:"e164" example:"+6285155678965"`
+	PhoneNumber string `json:"phoneNumber,omitempty" example:"+6285155678965"`
 }
 
-type CustomError struct {
-	Code    int
-	Message string
+// ChangePasswordInput proves the caller knows the password before replacing it.
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"currentPassword" binding:"required" example:"correct-Horse-battery"`
+	NewPassword     string `json:"newPassword" binding:"required,password" example:"Tr0ub4dor&3-staple"`
 }
 
-func (c *CustomError) Error() string {
-	return c.Message
+type UserController struct {
+	users     repository.UserRepository
+	sessions  repository.SessionRepository
+	passwords *auth.Passwords
+	emails    *verification.EmailVerifier
+}
+
+func NewUserController(users repository.UserRepository, sessions repository.SessionRepository, passwords *auth.Passwords, emails *verification.EmailVerifier) *UserController {
+	return &UserController{users: users, sessions: sessions, passwords: passwords, emails: emails}
 }
 
 // FindUsers godoc
-// @Summary      Find All User where not deleted and sorted by created_at
-// @Description  find all user
+// @Summary      Find users where not deleted, paginated with a cursor
+// @Description  find users, filtered and sorted, one page at a time
 // @Tags         users
 // @Accept       json
 // @Produce      json
-// @Success      200  {object}  []models.User
+// @Param        limit        query     int     false  "Page size (1-100)"  default(20)
+// @Param        cursor       query     string  false  "nextCursor or prevCursor of a previous page"
+// @Param        sort         query     string  false  "Sort field, prefix with - for descending"  Enums(id, -id, name, -name, email, -email, age, -age, createdAt, -createdAt, updatedAt, -updatedAt)  default(-createdAt)
+// @Param        name         query     string  false  "Name contains (case insensitive)"
+// @Param        email        query     string  false  "Email equals (case insensitive)"
+// @Param        minAge       query     int     false  "Minimum age"
+// @Param        maxAge       query     int     false  "Maximum age"
+// @Param        createdFrom  query     string  false  "Created at or after (RFC 3339)"
+// @Param        createdTo    query     string  false  "Created before (RFC 3339)"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Success      200  {object}  controllers.UserListResponse
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
 // @Router       /v1/users [get]
-func FindUsers(c *gin.Context) {
-	var users []models.User
-	models.DB.Where("deleted_at is null").Order("created_at desc").Find(&users)
+func (ctl *UserController) FindUsers(c *gin.Context) {
+	if !authorize(c, policy.ListUsers, 0) {
+		return
+	}
+
+	query, err := parseUserQuery(c)
+	if err != nil {
+		abortWithBadRequest(c, err)
+		return
+	}
+
+	page, err := ctl.users.List(c.Request.Context(), *query)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"data": users})
+	c.JSON(http.StatusOK, newUserListResponse(page))
 }
 
 // ShowAccount godoc
 // @Summary      Find by id
-// @Description  get by id
+// @Description  get by id. The ETag of the response is the version of the user, sending it back in If-None-Match answers 304 while the user is unchanged.
 // @Tags         users
 // @Accept       json
 // @Produce      json
-// @Param        id   path      int  true  "User ID"
+// @Param        id             path      int     true   "User ID"
+// @Param        If-None-Match  header    string  false  "ETag of a previous response"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
 // @Success      200  {object}  models.User
-// @Failure      404  {object}  httputil.HTTPError
+// @Header       200  {string}  ETag  "version of the user"
+// @Success      304  "the user didn't change"
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
 // @Router       /v1/users/{id} [get]
-func FindUser(c *gin.Context) {
-	var user models.User
+func (ctl *UserController) FindUser(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.ReadUser, id) {
+		return
+	}
+
+	user, err := ctl.users.Find(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
 
-	if err := models.DB.Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
-		httputil.NewError(c, http.StatusNotFound, err)
+	etag := userETag(user)
+	c.Header("ETag", etag)
+	if matchesETag(c.GetHeader("If-None-Match"), etag, true) {
+		c.Status(http.StatusNotModified)
 		return
 	}
 
@@ -77,22 +148,36 @@ func FindUser(c *gin.Context) {
 
 // ShowAccount godoc
 // @Summary      Create user
-// @Description  create user
+// @Description  create user. Retrying with the Idempotency-Key of the first attempt returns its response again instead of creating another user, until the key expires. The key answers 422 when sent with another body, and 409 while the first attempt is still running.
 // @Tags         users
 // @Accept       json
 // @Produce      json
+// @Param        Idempotency-Key  header  string  false  "key the client picked for this user, at most 255 characters"
 // @Param 			 request body controllers.CreateUserInput true "body"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
 // @Success      200  {object}  models.User
-// @Failure      400  {object}  httputil.HTTPError
+// @Header       200  {string}  ETag  "version of the new user"
+// @Header       200  {string}  Idempotent-Replayed  "true when the response is that of an earlier attempt"
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      409  {object}  controllers.ErrorResponse
+// @Failure      413  {object}  controllers.ErrorResponse
+// @Failure      422  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
 // @Router       /v1/users [post]
-func CreateUsers(c *gin.Context) {
+func (ctl *UserController) CreateUsers(c *gin.Context) {
+	if !authorize(c, policy.CreateUser, 0) {
+		return
+	}
+
 	// Validate input
 	var input CreateUserInput
-	if err := c.ShouldBindJSON(&input); err != nil {
-		httputil.NewError(c, http.StatusBadRequest, err)
+	if !bindJSON(c, &input) {
 		return
 	}
-	validateCreateInput(c, &input)
 
 	// Create user
 	user := models.User{
@@ -103,120 +188,245 @@ func CreateUsers(c *gin.Context) {
 		PhoneNumber: input.PhoneNumber,
 		CreatedAt:   time.Now(),
 	}
-	models.DB.Create(&user)
+	if input.Password != "" {
+		hash, err := ctl.passwords.Hash(input.Password)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		user.PasswordHash = hash
+	}
+	if err := ctl.users.Create(c.Request.Context(), &user); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	ctl.sendVerification(c, &user)
 
-	c.JSON(http.StatusOK, gin.H{"data": user})
+	respondWithUser(c, http.StatusOK, &user)
 }
 
 // ShowAccount godoc
 // @Summary      Update user
-// @Description  update user
+// @Description  update user with a JSON Merge Patch (RFC 7396): fields left out are kept, null clears address and age. Sent as application/json-patch+json, the body is a JSON Patch (RFC 6902) instead, whose failed test operations answer 409. The updated user is returned.
+// @Description  With If-Match set to the ETag the user was read with, the update is refused with 412 if someone else updated it since.
 // @Tags         users
 // @Accept       json
+// @Accept       application/merge-patch+json
+// @Accept       application/json-patch+json
 // @Produce      json
-// @Param        id   path      int  true  "User ID"
+// @Param        id        path    int     true   "User ID"
+// @Param        If-Match  header  string  false  "ETag the user was read with"
 // @Param 			 request body controllers.UpdateUserInput true "body"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
 // @Success      200  {object}  models.User
-// @Failure      400  {object}  httputil.HTTPError
+// @Header       200  {string}  ETag  "version of the updated user"
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      409  {object}  controllers.ErrorResponse
+// @Failure      412  {object}  controllers.ErrorResponse
+// @Failure      415  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
 // @Router       /v1/users/{id} [patch]
-func UpdateUser(c *gin.Context) {
+func (ctl *UserController) UpdateUser(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.UpdateUser, id) {
+		return
+	}
+
 	// Get User if exist
-	var user models.User
-	if err := models.DB.Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
-		httputil.NewError(c, http.StatusBadRequest, err)
+	user, err := ctl.users.Find(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !checkIfMatch(c, user) {
 		return
 	}
 
-	// Validate input
-	var input UpdateUserInput
-	if err := c.ShouldBindJSON(&input); err != nil {
-		httputil.NewError(c, http.StatusBadRequest, err)
+	// Patch and validate the user
+	previous := *user
+	if !patchUser(c, user) {
 		return
 	}
-	validateUpdateInput(c, &input)
-	user.UpdatedAt = time.Now()
+	ctl.saveUser(c, &previous, user)
+}
 
-	// Update user
-	models.DB.Model(&user).Updates(input)
+// saveUser updates the edited user and answers with it as stored. New contact
+// details need verifying again. When the user was updated meanwhile, the
+// update fails with 412 if the caller sent If-Match, 409 otherwise.
+func (ctl *UserController) saveUser(c *gin.Context, previous, user *models.User) {
+	emailChanged := !strings.EqualFold(user.Email, previous.Email)
+	if emailChanged {
+		user.EmailVerifiedAt = nil
+	}
+	if user.PhoneNumber != previous.PhoneNumber {
+		user.PhoneVerifiedAt = nil
+	}
+	err := ctl.users.Update(c.Request.Context(), user)
+	if errors.Is(err, repository.ErrVersionMismatch) && c.GetHeader("If-Match") != "" {
+		abortWithPreconditionFailed(c)
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if emailChanged {
+		ctl.sendVerification(c, user)
+	}
 
-	c.JSON(http.StatusOK, gin.H{"data": user})
+	// Answer with the user as stored
+	user, err = ctl.users.Find(c.Request.Context(), user.ID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	respondWithUser(c, http.StatusOK, user)
 }
 
-// ShowAccount godoc
-// @Summary      Delete user
-// @Description  delete user
+// respondWithUser answers with user and its ETag.
+func respondWithUser(c *gin.Context, status int, user *models.User) {
+	c.Header("ETag", userETag(user))
+	c.JSON(status, gin.H{"data": user})
+}
+
+// ChangePassword godoc
+// @Summary      Change password
+// @Description  replace the password of a user, who has to know the current one. Every other session of the user is revoked,
+// @Description  the one the request was made with stays
 // @Tags         users
 // @Accept       json
 // @Produce      json
 // @Param        id   path      int  true  "User ID"
-// @Success      200  {object}  models.User
-// @Failure      400  {object}  httputil.HTTPError
-// @Router       /v1/users/{id} [delete]
-func DeleteUser(c *gin.Context) {
-	// Get model if exist
-	var user models.User
-	if err := models.DB.Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Record not found!"})
+// @Param 			 request body controllers.ChangePasswordInput true "body"
+// @Security     BearerAuth
+// @Success      200  {object}  boolean
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id}/password [post]
+func (ctl *UserController) ChangePassword(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.ChangePassword, id) {
 		return
 	}
 
-	models.DB.Delete(&user)
+	var input ChangePasswordInput
+	if !bindJSON(c, &input) {
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"data": true})
-}
+	user, err := ctl.users.Find(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := ctl.passwords.Check(user.PasswordHash, input.CurrentPassword); err != nil {
+		abortWithFieldErrors(c, []FieldError{{
+			Field:   "currentPassword",
+			Code:    "wrong_password",
+			Message: "currentPassword is not the current password",
+		}})
+		return
+	}
 
-// Custom validation function for email
-// Only check when email not empty
-func ValidateEmail(fl validator.FieldLevel) bool {
-	email := fl.Field().String()
-	if email == "" {
-		return true // Skip validation if the email is empty
+	user.PasswordHash, err = ctl.passwords.Hash(input.NewPassword)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err := ctl.users.Update(c.Request.Context(), user); err != nil {
+		abortWithError(c, err)
+		return
 	}
-	err := validator.New().Var(email, "email")
-	return err == nil
-}
 
-// Custom validation function for phone number
-// Only check when phone number not empty
-func ValidatePhoneNumber(fl validator.FieldLevel) bool {
-	phoneNumber := fl.Field().String()
-	if phoneNumber == "" {
-		return true // Skip validation if the phone number is empty
+	// Whoever held the old password may hold a session too. The caller's own
+	// session, if any, is kept; sessions of other users never match it.
+	var current uint
+	if claims, ok := Claims(c); ok {
+		current = claims.SessionID
+	}
+	if _, err := ctl.sessions.RevokeOthers(c.Request.Context(), user.ID, current); err != nil {
+		abortWithError(c, err)
+		return
 	}
-	err := validator.New().Var(phoneNumber, "e164")
-	return err == nil
+
+	c.JSON(http.StatusOK, gin.H{"data": true})
 }
 
-func validateCreateInput(c *gin.Context, input *CreateUserInput) {
-	validations := map[string]string{
-		input.Name:    "Name should be more than 1 char",
-		input.Address: "Address should be more than 1 char",
+// ShowAccount godoc
+// @Summary      Delete user
+// @Description  soft-delete user, or purge it for good with hard=true (administrators only)
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        id    path      int   true   "User ID"
+// @Param        hard  query     bool  false  "Delete permanently, including users already in the trash"
+// @Security     BearerAuth
+// @Success      200  {object}  models.User
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id} [delete]
+func (ctl *UserController) DeleteUser(c *gin.Context) {
+	hard := c.Query("hard") == "true"
+
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	action := policy.DeleteUser
+	if hard {
+		action = policy.HardDeleteUser
+	}
+	if !authorize(c, action, id) {
+		return
 	}
 
-	for field, errorMessage := range validations {
-		if len(field) < 2 {
-			httputil.NewError(c, http.StatusBadRequest, &CustomError{
-				Code:    http.StatusBadRequest,
-				Message: errorMessage,
-			})
-			return
-		}
+	if hard {
+		err = ctl.users.HardDelete(c.Request.Context(), id)
+	} else {
+		err = ctl.users.SoftDelete(c.Request.Context(), id)
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
 	}
+
+	c.JSON(http.StatusOK, gin.H{"data": true})
 }
 
-func validateUpdateInput(c *gin.Context, input *UpdateUserInput) {
-	validations := map[string]string{
-		input.Name:    "Name should be more than 1 char",
-		input.Address: "Address should be more than 1 char",
+// sendVerification mails user a verification token. A failure is only logged,
+// the user is saved and can ask for another one.
+func (ctl *UserController) sendVerification(c *gin.Context, user *models.User) {
+	if err := ctl.emails.Send(c.Request.Context(), user); err != nil {
+		_ = c.Error(err)
 	}
+}
 
-	for field, errorMessage := range validations {
-		if len(field) > 0 && len(field) < 2 {
-			httputil.NewError(c, http.StatusBadRequest, &CustomError{
-				Code:    http.StatusBadRequest,
-				Message: errorMessage,
-			})
-			return
-		}
+// userID parses the :id path parameter. An id that can't exist is not found.
+func userID(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, repository.ErrNotFound
 	}
+	return uint(id), nil
 }
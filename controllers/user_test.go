@@ -2,94 +2,188 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"crud/user/auth"
 	"crud/user/models"
+	"crud/user/repository"
 
-	"github.com/DATA-DOG/go-sqlmock"
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 )
 
 type UserTestSuite struct {
 	suite.Suite
-	DB   *gorm.DB
-	mock sqlmock.Sqlmock
-	r    *gin.Engine
+	repo   *repository.MemoryUserRepository
+	keys   *repository.MemoryIdempotencyKeyRepository
+	outbox *outbox
+	ctl    *UserController
+	r      adminByDefault
 }
 
-func (suite *UserTestSuite) SetupTest() {
-	var err error
-	db, mock, err := sqlmock.New()
-	assert.NoError(suite.T(), err)
+// testTokens stands in for real JWTs: "admin" and "operator" grant that role,
+// "user-<id>" is the user with that id and no role
+var testTokens = verifierFunc(func(token string) (*auth.Claims, error) {
+	claims := &auth.Claims{}
+	switch {
+	case token == "admin" || token == "operator":
+		claims.Subject = "100"
+		claims.Roles = []string{token}
+	case strings.HasPrefix(token, "user-"):
+		claims.Subject = strings.TrimPrefix(token, "user-")
+	default:
+		return nil, errors.New("token is malformed")
+	}
+	return claims, nil
+})
 
-	dialector := postgres.New(postgres.Config{
-		DSN:                  "sqlmock_db_0",
-		DriverName:           "postgres",
-		Conn:                 db,
-		PreferSimpleProtocol: true,
-	})
+// adminByDefault sends requests without a token as an administrator
+type adminByDefault struct {
+	*gin.Engine
+}
 
-	suite.DB, err = gorm.Open(dialector, &gorm.Config{})
-	assert.NoError(suite.T(), err)
+func (r adminByDefault) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer admin")
+	}
+	r.Engine.ServeHTTP(w, req)
+}
 
-	suite.mock = mock
+// testPasswords hashes at the lowest bcrypt cost to keep the tests fast
+var testPasswords, _ = auth.NewPasswords(4)
+
+func (suite *UserTestSuite) SetupTest() {
+	suite.repo = repository.NewMemoryUserRepository()
+	suite.keys = repository.NewMemoryIdempotencyKeyRepository()
+	suite.outbox = &outbox{}
+	suite.ctl = NewUserController(suite.repo, repository.NewMemorySessionRepository(), testPasswords, testEmails(suite.repo, suite.outbox))
 
 	gin.SetMode(gin.TestMode)
-	suite.r = gin.Default()
-	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
-		v.RegisterValidation("email", ValidateEmail)
-		v.RegisterValidation("e164", ValidatePhoneNumber)
-	}
-	models.DB = suite.DB
+	suite.r = adminByDefault{gin.Default()}
+	useValidators()
+	suite.r.Use(RequestID(), Authenticate(testTokens, nil))
+	suite.r.GET("/v1/users", suite.ctl.FindUsers)
+	suite.r.GET("/v1/users/trash", suite.ctl.FindDeletedUsers)
+	suite.r.POST("/v1/users", Idempotent(suite.keys, IdempotencyConfig{KeyTTL: time.Hour, LockTimeout: time.Minute, MaxBodySize: 1024}), suite.ctl.CreateUsers)
+	suite.r.GET("/v1/users/:id", suite.ctl.FindUser)
+	suite.r.PATCH("/v1/users/:id", suite.ctl.UpdateUser)
+	suite.r.PUT("/v1/users/:id", suite.ctl.ReplaceUser)
+	suite.r.PUT("/v1/users/external/:externalId", suite.ctl.UpsertUser)
+	suite.r.GET("/v1/users/:id/history", suite.ctl.FindUserHistory)
+	suite.r.POST("/v1/users/:id/password", suite.ctl.ChangePassword)
+	suite.r.DELETE("/v1/users/:id", suite.ctl.DeleteUser)
+	suite.r.POST("/v1/users/:id/restore", suite.ctl.RestoreUser)
 }
 
-func (suite *UserTestSuite) TearDownTest() {
-	sqlDB, err := suite.DB.DB()
-	assert.NoError(suite.T(), err)
-	sqlDB.Close()
+// useValidators registers the custom validations on gin's validator, as main does
+func useValidators() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		RegisterValidators(v)
+	}
 }
 
 func TestUserTestSuite(t *testing.T) {
 	suite.Run(t, new(UserTestSuite))
 }
 
-func (suite *UserTestSuite) TestFindUsers() {
-	rows := sqlmock.NewRows([]string{"id", "name", "email", "address", "age", "phone_number", "created_at", "updated_at", "deleted_at"}).
-		AddRow(1, "John Doe", "john@example.com", "Address 1", 30, "+1234567890", time.Now(), time.Now(), nil)
+// seedUser stores an existing user in the repository
+func (suite *UserTestSuite) seedUser() models.User {
+	user := models.User{
+		Name:        "test",
+		Email:       "test@gmail.com",
+		Address:     "jalan 123",
+		Age:         24,
+		PhoneNumber: "+62234567890",
+	}
+	assert.NoError(suite.T(), suite.repo.Create(context.Background(), &user))
+	return user
+}
 
-	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE deleted_at is null ORDER BY created_at desc$").
-		WillReturnRows(rows)
+func (suite *UserTestSuite) TestFindUsers() {
+	existingUser := suite.seedUser()
 
 	req, _ := http.NewRequest("GET", "/v1/users", nil)
 	w := httptest.NewRecorder()
-	suite.r.GET("/v1/users", FindUsers)
 	suite.r.ServeHTTP(w, req)
 
 	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var response UserListResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), response.Data, 1)
+	assert.Equal(suite.T(), existingUser.ID, response.Data[0].ID)
+	assert.Equal(suite.T(), repository.DefaultUserSort, response.Paging.Sort)
+	assert.Equal(suite.T(), repository.DefaultPageLimit, response.Paging.Limit)
+	assert.False(suite.T(), response.Paging.HasNext)
+	assert.False(suite.T(), response.Paging.HasPrev)
 }
 
-func (suite *UserTestSuite) TestFindUser() {
-	rows := sqlmock.NewRows([]string{"id", "name", "email", "address", "age", "phone_number", "created_at", "updated_at", "deleted_at"}).
-		AddRow(1, "John Doe", "john@example.com", "Address 1", 30, "+1234567890", time.Now(), time.Now(), nil)
+func (suite *UserTestSuite) TestFindUsersFilteredAndPaged() {
+	for i, name := range []string{"John Doe", "Jane Doe", "Johnny", "Big John"} {
+		user := models.User{Name: name, Email: fmt.Sprintf("user%d@gmail.com", i), Age: 30, PhoneNumber: fmt.Sprintf("+6223456789%d", i)}
+		assert.NoError(suite.T(), suite.repo.Create(context.Background(), &user))
+	}
+
+	req, _ := http.NewRequest("GET", "/v1/users?name=john&sort=id&limit=2", nil)
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var first UserListResponse
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &first))
+	assert.Len(suite.T(), first.Data, 2)
+	assert.Equal(suite.T(), "John Doe", first.Data[0].Name)
+	assert.Equal(suite.T(), "Johnny", first.Data[1].Name)
+	assert.True(suite.T(), first.Paging.HasNext)
+
+	req, _ = http.NewRequest("GET", "/v1/users?name=john&sort=id&limit=2&cursor="+first.Paging.NextCursor, nil)
+	w = httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+
+	var second UserListResponse
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &second))
+	assert.Len(suite.T(), second.Data, 1)
+	assert.Equal(suite.T(), "Big John", second.Data[0].Name)
+	assert.False(suite.T(), second.Paging.HasNext)
+	assert.True(suite.T(), second.Paging.HasPrev)
+}
+
+func (suite *UserTestSuite) TestFindUsersInvalidQuery() {
+	for _, query := range []string{
+		"sort=address",
+		"limit=0",
+		"limit=101",
+		"minAge=40&maxAge=20",
+		"createdFrom=yesterday",
+		"cursor=not-a-cursor",
+	} {
+		req, _ := http.NewRequest("GET", "/v1/users?"+query, nil)
+		w := httptest.NewRecorder()
+		suite.r.ServeHTTP(w, req)
+
+		assert.Equal(suite.T(), http.StatusBadRequest, w.Code, query)
+	}
+}
 
-	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE id = \\$1 AND \"users\".\"deleted_at\" IS NULL ORDER BY \"users\".\"id\" LIMIT \\$2").
-		WithArgs("1", 1).
-		WillReturnRows(rows)
+func (suite *UserTestSuite) TestFindUser() {
+	suite.seedUser()
 
 	req, _ := http.NewRequest("GET", "/v1/users/1", nil)
 	w := httptest.NewRecorder()
-	suite.r.GET("/v1/users/:id", FindUser)
 	suite.r.ServeHTTP(w, req)
 
 	assert.Equal(suite.T(), http.StatusOK, w.Code)
@@ -106,20 +200,12 @@ func (suite *UserTestSuite) TestCreateUsers() {
 	}
 	inputJSON, _ := json.Marshal(input)
 
-	// Mock DB interaction
-	suite.mock.ExpectBegin()
-	suite.mock.ExpectQuery(`INSERT INTO "users"`).
-		WithArgs(sqlmock.AnyArg(), input.Name, input.Email, input.Address, input.Age, input.PhoneNumber, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	suite.mock.ExpectCommit()
-
 	// Create request and response recorder
 	req, _ := http.NewRequest("POST", "/v1/users", bytes.NewBuffer(inputJSON))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
 	// Route and handle request
-	suite.r.POST("/v1/users", CreateUsers)
 	suite.r.ServeHTTP(w, req)
 
 	// Assert response
@@ -133,25 +219,123 @@ func (suite *UserTestSuite) TestCreateUsers() {
 	assert.Equal(suite.T(), input.Address, response["data"].Address)
 	assert.Equal(suite.T(), input.Age, response["data"].Age)
 	assert.Equal(suite.T(), input.PhoneNumber, response["data"].PhoneNumber)
+
+	stored, err := suite.repo.Find(context.Background(), response["data"].ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), input.Email, stored.Email)
+
+	// New users are asked to verify their email address
+	assert.Nil(suite.T(), response["data"].EmailVerifiedAt)
+	assert.Len(suite.T(), suite.outbox.messages, 1)
+	assert.Equal(suite.T(), input.Email, suite.outbox.messages[0].To)
 }
 
-func (suite *UserTestSuite) TestUpdateUser() {
-	// Mock existing user
-	existingUser := models.User{
-		ID:          1,
+func (suite *UserTestSuite) TestCreateUsersHashesPassword() {
+	input := CreateUserInput{
 		Name:        "test",
 		Email:       "test@gmail.com",
 		Address:     "jalan 123",
 		Age:         24,
 		PhoneNumber: "+62234567890",
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		Password:    "correct-Horse-battery",
 	}
+	inputJSON, _ := json.Marshal(input)
 
-	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE id = \\$1 AND \"users\".\"deleted_at\" IS NULL ORDER BY \"users\".\"id\" LIMIT \\$2").
-		WithArgs("1", 1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "address", "age", "phone_number", "created_at", "updated_at", "deleted_at"}).
-			AddRow(existingUser.ID, existingUser.Name, existingUser.Email, existingUser.Address, existingUser.Age, existingUser.PhoneNumber, existingUser.CreatedAt, existingUser.UpdatedAt, nil))
+	req, _ := http.NewRequest("POST", "/v1/users", bytes.NewBuffer(inputJSON))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	// Neither the password nor its hash is ever sent back
+	assert.NotContains(suite.T(), w.Body.String(), "password")
+	assert.NotContains(suite.T(), w.Body.String(), "$2a$")
+
+	stored, err := suite.repo.Find(context.Background(), 1)
+	assert.NoError(suite.T(), err)
+	assert.NotEqual(suite.T(), input.Password, stored.PasswordHash)
+	assert.NoError(suite.T(), testPasswords.Check(stored.PasswordHash, input.Password))
+
+	w = httptest.NewRecorder()
+	suite.r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/users/1", nil))
+	assert.NotContains(suite.T(), w.Body.String(), "$2a$")
+}
+
+func (suite *UserTestSuite) TestChangePassword() {
+	user := suite.seedUser()
+	user.PasswordHash, _ = testPasswords.Hash("correct-Horse-battery")
+	assert.NoError(suite.T(), suite.repo.Update(context.Background(), &user))
+
+	changePassword := func(token, current, next string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ChangePasswordInput{CurrentPassword: current, NewPassword: next})
+		req, _ := http.NewRequest("POST", "/v1/users/1/password", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		suite.r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Only the user can, even operators don't know the password
+	assert.Equal(suite.T(), http.StatusForbidden, changePassword("user-2", "correct-Horse-battery", "Tr0ub4dor&3-staple").Code)
+	assert.Equal(suite.T(), http.StatusForbidden, changePassword("operator", "correct-Horse-battery", "Tr0ub4dor&3-staple").Code)
+
+	w := changePassword("user-1", "wrong-Horse-battery", "Tr0ub4dor&3-staple")
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	var response ErrorResponse
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(suite.T(), []FieldError{{Field: "currentPassword", Code: "wrong_password", Message: "currentPassword is not the current password"}}, response.Fields)
+
+	w = changePassword("user-1", "correct-Horse-battery", "tooweak")
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(suite.T(), "weak_password", response.Fields[0].Code)
+
+	assert.Equal(suite.T(), http.StatusOK, changePassword("user-1", "correct-Horse-battery", "Tr0ub4dor&3-staple").Code)
+	stored, err := suite.repo.Find(context.Background(), user.ID)
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), testPasswords.Check(stored.PasswordHash, "Tr0ub4dor&3-staple"))
+}
+
+func (suite *UserTestSuite) TestCreateUsersConflict() {
+	suite.seedUser()
+
+	for field, input := range map[string]CreateUserInput{
+		"email":       {Name: "other", Email: "TEST@gmail.com", Address: "jalan 123", Age: 24, PhoneNumber: "+62234567891"},
+		"phoneNumber": {Name: "other", Email: "other@gmail.com", Address: "jalan 123", Age: 24, PhoneNumber: "+62234567890"},
+	} {
+		inputJSON, _ := json.Marshal(input)
+
+		req, _ := http.NewRequest("POST", "/v1/users", bytes.NewBuffer(inputJSON))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		suite.r.ServeHTTP(w, req)
+
+		assert.Equal(suite.T(), http.StatusConflict, w.Code, field)
+
+		var response ErrorResponse
+		assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(suite.T(), field, response.Field)
+	}
+}
+
+func (suite *UserTestSuite) TestCreateUsersReusesDeletedEmail() {
+	existingUser := suite.seedUser()
+	assert.NoError(suite.T(), suite.repo.SoftDelete(context.Background(), existingUser.ID))
+
+	inputJSON, _ := json.Marshal(CreateUserInput{Name: "test", Email: existingUser.Email, Address: "jalan 123", Age: 24, PhoneNumber: existingUser.PhoneNumber})
+
+	req, _ := http.NewRequest("POST", "/v1/users", bytes.NewBuffer(inputJSON))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+}
+
+func (suite *UserTestSuite) TestUpdateUser() {
+	// Mock existing user
+	existingUser := suite.seedUser()
 
 	// Mock input JSON
 	input := UpdateUserInput{
@@ -160,20 +344,12 @@ func (suite *UserTestSuite) TestUpdateUser() {
 	}
 	inputJSON, _ := json.Marshal(input)
 
-	// Mock DB update
-	suite.mock.ExpectBegin()
-	suite.mock.ExpectExec(`UPDATE "users" SET (.+) WHERE "id" = \\?`).
-		WithArgs(sqlmock.AnyArg(), input.Name, input.Address, sqlmock.AnyArg(), sqlmock.AnyArg()).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	suite.mock.ExpectCommit()
-
 	// Create request and response recorder
 	req, _ := http.NewRequest("PATCH", "/v1/users/1", bytes.NewBuffer(inputJSON))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
 	// Route and handle request
-	suite.r.PATCH("/v1/users/:id", UpdateUser)
 	suite.r.ServeHTTP(w, req)
 
 	// Assert response
@@ -187,39 +363,72 @@ func (suite *UserTestSuite) TestUpdateUser() {
 	assert.Equal(suite.T(), existingUser.Email, response["data"].Email)             // Email should remain unchanged
 	assert.Equal(suite.T(), existingUser.PhoneNumber, response["data"].PhoneNumber) // PhoneNumber should remain unchanged
 	assert.Equal(suite.T(), existingUser.Age, response["data"].Age)                 // Age should remain unchanged
+
+	stored, err := suite.repo.Find(context.Background(), existingUser.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), input.Name, stored.Name)
+	assert.Empty(suite.T(), suite.outbox.messages)
 }
 
-func (suite *UserTestSuite) TestDeleteUser() {
-	rows := sqlmock.NewRows([]string{"id", "name", "email", "address", "age", "phone_number", "created_at", "updated_at", "deleted_at"}).
-		AddRow(1, "John Doe", "john@example.com", "Address 1", 30, "+1234567890", time.Now(), time.Now(), nil)
+func (suite *UserTestSuite) TestUpdateUserEmailNeedsVerifying() {
+	existingUser := suite.seedUser()
+	verifiedAt := time.Now()
+	existingUser.EmailVerifiedAt = &verifiedAt
+	assert.NoError(suite.T(), suite.repo.Update(context.Background(), &existingUser))
+
+	// Changing the case only keeps the address verified
+	w := patchJSON(suite.r, "/v1/users/1", UpdateUserInput{Email: "TEST@gmail.com"})
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	stored, _ := suite.repo.Find(context.Background(), existingUser.ID)
+	assert.NotNil(suite.T(), stored.EmailVerifiedAt)
+	assert.Empty(suite.T(), suite.outbox.messages)
+
+	w = patchJSON(suite.r, "/v1/users/1", UpdateUserInput{Email: "new@gmail.com"})
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	stored, _ = suite.repo.Find(context.Background(), existingUser.ID)
+	assert.Nil(suite.T(), stored.EmailVerifiedAt)
+	assert.Len(suite.T(), suite.outbox.messages, 1)
+	assert.Equal(suite.T(), "new@gmail.com", suite.outbox.messages[0].To)
+}
+
+func (suite *UserTestSuite) TestUpdateUserPhoneNumberNeedsVerifying() {
+	existingUser := suite.seedUser()
+	verifiedAt := time.Now()
+	existingUser.PhoneVerifiedAt = &verifiedAt
+	assert.NoError(suite.T(), suite.repo.Update(context.Background(), &existingUser))
+
+	w := patchJSON(suite.r, "/v1/users/1", UpdateUserInput{PhoneNumber: existingUser.PhoneNumber, Name: "test 2"})
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	stored, _ := suite.repo.Find(context.Background(), existingUser.ID)
+	assert.NotNil(suite.T(), stored.PhoneVerifiedAt)
+
+	w = patchJSON(suite.r, "/v1/users/1", UpdateUserInput{PhoneNumber: "+62234567899"})
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	stored, _ = suite.repo.Find(context.Background(), existingUser.ID)
+	assert.Nil(suite.T(), stored.PhoneVerifiedAt)
+}
 
-	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE id = \\$1 AND \"users\".\"deleted_at\" IS NULL ORDER BY \"users\".\"id\" LIMIT \\$2").
-		WithArgs("1", 1).
-		WillReturnRows(rows)
+func (suite *UserTestSuite) TestDeleteUser() {
+	existingUser := suite.seedUser()
 
 	req, _ := http.NewRequest("DELETE", "/v1/users/1", nil)
 	w := httptest.NewRecorder()
-	suite.r.DELETE("/v1/users/:id", DeleteUser)
 	suite.r.ServeHTTP(w, req)
 
 	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	_, err := suite.repo.Find(context.Background(), existingUser.ID)
+	assert.ErrorIs(suite.T(), err, repository.ErrNotFound)
 }
 
 func (suite *UserTestSuite) TestFindUserNotFound() {
-	// Mock the database query to return an error
-	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE id = \\$1").
-		WithArgs("1").
-		WillReturnError(gorm.ErrRecordNotFound)
-
 	// Create request and response recorder
 	req, _ := http.NewRequest("GET", "/v1/users/1", nil)
 	w := httptest.NewRecorder()
 
 	// Route and handle request
-	suite.r.GET("/v1/users/:id", FindUser)
 	suite.r.ServeHTTP(w, req)
 
-	fmt.Println(w.Body)
 	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
 }
 
@@ -240,30 +449,60 @@ func (suite *UserTestSuite) TestCreateUsersInvalidInput() {
 	w := httptest.NewRecorder()
 
 	// Route and handle request
-	suite.r.POST("/v1/users", CreateUsers)
 	suite.r.ServeHTTP(w, req)
 
 	// Assert response
 	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
 }
 
-func (suite *UserTestSuite) TestUpdateUserInvalidInput() {
-	// Mock DB query for existing user
-	existingUser := models.User{
-		ID:          1,
-		Name:        "test",
-		Email:       "test@gmail.com",
-		Address:     "jalan 123",
-		Age:         24,
-		PhoneNumber: "+62234567890",
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+func (suite *UserTestSuite) TestCreateUsersListsEveryInvalidField() {
+	inputJSON := []byte(`{"name": "A", "email": "wrong-email", "address": "A", "age": 300, "phoneNumber": "0812"}`)
+
+	req, _ := http.NewRequest("POST", "/v1/users", bytes.NewBuffer(inputJSON))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+
+	// A single response, nothing stored
+	var response ErrorResponse
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(suite.T(), "validation_failed", response.Error)
+
+	codes := map[string]string{}
+	for _, field := range response.Fields {
+		codes[field.Field] = field.Code
 	}
+	assert.Equal(suite.T(), map[string]string{
+		"name":        "too_short",
+		"email":       "invalid_email",
+		"address":     "too_short",
+		"age":         "invalid_type",
+		"phoneNumber": "invalid_phone_number",
+	}, codes)
+
+	page, err := suite.repo.List(context.Background(), repository.UserQuery{})
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), page.Users)
+}
 
-	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE id = \\$1 AND \"users\".\"deleted_at\" IS NULL ORDER BY \"users\".\"id\" LIMIT \\$2").
-		WithArgs("1", 1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "address", "age", "phone_number", "created_at", "updated_at", "deleted_at"}).
-			AddRow(existingUser.ID, existingUser.Name, existingUser.Email, existingUser.Address, existingUser.Age, existingUser.PhoneNumber, existingUser.CreatedAt, existingUser.UpdatedAt, nil))
+func (suite *UserTestSuite) TestCreateUsersMalformedBody() {
+	req, _ := http.NewRequest("POST", "/v1/users", bytes.NewBufferString(`{"name": `))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+
+	var response ErrorResponse
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(suite.T(), "bad_request", response.Error)
+}
+
+func (suite *UserTestSuite) TestUpdateUserInvalidInput() {
+	// Mock existing user
+	suite.seedUser()
 
 	// Mock invalid input JSON
 	input := UpdateUserInput{
@@ -281,7 +520,6 @@ func (suite *UserTestSuite) TestUpdateUserInvalidInput() {
 	w := httptest.NewRecorder()
 
 	// Route and handle request
-	suite.r.PATCH("/v1/users/:id", UpdateUser)
 	suite.r.ServeHTTP(w, req)
 
 	// Assert response
@@ -289,74 +527,248 @@ func (suite *UserTestSuite) TestUpdateUserInvalidInput() {
 }
 
 func (suite *UserTestSuite) TestDeleteUserNotFound() {
-	// Mock DB query for user not found
-	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE id = \\$1 AND \"users\".\"deleted_at\" IS NULL ORDER BY \"users\".\"id\" LIMIT \\$2").
-		WithArgs("999", 1).
-		WillReturnError(gorm.ErrRecordNotFound)
-
 	// Create request and response recorder
 	req, _ := http.NewRequest("DELETE", "/v1/users/999", nil)
 	w := httptest.NewRecorder()
 
 	// Route and handle request
-	suite.r.DELETE("/v1/users/:id", DeleteUser)
 	suite.r.ServeHTTP(w, req)
 
 	// Assert response
-	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+
+func (suite *UserTestSuite) TestFindDeletedUsers() {
+	existingUser := suite.seedUser()
+	other := models.User{Name: "other", Email: "other@gmail.com", PhoneNumber: "+62234567891"}
+	assert.NoError(suite.T(), suite.repo.Create(context.Background(), &other))
+	assert.NoError(suite.T(), suite.repo.SoftDelete(context.Background(), existingUser.ID))
+
+	req, _ := http.NewRequest("GET", "/v1/users/trash", nil)
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var response UserListResponse
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(suite.T(), response.Data, 1)
+	assert.Equal(suite.T(), existingUser.ID, response.Data[0].ID)
+	assert.True(suite.T(), response.Data[0].DeletedAt.Valid)
+	assert.Equal(suite.T(), repository.DefaultTrashSort, response.Paging.Sort)
+}
+
+func (suite *UserTestSuite) TestRestoreUser() {
+	existingUser := suite.seedUser()
+	assert.NoError(suite.T(), suite.repo.SoftDelete(context.Background(), existingUser.ID))
+
+	req, _ := http.NewRequest("POST", "/v1/users/1/restore", nil)
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	_, err := suite.repo.Find(context.Background(), existingUser.ID)
+	assert.NoError(suite.T(), err)
+
+	// Restoring a live user finds nothing in the trash
+	w = httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+
+func (suite *UserTestSuite) TestRestoreUserConflict() {
+	existingUser := suite.seedUser()
+	assert.NoError(suite.T(), suite.repo.SoftDelete(context.Background(), existingUser.ID))
+	suite.seedUser() // Takes the email and phone number meanwhile
+
+	req, _ := http.NewRequest("POST", "/v1/users/1/restore", nil)
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+
+	assert.Equal(suite.T(), http.StatusConflict, w.Code)
+
+	var response ErrorResponse
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(suite.T(), "email", response.Field)
+}
+
+func (suite *UserTestSuite) TestUsersOnlyManageThemselves() {
+	existingUser := suite.seedUser()
+	other := models.User{Name: "other", Email: "other@gmail.com", PhoneNumber: "+62234567891"}
+	assert.NoError(suite.T(), suite.repo.Create(context.Background(), &other))
+
+	tests := []struct {
+		Method   string
+		Path     string
+		Body     string
+		Token    string
+		Expected int
+	}{
+		{"GET", fmt.Sprintf("/v1/users/%d", existingUser.ID), "", "user-1", http.StatusOK},
+		{"PATCH", fmt.Sprintf("/v1/users/%d", existingUser.ID), `{"name": "renamed"}`, "user-1", http.StatusOK},
+		{"GET", fmt.Sprintf("/v1/users/%d", other.ID), "", "user-1", http.StatusForbidden},
+		{"PATCH", fmt.Sprintf("/v1/users/%d", other.ID), `{"name": "renamed"}`, "user-1", http.StatusForbidden},
+		{"GET", "/v1/users", "", "user-1", http.StatusForbidden},
+		{"DELETE", fmt.Sprintf("/v1/users/%d", existingUser.ID), "", "user-1", http.StatusForbidden},
+
+		{"GET", "/v1/users", "", "operator", http.StatusOK},
+		{"PATCH", fmt.Sprintf("/v1/users/%d", other.ID), `{"name": "renamed"}`, "operator", http.StatusOK},
+		{"DELETE", fmt.Sprintf("/v1/users/%d", other.ID), "", "operator", http.StatusForbidden},
+		{"GET", "/v1/users/trash", "", "operator", http.StatusForbidden},
+		{"POST", fmt.Sprintf("/v1/users/%d/restore", other.ID), "", "operator", http.StatusForbidden},
+
+		{"GET", "/v1/users", "", "forged", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.Method, test.Path, strings.NewReader(test.Body))
+		req.Header.Set("Authorization", "Bearer "+test.Token)
+		w := httptest.NewRecorder()
+		suite.r.ServeHTTP(w, req)
+		assert.Equal(suite.T(), test.Expected, w.Code, "%s %s as %s", test.Method, test.Path, test.Token)
+	}
+}
+
+func (suite *UserTestSuite) TestHardDeleteUser() {
+	existingUser := suite.seedUser()
+	assert.NoError(suite.T(), suite.repo.SoftDelete(context.Background(), existingUser.ID))
+
+	// Only administrators
+	req, _ := http.NewRequest("DELETE", "/v1/users/1?hard=true", nil)
+	req.Header.Set("Authorization", "Bearer operator")
+	w := httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+	assert.Equal(suite.T(), http.StatusForbidden, w.Code)
+
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	suite.r.ServeHTTP(w, req)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	page, err := suite.repo.ListDeleted(context.Background(), repository.UserQuery{})
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), page.Users)
+}
+
+// failingRepository fails every call with err
+type failingRepository struct {
+	repository.UserRepository
+	err error
+}
+
+func (r failingRepository) Find(ctx context.Context, id uint) (*models.User, error) {
+	return nil, r.err
+}
+
+func (r failingRepository) SoftDelete(ctx context.Context, id uint) error {
+	return r.err
+}
+
+func TestDatabaseErrorsAreTranslated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		Err      error
+		Expected int
+		Code     string
+	}{
+		{Err: repository.ErrNotFound, Expected: http.StatusNotFound, Code: "not_found"},
+		{Err: &repository.ConflictError{}, Expected: http.StatusConflict, Code: "conflict"},
+		{Err: fmt.Errorf("%w: %w", repository.ErrTimeout, context.DeadlineExceeded), Expected: http.StatusServiceUnavailable, Code: "database_timeout"},
+		{Err: fmt.Errorf("%w: connection refused", repository.ErrUnavailable), Expected: http.StatusServiceUnavailable, Code: "database_unavailable"},
+		{Err: errors.New("pq: syntax error"), Expected: http.StatusInternalServerError, Code: "internal_error"},
+	}
+
+	for _, test := range tests {
+		ctl := NewUserController(failingRepository{err: test.Err}, repository.NewMemorySessionRepository(), testPasswords, nil)
+		r := gin.New()
+		r.Use(AnonymousAdmin())
+		r.GET("/v1/users/:id", ctl.FindUser)
+		r.DELETE("/v1/users/:id", ctl.DeleteUser)
+
+		for _, method := range []string{"GET", "DELETE"} {
+			req, _ := http.NewRequest(method, "/v1/users/1", nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, test.Expected, w.Code, test.Err.Error())
+
+			var response ErrorResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, test.Expected, response.Code)
+			assert.Equal(t, test.Code, response.Error)
+			assert.NotContains(t, response.Message, "pq:") // Internal details should not leak
+		}
+	}
 }
 
 func TestValidateCreateInput(t *testing.T) {
 	type testData struct {
 		Input    CreateUserInput
-		Expected int
+		Expected []string // Failing fields
 	}
+	useValidators()
+
+	valid := CreateUserInput{Name: "Valid Name", Email: "valid@gmail.com", Address: "Valid Address", Age: 24, PhoneNumber: "+62234567890"}
+	tooShort, sameValues, outOfRange, weakPassword, strongPassword := valid, valid, valid, valid, valid
+	tooShort.Name = "A"
+	sameValues.Name, sameValues.Address = "A", "A" // Both checks should be reported
+	outOfRange.Age = 121
+	weakPassword.Password, weakPassword.Name = "password1234", "Weak Password"
+	strongPassword.Password, strongPassword.Name = "correct-Horse-battery", "Strong Password"
 
 	tests := []testData{
-		{Input: CreateUserInput{Name: "A", Address: "Valid Address"}, Expected: http.StatusBadRequest}, // Name too short
-		{Input: CreateUserInput{Name: "Valid Name", Address: "A"}, Expected: http.StatusBadRequest},    // Address too short
-		{Input: CreateUserInput{Name: "Valid Name", Address: "Valid Address"}, Expected: http.StatusOK},
+		{Input: valid, Expected: nil},
+		{Input: tooShort, Expected: []string{"name"}},
+		{Input: sameValues, Expected: []string{"name", "address"}},
+		{Input: outOfRange, Expected: []string{"age"}},
+		{Input: weakPassword, Expected: []string{"password"}},
+		{Input: strongPassword, Expected: nil},
+		{Input: CreateUserInput{}, Expected: []string{"name", "email", "address", "age", "phoneNumber"}},
 	}
 
 	for _, test := range tests {
 		t.Run(test.Input.Name+"_"+test.Input.Address, func(t *testing.T) {
-			// Mock Gin context
-			c, _ := gin.CreateTestContext(httptest.NewRecorder())
-
-			// Invoke validation function
-			validateCreateInput(c, &test.Input)
+			var fields []string
+			for _, field := range fieldErrors(binding.Validator.ValidateStruct(&test.Input)) {
+				fields = append(fields, field.Field)
+			}
 
-			// Check if error is expected
-			assert.Equal(t, test.Expected, c.Writer.Status())
+			assert.Equal(t, test.Expected, fields)
 		})
 	}
 }
 
 func TestValidateUpdateInput(t *testing.T) {
-	type testData struct {
-		Input    UpdateUserInput
-		Expected bool
-	}
+	useValidators()
+	user := &models.User{Name: "test", Email: "test@gmail.com", Address: "jalan 123", Age: 24, PhoneNumber: "+62234567890"}
+	current, _ := json.Marshal(newUserDocument(user))
 
-	tests := []testData{
-		{Input: UpdateUserInput{Name: "A", Address: "Valid Address"}, Expected: false}, // Name too short
-		{Input: UpdateUserInput{Name: "Valid Name", Address: "A"}, Expected: false},    // Address too short
-		{Input: UpdateUserInput{Name: "Valid Name", Address: "Valid Address"}, Expected: true},
-		{Input: UpdateUserInput{Name: "", Address: "Valid Address"}, Expected: true}, // No validation error for empty name
+	tests := []struct {
+		Patch    string
+		Expected bool
+	}{
+		{Patch: `{"name": "A", "address": "Valid Address"}`, Expected: false}, // Name too short
+		{Patch: `{"name": "Valid Name", "address": "A"}`, Expected: false},    // Address too short
+		{Patch: `{"name": "Valid Name", "address": "Valid Address"}`, Expected: true},
+		{Patch: `{"address": null, "age": null}`, Expected: true}, // Optional fields can be cleared
+		{Patch: `{"age": 0}`, Expected: true},
+		{Patch: `{"name": null}`, Expected: false}, // Required fields can't
+		{Patch: `{"email": "wrong-email"}`, Expected: false},
+		{Patch: `{}`, Expected: true},
 	}
 
 	for _, test := range tests {
-		t.Run(test.Input.Name+"_"+test.Input.Address, func(t *testing.T) {
-			// Mock Gin context
-			c, _ := gin.CreateTestContext(httptest.NewRecorder())
-			validateUpdateInput(c, &test.Input)
+		t.Run(test.Patch, func(t *testing.T) {
+			patched, err := jsonpatch.MergePatch(current, []byte(test.Patch))
+			assert.NoError(t, err)
+			var doc userDocument
+			assert.NoError(t, json.Unmarshal(patched, &doc))
+			fields := fieldErrors(binding.Validator.ValidateStruct(&doc))
 
 			// Check if error is expected
-			if test.Expected {
-				assert.Equal(t, http.StatusOK, c.Writer.Status())
-			} else {
-				assert.Equal(t, http.StatusBadRequest, c.Writer.Status())
-			}
+			assert.Equal(t, test.Expected, len(fields) == 0)
 		})
 	}
 }
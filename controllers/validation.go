@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"crud/user/auth"
+	"crud/user/policy"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes one invalid field of a request body.
+type FieldError struct {
+	Field   string `json:"field" example:"email"`
+	Code    string `json:"code" example:"invalid_email"`
+	Message string `json:"message" example:"email should be a valid email address"`
+}
+
+// RegisterValidators installs the custom validations and reports fields
+// by their JSON name, so FieldError.Field matches the request body.
+func RegisterValidators(v *validator.Validate) {
+	v.RegisterValidation("email", ValidateEmail)
+	v.RegisterValidation("e164", ValidatePhoneNumber)
+	v.RegisterValidation("password", ValidatePassword)
+	v.RegisterValidation("scope", ValidateScope)
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+}
+
+// Custom validation function for email
+// Only check when email not empty
+func ValidateEmail(fl validator.FieldLevel) bool {
+	email := fl.Field().String()
+	if email == "" {
+		return true // Skip validation if the email is empty
+	}
+	err := validator.New().Var(email, "email")
+	return err == nil
+}
+
+// Custom validation function for phone number
+// Only check when phone number not empty
+func ValidatePhoneNumber(fl validator.FieldLevel) bool {
+	phoneNumber := fl.Field().String()
+	if phoneNumber == "" {
+		return true // Skip validation if the phone number is empty
+	}
+	err := validator.New().Var(phoneNumber, "e164")
+	return err == nil
+}
+
+// Custom validation function for passwords, see auth.CheckPasswordStrength
+func ValidatePassword(fl validator.FieldLevel) bool {
+	return auth.CheckPasswordStrength(fl.Field().String()) == nil
+}
+
+// Custom validation function for API key scopes, see policy.Scopes
+func ValidateScope(fl validator.FieldLevel) bool {
+	for _, scope := range policy.Scopes {
+		if fl.Field().String() == string(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// bindJSON decodes and validates the request body into obj. When that fails it
+// aborts with 400, listing every invalid field, and returns false.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+	abortWithBindError(c, obj, err)
+	return false
+}
+
+// bindDocument decodes and validates raw into obj like bindJSON, refusing
+// fields obj doesn't have.
+func bindDocument(c *gin.Context, raw []byte, obj interface{}) bool {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(obj)
+	if err == nil {
+		err = binding.Validator.ValidateStruct(obj)
+	}
+	if err == nil {
+		return true
+	}
+	abortWithBindError(c, obj, err)
+	return false
+}
+
+// abortWithBindError aborts with 400, reporting why obj couldn't be decoded or validated.
+func abortWithBindError(c *gin.Context, obj interface{}, err error) {
+	// A value of the wrong type stops decoding, validate the rest anyway
+	// so the client learns about every field at once
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		fields := []FieldError{{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: fmt.Sprintf("%s should be a %s that fits the field", typeErr.Field, jsonType(typeErr.Type)),
+		}}
+		for _, field := range fieldErrors(binding.Validator.ValidateStruct(obj)) {
+			if field.Field != typeErr.Field {
+				fields = append(fields, field)
+			}
+		}
+		abortWithFieldErrors(c, fields)
+		return
+	}
+
+	// encoding/json doesn't type this error, only its message names the field
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		field = strings.Trim(field, `"`)
+		abortWithFieldErrors(c, []FieldError{{Field: field, Code: "unknown_field", Message: fmt.Sprintf("%s can't be set", field)}})
+		return
+	}
+
+	if fields := fieldErrors(err); fields != nil {
+		abortWithFieldErrors(c, fields)
+		return
+	}
+
+	abortWithBadRequest(c, errors.New("request body should be a JSON object"))
+}
+
+func abortWithFieldErrors(c *gin.Context, fields []FieldError) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{
+		Code:    http.StatusBadRequest,
+		Error:   "validation_failed",
+		Message: "request body has invalid fields",
+		Fields:  fields,
+	})
+}
+
+// fieldErrors converts the validation errors in err into FieldErrors.
+// It returns nil when err doesn't come from validation.
+func fieldErrors(err error) []FieldError {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+
+	fields := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, newFieldError(fe))
+	}
+	return fields
+}
+
+func newFieldError(fe validator.FieldError) FieldError {
+	field := fe.Field()
+	isString := fe.Kind() == reflect.String
+
+	switch fe.Tag() {
+	case "required":
+		return FieldError{Field: field, Code: "required", Message: fmt.Sprintf("%s is required", field)}
+	case "min":
+		if isString {
+			return FieldError{Field: field, Code: "too_short", Message: fmt.Sprintf("%s should be at least %s characters", field, fe.Param())}
+		}
+		return FieldError{Field: field, Code: "out_of_range", Message: fmt.Sprintf("%s should be at least %s", field, fe.Param())}
+	case "max":
+		if isString {
+			return FieldError{Field: field, Code: "too_long", Message: fmt.Sprintf("%s should be at most %s characters", field, fe.Param())}
+		}
+		return FieldError{Field: field, Code: "out_of_range", Message: fmt.Sprintf("%s should be at most %s", field, fe.Param())}
+	case "len":
+		return FieldError{Field: field, Code: "wrong_length", Message: fmt.Sprintf("%s should be %s characters", field, fe.Param())}
+	case "numeric":
+		return FieldError{Field: field, Code: "not_numeric", Message: fmt.Sprintf("%s should only contain digits", field)}
+	case "email":
+		return FieldError{Field: field, Code: "invalid_email", Message: fmt.Sprintf("%s should be a valid email address", field)}
+	case "e164":
+		return FieldError{Field: field, Code: "invalid_phone_number", Message: fmt.Sprintf("%s should be an E.164 phone number like +6285155678965", field)}
+	case "password":
+		return FieldError{Field: field, Code: "weak_password", Message: fmt.Sprintf("%s should be %d to %d characters long and mix at least three of lowercase letters, uppercase letters, digits and symbols", field, auth.MinPasswordLength, auth.MaxPasswordLength)}
+	case "scope":
+		return FieldError{Field: field, Code: "unknown_scope", Message: fmt.Sprintf("%s should be one of %s", field, scopeNames())}
+	default:
+		return FieldError{Field: field, Code: "invalid", Message: fmt.Sprintf("%s is invalid", field)}
+	}
+}
+
+// scopeNames lists policy.Scopes for messages.
+func scopeNames() string {
+	names := make([]string, len(policy.Scopes))
+	for i, scope := range policy.Scopes {
+		names[i] = string(scope)
+	}
+	return strings.Join(names, ", ")
+}
+
+// jsonType names the JSON type a Go type is decoded from.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "whole number"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	default:
+		return "valid value"
+	}
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"crud/user/policy"
+	"crud/user/repository"
+	"crud/user/verification"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VerifyEmailInput struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ConfirmPhoneInput struct {
+	Code string `json:"code" binding:"required,len=6,numeric" example:"123456"`
+}
+
+type VerificationController struct {
+	users  repository.UserRepository
+	emails *verification.EmailVerifier
+	phones *verification.PhoneVerifier
+}
+
+func NewVerificationController(users repository.UserRepository, emails *verification.EmailVerifier, phones *verification.PhoneVerifier) *VerificationController {
+	return &VerificationController{users: users, emails: emails, phones: phones}
+}
+
+// SendEmailVerification godoc
+// @Summary      Resend email verification
+// @Description  mail the user a new verification token, the ones sent before stop working
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Security     BearerAuth
+// @Success      200  {object}  boolean
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      409  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id}/email/verification [post]
+func (ctl *VerificationController) SendEmailVerification(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.VerifyEmail, id) {
+		return
+	}
+
+	user, err := ctl.users.Find(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	err = ctl.emails.Send(c.Request.Context(), user)
+	if errors.Is(err, verification.ErrAlreadyVerified) {
+		abortWithStatus(c, http.StatusConflict, "already_verified", err)
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
+
+// VerifyEmail godoc
+// @Summary      Verify email
+// @Description  confirm the email address of a user with the token mailed to it. Every token works once.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param 			 request body controllers.VerifyEmailInput true "body"
+// @Success      200  {object}  models.User
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/auth/verify-email [post]
+func (ctl *VerificationController) VerifyEmail(c *gin.Context) {
+	var input VerifyEmailInput
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	user, err := ctl.emails.Confirm(c.Request.Context(), input.Token)
+	if errors.Is(err, verification.ErrInvalidToken) {
+		abortWithStatus(c, http.StatusBadRequest, "invalid_token", err)
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// SendPhoneVerification godoc
+// @Summary      Send phone verification code
+// @Description  text a one-time code to the phone number of the user, the code sent before stops working
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Security     BearerAuth
+// @Success      200  {object}  boolean
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      404  {object}  controllers.ErrorResponse
+// @Failure      409  {object}  controllers.ErrorResponse
+// @Failure      429  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id}/phone/verification [post]
+func (ctl *VerificationController) SendPhoneVerification(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.VerifyPhone, id) {
+		return
+	}
+
+	user, err := ctl.users.Find(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	err = ctl.phones.Send(c.Request.Context(), user)
+	if errors.Is(err, verification.ErrPhoneAlreadyVerified) {
+		abortWithStatus(c, http.StatusConflict, "already_verified", err)
+		return
+	}
+	if abortWhenLocked(c, err) {
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
+
+// ConfirmPhone godoc
+// @Summary      Verify phone number
+// @Description  confirm the phone number of the user with the code texted to it. Too many wrong codes lock the user out for a while.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Param 			 request body controllers.ConfirmPhoneInput true "body"
+// @Security     BearerAuth
+// @Success      200  {object}  models.User
+// @Failure      400  {object}  controllers.ErrorResponse
+// @Failure      401  {object}  controllers.ErrorResponse
+// @Failure      403  {object}  controllers.ErrorResponse
+// @Failure      429  {object}  controllers.ErrorResponse
+// @Failure      500  {object}  controllers.ErrorResponse
+// @Failure      503  {object}  controllers.ErrorResponse
+// @Router       /v1/users/{id}/phone/verification/confirm [post]
+func (ctl *VerificationController) ConfirmPhone(c *gin.Context) {
+	id, err := userID(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if !authorize(c, policy.VerifyPhone, id) {
+		return
+	}
+	var input ConfirmPhoneInput
+	if !bindJSON(c, &input) {
+		return
+	}
+
+	user, err := ctl.phones.Confirm(c.Request.Context(), id, input.Code)
+	if errors.Is(err, verification.ErrInvalidCode) {
+		abortWithStatus(c, http.StatusBadRequest, "invalid_code", err)
+		return
+	}
+	if abortWhenLocked(c, err) {
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// abortWhenLocked answers 429 with a Retry-After header when err is a
+// verification.LockedError, reporting whether it did.
+func abortWhenLocked(c *gin.Context, err error) bool {
+	var locked *verification.LockedError
+	if !errors.As(err, &locked) {
+		return false
+	}
+	seconds := math.Ceil(time.Until(locked.Until).Seconds())
+	c.Header("Retry-After", strconv.Itoa(int(math.Max(seconds, 1))))
+	abortWithStatus(c, http.StatusTooManyRequests, "too_many_attempts", err)
+	return true
+}
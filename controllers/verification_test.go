@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"crud/user/mail"
+	"crud/user/models"
+	"crud/user/repository"
+	"crud/user/sms"
+	"crud/user/verification"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// outbox keeps the messages sent to it instead of mailing them
+type outbox struct {
+	messages []mail.Message
+}
+
+func (o *outbox) Send(ctx context.Context, msg mail.Message) error {
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+var mailedToken = regexp.MustCompile(`\?token=([A-Za-z0-9_-]+)`)
+
+// lastToken returns the token in the last message sent
+func (o *outbox) lastToken(t *testing.T) string {
+	assert.NotEmpty(t, o.messages)
+	match := mailedToken.FindStringSubmatch(o.messages[len(o.messages)-1].Body)
+	assert.NotNil(t, match)
+	return match[1]
+}
+
+func testEmails(users repository.UserRepository, mailer mail.Mailer) *verification.EmailVerifier {
+	return verification.NewEmailVerifier(users, repository.NewMemoryTokenRepository(), mailer, time.Hour, "https://app.example.com/verify-email")
+}
+
+// texts keeps the text messages sent to it instead of sending them
+type texts struct {
+	messages []sms.Message
+}
+
+func (s *texts) Send(ctx context.Context, msg sms.Message) error {
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+var textedCode = regexp.MustCompile(`code is (\d{6})\.`)
+
+func TestEmailVerification(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useValidators()
+	users := repository.NewMemoryUserRepository()
+	inbox := &outbox{}
+	ctl := NewVerificationController(users, testEmails(users, inbox), nil)
+
+	r := gin.New()
+	r.POST("/v1/auth/verify-email", ctl.VerifyEmail)
+	authenticated := r.Group("/v1", Authenticate(testTokens, nil))
+	authenticated.POST("/users/:id/email/verification", ctl.SendEmailVerification)
+
+	request := func(path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, users.Create(context.Background(), &user))
+
+	// Users and operators may ask for a token, other users may not
+	assert.Equal(t, http.StatusForbidden, request("/v1/users/1/email/verification", "user-2").Code)
+	assert.Equal(t, http.StatusNotFound, request("/v1/users/9/email/verification", "operator").Code)
+	assert.Equal(t, http.StatusOK, request("/v1/users/1/email/verification", "operator").Code)
+	assert.Equal(t, http.StatusOK, request("/v1/users/1/email/verification", "user-1").Code)
+	assert.Len(t, inbox.messages, 2)
+	token := inbox.lastToken(t)
+
+	w := postJSON(r, "/v1/auth/verify-email", VerifyEmailInput{Token: token})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Data models.User `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.NotNil(t, body.Data.EmailVerifiedAt)
+
+	// Tokens work once
+	w = postJSON(r, "/v1/auth/verify-email", VerifyEmailInput{Token: token})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"code":400,"error":"invalid_token","message":"the verification token is invalid or expired"}`, w.Body.String())
+
+	w = request("/v1/users/1/email/verification", "user-1")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "already_verified")
+
+	assert.Equal(t, http.StatusBadRequest, postJSON(r, "/v1/auth/verify-email", VerifyEmailInput{}).Code)
+}
+
+func TestPhoneVerification(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useValidators()
+	users := repository.NewMemoryUserRepository()
+	sent := &texts{}
+	phones := verification.NewPhoneVerifier(users, repository.NewMemoryPhoneCodeRepository(), sent,
+		verification.PhoneConfig{TTL: time.Minute, MaxAttempts: 2, Lockout: time.Hour})
+	ctl := NewVerificationController(users, nil, phones)
+
+	r := gin.New()
+	r.Use(Authenticate(testTokens, nil))
+	r.POST("/v1/users/:id/phone/verification", ctl.SendPhoneVerification)
+	r.POST("/v1/users/:id/phone/verification/confirm", ctl.ConfirmPhone)
+
+	request := func(path, token string, body interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(raw))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	lastCode := func() string {
+		match := textedCode.FindStringSubmatch(sent.messages[len(sent.messages)-1].Body)
+		assert.NotNil(t, match)
+		return match[1]
+	}
+	wrongCode := func() string {
+		if lastCode() == "000000" {
+			return "111111"
+		}
+		return "000000"
+	}
+
+	for _, phoneNumber := range []string{"+62234567890", "+62234567891"} {
+		user := models.User{Name: "test", Email: phoneNumber + "@gmail.com", PhoneNumber: phoneNumber}
+		assert.NoError(t, users.Create(context.Background(), &user))
+	}
+
+	// Only the user can ask for a code
+	assert.Equal(t, http.StatusForbidden, request("/v1/users/1/phone/verification", "operator", nil).Code)
+	assert.Equal(t, http.StatusOK, request("/v1/users/1/phone/verification", "user-1", nil).Code)
+	assert.Equal(t, "+62234567890", sent.messages[0].To)
+
+	w := request("/v1/users/1/phone/verification/confirm", "user-1", ConfirmPhoneInput{Code: "12345a"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "not_numeric")
+	w = request("/v1/users/1/phone/verification/confirm", "user-1", ConfirmPhoneInput{Code: wrongCode()})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_code")
+
+	w = request("/v1/users/1/phone/verification/confirm", "user-1", ConfirmPhoneInput{Code: lastCode()})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Data models.User `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.NotNil(t, body.Data.PhoneVerifiedAt)
+	assert.Equal(t, http.StatusConflict, request("/v1/users/1/phone/verification", "user-1", nil).Code)
+
+	// Too many wrong codes lock the user out
+	assert.Equal(t, http.StatusOK, request("/v1/users/2/phone/verification", "user-2", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, request("/v1/users/2/phone/verification/confirm", "user-2", ConfirmPhoneInput{Code: wrongCode()}).Code)
+	w = request("/v1/users/2/phone/verification/confirm", "user-2", ConfirmPhoneInput{Code: wrongCode()})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "too_many_attempts")
+	assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, request("/v1/users/2/phone/verification", "user-2", nil).Code)
+}
@@ -0,0 +1,133 @@
+// Package health tracks whether the service and its dependencies can take traffic.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// State is the last reported condition of one dependency.
+type State struct {
+	Ready   bool
+	Message string
+	Since   time.Time
+}
+
+// Check reports why a dependency can't be used, nil when it can.
+type Check func(ctx context.Context) error
+
+// serverDependency is the state of the HTTP server itself, see Serving and Drain.
+const serverDependency = "server"
+
+// Readiness collects the state of named dependencies, like "database".
+// States are either reported with Set or refreshed by running the registered checks.
+// The service is ready once every dependency that reported is ready.
+type Readiness struct {
+	mu     sync.RWMutex
+	states map[string]State
+	checks map[string]Check
+	now    func() time.Time
+}
+
+func NewReadiness() *Readiness {
+	return &Readiness{states: map[string]State{}, checks: map[string]Check{}, now: time.Now}
+}
+
+// AddCheck registers a check run by Check under the given dependency name.
+func (r *Readiness) AddCheck(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+}
+
+// Serving reports the server as accepting requests.
+func (r *Readiness) Serving() {
+	r.Set(serverDependency, true, "accepting requests")
+}
+
+// Drain reports the server as shutting down, so traffic is routed elsewhere
+// while in-flight requests finish.
+func (r *Readiness) Drain() {
+	r.Set(serverDependency, false, "draining")
+}
+
+// Check runs every registered check concurrently, each given at most timeout,
+// records their results and returns every state along with whether the service is ready.
+func (r *Readiness) Check(ctx context.Context, timeout time.Duration) (bool, map[string]State) {
+	r.mu.RLock()
+	checks := make(map[string]Check, len(r.checks))
+	for name, check := range r.checks {
+		checks[name] = check
+	}
+	r.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			if err := check(ctx); err != nil {
+				r.Set(name, false, err.Error())
+				return
+			}
+			r.Set(name, true, "ok")
+		}(name, check)
+	}
+	wg.Wait()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ready(), r.copyStates()
+}
+
+// Set records the state of a dependency. Since only moves when Ready flips.
+func (r *Readiness) Set(name string, ready bool, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.states[name]
+	if !ok || state.Ready != ready {
+		state.Since = r.now()
+	}
+	state.Ready = ready
+	state.Message = message
+	r.states[name] = state
+}
+
+// Ready reports whether at least one dependency reported and all of them are ready.
+func (r *Readiness) Ready() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ready()
+}
+
+func (r *Readiness) ready() bool {
+	if len(r.states) == 0 {
+		return false
+	}
+	for _, state := range r.states {
+		if !state.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// States returns a copy of every reported state, keyed by dependency.
+func (r *Readiness) States() map[string]State {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.copyStates()
+}
+
+func (r *Readiness) copyStates() map[string]State {
+	states := make(map[string]State, len(r.states))
+	for name, state := range r.states {
+		states[name] = state
+	}
+	return states
+}
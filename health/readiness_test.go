@@ -0,0 +1,55 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadiness(t *testing.T) {
+	readiness := NewReadiness()
+	assert.False(t, readiness.Ready(), "nothing reported yet")
+
+	start := time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC)
+	readiness.now = func() time.Time { return start }
+	readiness.Set("database", false, "connecting")
+	assert.False(t, readiness.Ready())
+
+	readiness.now = func() time.Time { return start.Add(time.Minute) }
+	readiness.Set("database", true, "connected")
+	assert.True(t, readiness.Ready())
+
+	// Since only moves when the state flips
+	readiness.now = func() time.Time { return start.Add(time.Hour) }
+	readiness.Set("database", true, "still connected")
+	assert.Equal(t, State{Ready: true, Message: "still connected", Since: start.Add(time.Minute)}, readiness.States()["database"])
+
+	readiness.Set("cache", false, "warming up")
+	assert.False(t, readiness.Ready())
+}
+
+func TestCheck(t *testing.T) {
+	readiness := NewReadiness()
+	readiness.Serving()
+	readiness.AddCheck("database", func(ctx context.Context) error { return nil })
+	readiness.AddCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ready, states := readiness.Check(context.Background(), 10*time.Millisecond)
+	assert.False(t, ready)
+	assert.True(t, states["database"].Ready)
+	assert.Equal(t, "context deadline exceeded", states["slow"].Message)
+
+	readiness.AddCheck("slow", func(ctx context.Context) error { return nil })
+	ready, _ = readiness.Check(context.Background(), 10*time.Millisecond)
+	assert.True(t, ready)
+
+	readiness.Drain()
+	ready, states = readiness.Check(context.Background(), 10*time.Millisecond)
+	assert.False(t, ready)
+	assert.Equal(t, "draining", states["server"].Message)
+}
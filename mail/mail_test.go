@@ -0,0 +1,98 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFileMailer(dir, "noreply@example.com")
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.Send(context.Background(), Message{To: "test@gmail.com", Subject: "Hello", Body: "line one\nline two"}))
+	assert.NoError(t, m.Send(context.Background(), Message{To: "test@gmail.com", Subject: "Again", Body: "body"}))
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	raw, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	assert.NoError(t, err)
+	assert.Contains(t, string(raw), "From: noreply@example.com\r\nTo: test@gmail.com\r\nSubject: Hello\r\n")
+	assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\nline one\r\nline two"))
+
+	err = m.Send(context.Background(), Message{To: "test@gmail.com\r\nBcc: everyone@example.com", Subject: "Hello"})
+	assert.ErrorContains(t, err, "line break")
+}
+
+// fakeSMTP accepts one message and hands back the conversation.
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	received := make(chan string, 1)
+
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		var transcript strings.Builder
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 fake ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				received <- transcript.String()
+				return
+			}
+			transcript.WriteString(line)
+			switch {
+			case inData && line == ".\r\n":
+				inData = false
+				reply("250 queued")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 fake")
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				reply("354 go ahead")
+			case strings.HasPrefix(line, "QUIT"):
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+
+	m := NewSMTPMailer(SMTPConfig{Host: host, Port: portNumber, From: "noreply@example.com"})
+	err := m.Send(context.Background(), Message{To: "test@gmail.com", Subject: "Verify", Body: "click"})
+	assert.NoError(t, err)
+
+	transcript := <-received
+	assert.Contains(t, transcript, "MAIL FROM:<noreply@example.com>")
+	assert.Contains(t, transcript, "RCPT TO:<test@gmail.com>")
+	assert.Contains(t, transcript, "Subject: Verify\r\n")
+}
@@ -1,9 +1,31 @@
 package main
 
 import (
+	"context"
+	"crud/user/apikey"
+	"crud/user/auth"
+	"crud/user/config"
 	"crud/user/controllers"
+	"crud/user/health"
+	"crud/user/mail"
+	"crud/user/migrations"
 	"crud/user/models"
+	"crud/user/purge"
+	"crud/user/repository"
+	"crud/user/server"
+	"crud/user/sms"
+	"crud/user/verification"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"crud/user/docs"
 
@@ -24,42 +46,268 @@ import (
 
 // @license.name  Apache 2.0
 // @license.url   http://www.apache.org/licenses/LICENSE-2.0.html
+
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
+// @description                 JWT sent as "Bearer <token>"
+
+// @securityDefinitions.apikey  ApiKeyAuth
+// @in                          header
+// @name                        X-API-Key
+// @description                 API key of a service, created by an administrator
 func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "optional YAML or JSON config file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if flag.Arg(0) == "migrate" {
+		migrate(cfg, flag.Args()[1:])
+		return
+	}
+
+	// SIGINT or SIGTERM cancels ctx, which starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// programmatically set swagger info
 	docs.SwaggerInfo.Title = "User API"
 	docs.SwaggerInfo.Description = "This is a CRUD User."
 	docs.SwaggerInfo.Version = "1.0"
-	docs.SwaggerInfo.Host = "localhost:8080"
+	docs.SwaggerInfo.Host = cfg.Server.PublicHost
 	docs.SwaggerInfo.BasePath = ""
 	docs.SwaggerInfo.Schemes = []string{"http", "https"}
 	route := gin.Default()
+	route.Use(controllers.RequestID())
 
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
-		v.RegisterValidation("email", controllers.ValidateEmail)
-		v.RegisterValidation("e164", controllers.ValidatePhoneNumber)
+		controllers.RegisterValidators(v)
+	}
+
+	readiness := health.NewReadiness()
+	db, err := models.ConnectDatabase(ctx, cfg.Database, readiness, log.Default())
+	if err != nil {
+		log.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	migrator, err := migrations.New(sqlDB, migrations.Files, log.Default())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Features.AutoMigrate {
+		if _, err := migrator.Up(ctx); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	models.ConnectDatabase()
+	readiness.AddCheck(models.DatabaseDependency, sqlDB.PingContext)
+	readiness.AddCheck("migrations", func(ctx context.Context) error {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d migrations pending, newest is %s", len(pending), pending[len(pending)-1])
+		}
+		return nil
+	})
+	probes := controllers.NewHealthController(readiness, cfg.Server.HealthCheckTimeout)
+
+	passwords, err := auth.NewPasswords(cfg.Auth.BcryptCost)
+	if err != nil {
+		log.Fatal(err)
+	}
+	userRepository := repository.NewGormUserRepository(db)
+	tokenRepository := repository.NewGormTokenRepository(db)
+	sessionRepository := repository.NewGormSessionRepository(db)
+	mailer, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+	emails := verification.NewEmailVerifier(userRepository, tokenRepository, mailer,
+		cfg.Auth.EmailVerificationTTL, cfg.Mail.VerifyEmailURL)
+	users := controllers.NewUserController(userRepository, sessionRepository, passwords, emails)
+	// Texts are only printed until an SMS provider implements sms.Sender
+	phones := verification.NewPhoneVerifier(userRepository, repository.NewGormPhoneCodeRepository(db), sms.NewConsoleSender(log.Default()),
+		verification.PhoneConfig{
+			TTL:         cfg.Auth.PhoneCodeTTL,
+			MaxAttempts: cfg.Auth.PhoneCodeMaxAttempts,
+			Lockout:     cfg.Auth.PhoneCodeLockout,
+		})
+	verifications := controllers.NewVerificationController(userRepository, emails, phones)
+	sessions := controllers.NewSessionController(sessionRepository)
+	resetter := verification.NewPasswordResetter(userRepository, tokenRepository, sessionRepository,
+		passwords, mailer, cfg.Auth.PasswordResetTTL, cfg.Mail.ResetPasswordURL)
+	// Forgot-password requests beyond the 100 waiting are dropped
+	resetQueue := verification.NewResetQueue(resetter, 100, log.Default())
+	resetQueue.Start(context.Background())
+	resets := controllers.NewPasswordResetController(resetter, resetQueue)
+
+	purger := purge.NewWorker(userRepository, purge.Config{
+		Retention: cfg.Purge.Retention,
+		Interval:  cfg.Purge.Interval,
+		BatchSize: cfg.Purge.BatchSize,
+	}, log.Default())
+	if cfg.Features.Purge {
+		purger.Start(context.Background())
+	}
+	admin := controllers.NewAdminController(purger)
+	apiKeyRepository := repository.NewGormAPIKeyRepository(db)
+	apiKeyManager := apikey.NewManager(apiKeyRepository)
+	apiKeys := controllers.NewAPIKeyController(apiKeyManager, apiKeyRepository)
+	idempotent := controllers.Idempotent(repository.NewGormIdempotencyKeyRepository(db), controllers.IdempotencyConfig{
+		KeyTTL:      cfg.Idempotency.KeyTTL,
+		LockTimeout: cfg.Idempotency.LockTimeout,
+		MaxBodySize: int64(cfg.Idempotency.MaxBodySize),
+	})
 
 	v1 := route.Group("/v1")
-	{
-		v1.GET("/ping", func(context *gin.Context) {
-			context.JSON(http.StatusOK, gin.H{
-				"message": "pong",
-			})
+	v1.GET("/ping", func(context *gin.Context) {
+		context.JSON(http.StatusOK, gin.H{
+			"message": "pong",
 		})
-		v1.GET("/users", controllers.FindUsers)
-		v1.POST("/users", controllers.CreateUsers)
-		v1.GET("/users/:id", controllers.FindUser)
-		v1.PATCH("/users/:id", controllers.UpdateUser)
-		v1.DELETE("/users/:id", controllers.DeleteUser)
+	})
+	// The mailed tokens prove who is calling, so these are public
+	v1.POST("/auth/verify-email", verifications.VerifyEmail)
+	v1.POST("/auth/forgot-password", resets.ForgotPassword)
+	v1.POST("/auth/reset-password", resets.ResetPassword)
+
+	if cfg.Features.Auth {
+		authConfig := auth.Config{
+			HMACSecretFile:  cfg.Auth.HMACSecretFile,
+			PublicKeyFile:   cfg.Auth.PublicKeyFile,
+			JWKSFile:        cfg.Auth.JWKSFile,
+			PrivateKeyFile:  cfg.Auth.PrivateKeyFile,
+			KeyID:           cfg.Auth.KeyID,
+			AccessTokenTTL:  cfg.Auth.AccessTokenTTL,
+			RefreshTokenTTL: cfg.Auth.RefreshTokenTTL,
+			Issuer:          cfg.Auth.Issuer,
+			Audience:        cfg.Auth.Audience,
+			Leeway:          cfg.Auth.Leeway,
+		}
+		verifier, err := auth.NewVerifier(authConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Logging in is public, and only possible when this service can sign tokens
+		issuer, err := auth.NewIssuer(authConfig)
+		switch {
+		case errors.Is(err, auth.ErrNoSigningKey):
+			log.Print("auth: no HS256 secret or RS256 private key, logging in is off")
+		case err != nil:
+			log.Fatal(err)
+		default:
+			login := controllers.NewAuthController(userRepository, sessionRepository, passwords, issuer)
+			v1.POST("/auth/login", login.Login)
+			v1.POST("/auth/refresh", login.Refresh)
+		}
+
+		v1.Use(controllers.Authenticate(verifier, apiKeyManager))
+	} else {
+		log.Print("auth: FEATURE_AUTH is off, every /v1 request acts as an administrator")
+		v1.Use(controllers.AnonymousAdmin())
 	}
+	{
+		v1.GET("/users", users.FindUsers)
+		v1.GET("/users/trash", users.FindDeletedUsers)
+		v1.POST("/users", idempotent, users.CreateUsers)
+		v1.GET("/users/:id", users.FindUser)
+		v1.PATCH("/users/:id", users.UpdateUser)
+		v1.PUT("/users/:id", users.ReplaceUser)
+		v1.PUT("/users/external/:externalId", users.UpsertUser)
+		v1.GET("/users/:id/history", users.FindUserHistory)
+		v1.POST("/users/:id/password", users.ChangePassword)
+		v1.POST("/users/:id/email/verification", verifications.SendEmailVerification)
+		v1.POST("/users/:id/phone/verification", verifications.SendPhoneVerification)
+		v1.POST("/users/:id/phone/verification/confirm", verifications.ConfirmPhone)
+		v1.GET("/users/:id/sessions", sessions.ListSessions)
+		v1.DELETE("/users/:id/sessions", sessions.RevokeSessions)
+		v1.DELETE("/users/:id/sessions/:sessionId", sessions.RevokeSession)
+		v1.DELETE("/users/:id", users.DeleteUser)
+		v1.POST("/users/:id/restore", users.RestoreUser)
+		v1.POST("/admin/purge", admin.PurgeDeletedUsers)
+		v1.GET("/admin/api-keys", apiKeys.ListAPIKeys)
+		v1.POST("/admin/api-keys", apiKeys.CreateAPIKey)
+		v1.POST("/admin/api-keys/:id/rotate", apiKeys.RotateAPIKey)
+		v1.DELETE("/admin/api-keys/:id", apiKeys.RevokeAPIKey)
+	}
+
+	route.GET("/healthz", probes.Liveness)
+	route.GET("/readyz", probes.Readiness)
 
 	// use ginSwagger middleware to serve the API docs
-	route.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	if cfg.Features.Swagger {
+		route.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	}
+
+	ln, err := net.Listen("tcp", cfg.Server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Handler: route, ReadHeaderTimeout: 10 * time.Second}
+	err = server.Run(ctx, srv, ln, readiness, server.Config{
+		DrainDelay:      cfg.Server.DrainDelay,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+	}, log.Default())
+	if err != nil {
+		log.Printf("server: %v", err)
+	}
+
+	// Requests are done, stop what they relied on
+	purger.Stop()
+	resetQueue.Stop()
+	if err := sqlDB.Close(); err != nil {
+		log.Printf("database: %v", err)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// newMailer returns the mailer chosen by MAIL_DRIVER.
+func newMailer(cfg config.MailConfig) (mail.Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return mail.NewSMTPMailer(mail.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}), nil
+	case "file":
+		return mail.NewFileMailer(cfg.Dir, cfg.From)
+	default:
+		return mail.NewLogMailer(log.Default()), nil
+	}
+}
 
-	err := route.Run(":8080")
+// migrate runs the migrate subcommand, like `go run main.go migrate up`.
+func migrate(cfg *config.Config, args []string) {
+	db, err := models.ConnectDatabase(context.Background(), cfg.Database, health.NewReadiness(), log.Default())
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	migrator, err := migrations.New(sqlDB, migrations.Files, log.New(io.Discard, "", 0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := migrations.Run(context.Background(), migrator, args, os.Stdout); err != nil {
+		sqlDB.Close()
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,84 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Usage describes the arguments Run accepts.
+const Usage = `usage: migrate <command>
+
+commands:
+  up             apply every pending migration
+  down [steps]   revert the newest applied migration, or the newest steps of them
+  to <version>   apply or revert migrations until version is the newest applied, 0 reverts all
+  status         list migrations and when they were applied`
+
+// Run carries out a migrate command line like "up" or "down 2", reporting to out.
+func Run(ctx context.Context, m *Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(Usage)
+	}
+
+	var done []Migration
+	var err error
+	var target int64
+	switch command := args[0]; {
+	case command == "up" && len(args) == 1:
+		done, err = m.Up(ctx)
+	case command == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("steps should be a positive number, got %q", args[1])
+			}
+		}
+		done, err = m.Down(ctx, steps)
+	case command == "to" && len(args) == 2:
+		target, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil || target < 0 {
+			return fmt.Errorf("version should be a migration version or 0, got %q", args[1])
+		}
+		done, err = m.To(ctx, target)
+	case command == "status" && len(args) == 1:
+		return printStatus(ctx, m, out)
+	default:
+		return errors.New(Usage)
+	}
+
+	if len(done) == 0 && err == nil {
+		fmt.Fprintln(out, "nothing to migrate")
+	}
+	for _, migration := range done {
+		direction := "up"
+		if args[0] == "down" || (args[0] == "to" && migration.Version > target) {
+			direction = "down"
+		}
+		fmt.Fprintf(out, "%s %s\n", direction, migration)
+	}
+	return err
+}
+
+func printStatus(ctx context.Context, m *Migrator, out io.Writer) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,170 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var testFiles = fstest.MapFS{
+	"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id bigserial)")},
+	"0001_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+	"0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email text")},
+	"0002_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP email")},
+	"0010_index_email.up.sql":    {Data: []byte("CREATE INDEX idx_users_email ON users (email)")},
+	"0010_index_email.down.sql":  {Data: []byte("DROP INDEX idx_users_email")},
+}
+
+func newTestMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, mock.ExpectationsWereMet())
+		db.Close()
+	})
+
+	m, err := New(db, testFiles, log.New(io.Discard, "", 0))
+	assert.NoError(t, err)
+	return m, mock
+}
+
+// expectLocked expects the lock, schema_migrations and the applied versions to be read.
+func expectLocked(mock sqlmock.Sqlmock, applied ...int64) {
+	mock.ExpectExec(`SELECT pg_advisory_lock\(\$1\)`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, version := range applied {
+		rows.AddRow(version, time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).WillReturnRows(rows)
+}
+
+func expectApply(mock sqlmock.Sqlmock, script string, version int64, name string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(script).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations \(version, name\) VALUES \(\$1, \$2\)`).WithArgs(version, name).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func expectRevert(mock sqlmock.Sqlmock, script string, version int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(script).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM schema_migrations WHERE version = \$1`).WithArgs(version).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testFiles)
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 3)
+	assert.Equal(t, "0010_index_email", migrations[2].String())
+
+	// The migrations shipped with the service load too
+	_, err = Load(Files)
+	assert.NoError(t, err)
+
+	_, err = Load(fstest.MapFS{"0001_create_users.up.sql": {Data: []byte("CREATE TABLE users ()")}})
+	assert.ErrorContains(t, err, "needs both an up and a down script")
+
+	_, err = Load(fstest.MapFS{"create_users.sql": {}})
+	assert.ErrorContains(t, err, "should be named")
+
+	_, err = Load(fstest.MapFS{
+		"0001_create_users.up.sql":  {Data: []byte("CREATE TABLE users ()")},
+		"0001_create_people.up.sql": {Data: []byte("CREATE TABLE people ()")},
+	})
+	assert.ErrorContains(t, err, "is used by both")
+}
+
+func TestUpAppliesPendingInOrder(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectLocked(mock, 1)
+	expectApply(mock, `ALTER TABLE users ADD email text`, 2, "add_email")
+	expectApply(mock, `CREATE INDEX idx_users_email`, 10, "index_email")
+	expectUnlock(mock)
+
+	done, err := m.Up(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, done, 2)
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectLocked(mock, 1, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE INDEX idx_users_email`).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	_, err := m.Up(context.Background())
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorContains(t, err, "migrate up 0010_index_email")
+}
+
+func TestToRevertsNewerMigrations(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectLocked(mock, 1, 2, 10)
+	expectRevert(mock, `DROP INDEX idx_users_email`, 10)
+	expectRevert(mock, `ALTER TABLE users DROP email`, 2)
+	expectUnlock(mock)
+
+	var out bytes.Buffer
+	assert.NoError(t, Run(context.Background(), m, []string{"to", "1"}, &out))
+	assert.Equal(t, "down 0010_index_email\ndown 0002_add_email\n", out.String())
+
+	_, err := m.To(context.Background(), 3)
+	assert.ErrorContains(t, err, "does not exist")
+}
+
+func TestDownRefusesUnknownVersions(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectLocked(mock, 1, 11)
+	expectUnlock(mock)
+
+	_, err := m.Down(context.Background(), 1)
+	assert.ErrorContains(t, err, "migration version 11 is applied but unknown to this build")
+}
+
+func TestRunStatus(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectLocked(mock, 1)
+	expectUnlock(mock)
+
+	var out bytes.Buffer
+	assert.NoError(t, Run(context.Background(), m, []string{"status"}, &out))
+	assert.Equal(t, `VERSION  NAME          APPLIED AT
+0001     create_users  2024-07-10T00:00:00Z
+0002     add_email     pending
+0010     index_email   pending
+`, out.String())
+
+	assert.EqualError(t, Run(context.Background(), m, []string{"sideways"}, &out), Usage)
+	assert.ErrorContains(t, Run(context.Background(), m, []string{"down", "none"}, &out), "steps should be a positive number")
+}
+
+func TestPending(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+
+	pending, err := m.Pending(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 10}, []int64{pending[0].Version, pending[1].Version})
+}
@@ -0,0 +1,15 @@
+-- Matches the table AutoMigrate used to create, so databases set up
+-- before versioned migrations existed are adopted as they are.
+CREATE TABLE IF NOT EXISTS users (
+    id           bigserial PRIMARY KEY,
+    name         text,
+    email        text,
+    address      text,
+    age          smallint,
+    phone_number text,
+    created_at   timestamptz,
+    updated_at   timestamptz,
+    deleted_at   timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
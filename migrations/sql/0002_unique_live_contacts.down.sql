@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_phone_number_live;
+DROP INDEX IF EXISTS idx_users_email_live;
@@ -0,0 +1,28 @@
+-- Email and phone number are unique among live users only,
+-- so a soft-deleted user doesn't keep them reserved.
+--
+-- Live users sharing an email, in any case, or a phone number fail the
+-- migration with a list of them. Soft-delete or edit all but one of each
+-- group, then run the migration again.
+DO $$
+DECLARE
+    conflicts text;
+BEGIN
+    SELECT string_agg(conflict, '; ') INTO conflicts FROM (
+        SELECT format('email %s: users %s', lower(email), string_agg(id::text, ', ' ORDER BY id)) AS conflict
+        FROM users WHERE deleted_at IS NULL
+        GROUP BY lower(email) HAVING count(*) > 1
+        UNION ALL
+        SELECT format('phone number %s: users %s', phone_number, string_agg(id::text, ', ' ORDER BY id))
+        FROM users WHERE deleted_at IS NULL
+        GROUP BY phone_number HAVING count(*) > 1
+    ) AS duplicates;
+
+    IF conflicts IS NOT NULL THEN
+        RAISE EXCEPTION 'live users share contacts, keep one user of each: %', conflicts;
+    END IF;
+END;
+$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_live ON users (lower(email)) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_phone_number_live ON users (phone_number) WHERE deleted_at IS NULL;
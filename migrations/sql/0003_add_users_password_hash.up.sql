@@ -0,0 +1,2 @@
+-- Users created before passwords existed get none and can't log in until one is set.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash text NOT NULL DEFAULT '';
@@ -0,0 +1,24 @@
+-- A session is one login on one device. Every refresh trades its refresh token
+-- for a new one, used tokens are kept to spot a stolen token being replayed.
+CREATE TABLE sessions (
+    id           bigserial PRIMARY KEY,
+    user_id      bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent   text NOT NULL DEFAULT '',
+    ip_address   text NOT NULL DEFAULT '',
+    created_at   timestamptz NOT NULL,
+    last_used_at timestamptz NOT NULL,
+    expires_at   timestamptz NOT NULL,
+    revoked_at   timestamptz
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+-- Only a SHA-256 of each refresh token is stored
+CREATE TABLE refresh_tokens (
+    token_hash text PRIMARY KEY,
+    session_id bigint NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+    created_at timestamptz NOT NULL,
+    used_at    timestamptz
+);
+
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens (session_id);
@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at timestamptz;
+
+-- Single-use tokens mailed to users, like email verification links. Only a
+-- SHA-256 of each token is stored. Target is what the token vouches for, like
+-- the email address it was sent to.
+CREATE TABLE one_time_tokens (
+    token_hash text PRIMARY KEY,
+    user_id    bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose    text NOT NULL,
+    target     text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz
+);
+
+CREATE INDEX idx_one_time_tokens_user_id_purpose ON one_time_tokens (user_id, purpose);
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_verified_at timestamptz;
+
+-- The one-time code texted to each user, only its SHA-256 is stored. Attempts
+-- counts wrong guesses since the last lockout, reaching the limit locks the
+-- user out until locked_until.
+CREATE TABLE phone_codes (
+    user_id      bigint PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    phone_number text NOT NULL,
+    code_hash    text NOT NULL DEFAULT '',
+    attempts     integer NOT NULL DEFAULT 0,
+    created_at   timestamptz NOT NULL,
+    expires_at   timestamptz NOT NULL,
+    locked_until timestamptz
+);
@@ -0,0 +1,15 @@
+-- API keys authenticate services rather than users. Keys are looked up by their
+-- public prefix, and only a SHA-256 of the whole key is stored. Scopes are
+-- space separated, like OAuth scopes.
+CREATE TABLE api_keys (
+    id           bigserial PRIMARY KEY,
+    name         text NOT NULL,
+    prefix       text NOT NULL,
+    key_hash     text NOT NULL,
+    scopes       text NOT NULL DEFAULT '',
+    created_at   timestamptz NOT NULL,
+    last_used_at timestamptz,
+    revoked_at   timestamptz
+);
+
+CREATE UNIQUE INDEX idx_api_keys_prefix ON api_keys (prefix);
@@ -0,0 +1,25 @@
+-- Every change to a user is appended here, in the transaction making it.
+-- Entries are never updated or deleted, and outlive the user they describe,
+-- so there is no foreign key. Changes maps each changed field to its value
+-- before and after.
+CREATE TABLE audit_entries (
+    id         bigserial PRIMARY KEY,
+    user_id    bigint NOT NULL,
+    action     text NOT NULL,
+    actor      text NOT NULL,
+    request_id text NOT NULL DEFAULT '',
+    changes    jsonb NOT NULL DEFAULT '{}',
+    created_at timestamptz NOT NULL
+);
+
+CREATE INDEX idx_audit_entries_user ON audit_entries (user_id, id);
+
+CREATE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_entries_append_only
+    BEFORE UPDATE OR DELETE ON audit_entries
+    FOR EACH ROW EXECUTE FUNCTION audit_entries_append_only();
@@ -0,0 +1,3 @@
+-- Version counts the updates of each user, it backs the ETag of the user and
+-- lets an update fail rather than overwrite one it didn't see.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
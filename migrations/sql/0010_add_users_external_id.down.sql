@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_external_id_live;
+ALTER TABLE users DROP COLUMN IF EXISTS external_id;
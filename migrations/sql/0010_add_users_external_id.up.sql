@@ -0,0 +1,5 @@
+-- The ID a client keeps for the user in its own system, which sync jobs
+-- upsert users by. Unique among live users, like email.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_external_id_live ON users (external_id) WHERE deleted_at IS NULL;
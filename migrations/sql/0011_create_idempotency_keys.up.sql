@@ -0,0 +1,20 @@
+-- The responses to requests sent with an Idempotency-Key, replayed when the
+-- request is retried. Keys are scoped to the caller that sent them, and the
+-- fingerprint is a SHA-256 of the request. The headers kept are those of the
+-- response that describe it, like ETag and Location. A key without a status code is
+-- taken by a request still running, until locked_until: a request whose
+-- process died is then retried.
+CREATE TABLE idempotency_keys (
+    scope        text NOT NULL,
+    key          text NOT NULL,
+    fingerprint  text NOT NULL,
+    status_code  integer NOT NULL DEFAULT 0,
+    headers      jsonb NOT NULL DEFAULT '{}',
+    response     bytea,
+    created_at   timestamptz NOT NULL,
+    locked_until timestamptz NOT NULL,
+    expires_at   timestamptz NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
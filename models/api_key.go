@@ -0,0 +1,42 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// APIKey lets a service call the API without a user token. Only the hash of
+// the key is kept, Prefix is the public part it is looked up and shown by.
+type APIKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey" example:"1"`
+	Name       string     `json:"name" example:"nightly-export"`
+	Prefix     string     `json:"prefix" example:"3f9a1c0d7b2e"`
+	KeyHash    string     `json:"-" swaggerignore:"true"`
+	Scopes     ScopeList  `json:"scopes" swaggertype:"array,string" example:"users:read"`
+	CreatedAt  time.Time  `json:"createdAt" example:"2024-07-10T04:24:55.405915+07:00"`
+	LastUsedAt *time.Time `json:"lastUsedAt" example:"2024-07-10T04:30:12.105915+07:00"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// ScopeList is stored space separated, like OAuth scopes.
+type ScopeList []string
+
+func (s ScopeList) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+func (s *ScopeList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+	case string:
+		*s = strings.Fields(v)
+	case []byte:
+		*s = strings.Fields(string(v))
+	default:
+		return fmt.Errorf("scan %T into ScopeList", value)
+	}
+	return nil
+}
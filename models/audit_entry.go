@@ -0,0 +1,67 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Actions recorded in AuditEntry.Action
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditDelete     = "delete"
+	AuditRestore    = "restore"
+	AuditHardDelete = "hard_delete"
+)
+
+// AuditEntry records one change to a user: who made it, from which request,
+// and the fields it changed. Entries are only ever appended.
+type AuditEntry struct {
+	ID     uint   `json:"id" gorm:"primaryKey" example:"1"`
+	UserID uint   `json:"userId" example:"1"`
+	Action string `json:"action" example:"update"`
+	// Actor is user:<id>, api_key:<id>, client:<subject>, anonymous or system
+	Actor     string    `json:"actor" example:"user:1"`
+	RequestID string    `json:"requestId" example:"3f9a1c0d7b2e4f6a8c1d3e5f7a9b0c2d"`
+	Changes   Changes   `json:"changes" swaggertype:"object"`
+	CreatedAt time.Time `json:"createdAt" example:"2024-07-10T04:24:55.405915+07:00"`
+}
+
+// Change is the JSON value of a field before and after a change.
+// Before is null when the user was created, after when it was hard-deleted.
+type Change struct {
+	Before json.RawMessage `json:"before" swaggertype:"object"`
+	After  json.RawMessage `json:"after" swaggertype:"object"`
+}
+
+// Changes maps the JSON name of every changed field to its Change, stored as jsonb.
+type Changes map[string]Change
+
+func (c Changes) Value() (driver.Value, error) {
+	if c == nil {
+		return "{}", nil
+	}
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+func (c *Changes) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return fmt.Errorf("scan %T into Changes", value)
+	}
+	return json.Unmarshal(raw, c)
+}
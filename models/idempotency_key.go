@@ -0,0 +1,62 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// IdempotencyKey remembers the response to a request sent with an
+// Idempotency-Key header, so a retry of the request gets it again instead of
+// repeating it. Keys belong to the caller in Scope.
+type IdempotencyKey struct {
+	Scope string `gorm:"primaryKey"`
+	Key   string `gorm:"primaryKey"`
+	// Fingerprint tells the request the key was sent with from others
+	Fingerprint string
+	// StatusCode, Headers and Response are zero until the request is done
+	StatusCode int
+	Headers    ResponseHeaders
+	Response   []byte
+	CreatedAt  time.Time
+	// LockedUntil is when the request running stops holding the key, if it
+	// isn't done by then it is taken to have died
+	LockedUntil time.Time
+	ExpiresAt   time.Time
+}
+
+// Done reports whether the response to the request is stored.
+func (k IdempotencyKey) Done() bool {
+	return k.StatusCode != 0
+}
+
+// ResponseHeaders are headers of a stored response by name, stored as jsonb.
+type ResponseHeaders map[string]string
+
+func (h ResponseHeaders) Value() (driver.Value, error) {
+	if h == nil {
+		return "{}", nil
+	}
+	raw, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+func (h *ResponseHeaders) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return fmt.Errorf("scan %T into ResponseHeaders", value)
+	}
+	return json.Unmarshal(raw, h)
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// PhoneCode is the one-time code last texted to a user, identified by its hash.
+// PhoneNumber is the number it was sent to.
+type PhoneCode struct {
+	UserID      uint `gorm:"primaryKey;autoIncrement:false"`
+	PhoneNumber string
+	CodeHash    string
+	// Attempts counts wrong codes entered since the last lockout
+	Attempts    int
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	LockedUntil *time.Time
+}
+
+// Locked reports whether the user may not enter codes at now.
+func (c *PhoneCode) Locked(now time.Time) bool {
+	return c.LockedUntil != nil && now.Before(*c.LockedUntil)
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Session is a login of a user on one device, kept alive by refreshing.
+type Session struct {
+	ID         uint       `json:"id" gorm:"primaryKey" example:"1"`
+	UserID     uint       `json:"userId" example:"1"`
+	UserAgent  string     `json:"userAgent" example:"Mozilla/5.0 (X11; Linux x86_64)"`
+	IPAddress  string     `json:"ipAddress" example:"203.0.113.7"`
+	CreatedAt  time.Time  `json:"createdAt" example:"2024-07-10T04:24:55.405915+07:00"`
+	LastUsedAt time.Time  `json:"lastUsedAt" example:"2024-07-10T04:24:55.405915+07:00"`
+	ExpiresAt  time.Time  `json:"expiresAt" example:"2024-08-09T04:24:55.405915+07:00"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Active reports whether the session can still be refreshed at now.
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// RefreshToken is one refresh token of a session, identified by its hash.
+// It is used once, when traded for the next one.
+type RefreshToken struct {
+	TokenHash string `gorm:"primaryKey"`
+	SessionID uint
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
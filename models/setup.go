@@ -1,52 +1,101 @@
 package models
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/joho/godotenv"
+	"log"
+	"strings"
+	"time"
+
+	"crud/user/config"
+	"crud/user/health"
+
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"os"
-	"strconv"
-	"strings"
 )
 
 var DB *gorm.DB
 
-func ConnectDatabase() {
-	err := godotenv.Load()
-	if err != nil {
-		fmt.Println("Error is occurred  on .env file please check")
+// DatabaseDependency is the name the database reports its readiness under.
+const DatabaseDependency = "database"
+
+// ConnectDatabase opens the connection pool described by cfg and sets DB, the schema
+// is left to the migrations package. An unreachable database is retried with
+// exponential backoff, errors that retrying can't fix, like a wrong password, are
+// returned at once. Progress is reported to readiness.
+func ConnectDatabase(ctx context.Context, cfg config.DatabaseConfig, readiness *health.Readiness, logger *log.Logger) (*gorm.DB, error) {
+	open := func() (*gorm.DB, error) {
+		// gorm pings the database while opening, so an unreachable server fails here
+		return gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{})
 	}
-	// read .env file
-	host := os.Getenv("HOST")
-	port, _ := strconv.Atoi(os.Getenv("PORT")) // don't forget to convert int since port is int type.
-	user := os.Getenv("USER_DB")
-	dbname := os.Getenv("DB_NAME")
-	pass := os.Getenv("PASSWORD")
-
-	// Initialize gorm
-	var builder strings.Builder
-	builder.WriteString("host=")
-	builder.WriteString(host)
-	builder.WriteString(" user=")
-	builder.WriteString(user)
-	builder.WriteString(" password=")
-	builder.WriteString(pass)
-	builder.WriteString(" dbname=")
-	builder.WriteString(dbname)
-	builder.WriteString(fmt.Sprintf(" port=%d", port))
-	builder.WriteString(" sslmode=disable TimeZone=Asia/Jakarta")
-
-	dsn := builder.String()
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+
+	db, err := connect(ctx, cfg, open, readiness, logger)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	err = db.AutoMigrate(&User{})
+	sqlDB, err := db.DB()
 	if err != nil {
-		return
+		return nil, err
 	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
+	readiness.Set(DatabaseDependency, true, "connected")
 	DB = db
+	return db, nil
+}
+
+// connect calls open until it succeeds, fails for good, runs out of attempts or ctx is done.
+func connect(ctx context.Context, cfg config.DatabaseConfig, open func() (*gorm.DB, error), readiness *health.Readiness, logger *log.Logger) (*gorm.DB, error) {
+	target := fmt.Sprintf("database %s on %s:%d", cfg.Name, cfg.Host, cfg.Port)
+	wait := cfg.ConnectBackoff
+
+	for attempt := 1; ; attempt++ {
+		readiness.Set(DatabaseDependency, false, fmt.Sprintf("connecting, attempt %d of %d", attempt, cfg.ConnectAttempts))
+
+		db, err := open()
+		if err == nil {
+			return db, nil
+		}
+		if !retryable(err) {
+			readiness.Set(DatabaseDependency, false, "connection refused by the database")
+			return nil, fmt.Errorf("connect to %s: %w", target, err)
+		}
+		if attempt >= cfg.ConnectAttempts {
+			readiness.Set(DatabaseDependency, false, "unreachable")
+			return nil, fmt.Errorf("connect to %s: giving up after %d attempts: %w", target, attempt, err)
+		}
+
+		logger.Printf("database: connecting to %s failed (attempt %d of %d), retrying in %s: %v", target, attempt, cfg.ConnectAttempts, wait, err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("connect to %s: %w", target, ctx.Err())
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if wait > cfg.ConnectMaxBackoff {
+			wait = cfg.ConnectMaxBackoff
+		}
+	}
+}
+
+const (
+	pgInvalidAuthorization = "28"
+	pgInvalidCatalogName   = "3D000"
+)
+
+// retryable reports whether waiting could fix a failed connection. The server answering
+// that the credentials or the database name are wrong won't change on its own.
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return !strings.HasPrefix(pgErr.Code, pgInvalidAuthorization) && pgErr.Code != pgInvalidCatalogName
+	}
+	return true
 }
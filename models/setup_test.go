@@ -0,0 +1,86 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"crud/user/config"
+	"crud/user/health"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func retryConfig() config.DatabaseConfig {
+	cfg := config.Default().Database
+	cfg.ConnectAttempts = 4
+	cfg.ConnectBackoff = time.Millisecond
+	cfg.ConnectMaxBackoff = 2 * time.Millisecond
+	return cfg
+}
+
+func TestConnectRetriesUntilReachable(t *testing.T) {
+	var logs bytes.Buffer
+	readiness := health.NewReadiness()
+
+	calls := 0
+	db, err := connect(context.Background(), retryConfig(), func() (*gorm.DB, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("dial tcp 127.0.0.1:5432: connect: connection refused")
+		}
+		return &gorm.DB{}, nil
+	}, readiness, log.New(&logs, "", 0))
+
+	assert.NoError(t, err)
+	assert.NotNil(t, db)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 2, bytes.Count(logs.Bytes(), []byte("retrying in")))
+	assert.Equal(t, "connecting, attempt 3 of 4", readiness.States()[DatabaseDependency].Message)
+}
+
+func TestConnectGivesUp(t *testing.T) {
+	readiness := health.NewReadiness()
+	unreachable := errors.New("connection refused")
+
+	calls := 0
+	_, err := connect(context.Background(), retryConfig(), func() (*gorm.DB, error) {
+		calls++
+		return nil, unreachable
+	}, readiness, log.New(&bytes.Buffer{}, "", 0))
+
+	assert.ErrorIs(t, err, unreachable)
+	assert.ErrorContains(t, err, "giving up after 4 attempts")
+	assert.Equal(t, 4, calls)
+	assert.False(t, readiness.Ready())
+}
+
+func TestConnectFailsFastOnBadCredentials(t *testing.T) {
+	calls := 0
+	_, err := connect(context.Background(), retryConfig(), func() (*gorm.DB, error) {
+		calls++
+		return nil, &pgconn.PgError{Code: "28P01", Message: "password authentication failed"}
+	}, health.NewReadiness(), log.New(&bytes.Buffer{}, "", 0))
+
+	assert.ErrorContains(t, err, "password authentication failed")
+	assert.Equal(t, 1, calls)
+}
+
+func TestConnectStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := retryConfig()
+	cfg.ConnectBackoff = time.Hour
+	cfg.ConnectMaxBackoff = time.Hour
+
+	_, err := connect(ctx, cfg, func() (*gorm.DB, error) {
+		cancel()
+		return nil, errors.New("connection refused")
+	}, health.NewReadiness(), log.New(&bytes.Buffer{}, "", 0))
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
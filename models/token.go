@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Purposes of one-time tokens
+const (
+	VerifyEmailPurpose   = "verify_email"
+	ResetPasswordPurpose = "reset_password"
+)
+
+// OneTimeToken is a single-use token mailed to a user, identified by its hash.
+// Target is what it vouches for, like the email address it was sent to.
+type OneTimeToken struct {
+	TokenHash string `gorm:"primaryKey"`
+	UserID    uint
+	Purpose   string
+	Target    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
@@ -6,15 +6,32 @@ import (
 	"gorm.io/gorm"
 )
 
+// Unique indexes on users, see migrations/sql/0002_unique_live_contacts.up.sql
+const (
+	UserEmailIndex       = "idx_users_email_live"
+	UserPhoneNumberIndex = "idx_users_phone_number_live"
+	UserExternalIDIndex  = "idx_users_external_id_live"
+)
+
 // swagger:model User
 type User struct {
-	ID          uint           `json:"id" gorm:"primaryKey" example:"1"`
-	Name        string         `json:"name" example:"testName"`
-	Email       string         `json:"email" example:"testName@gmail.com"`
-	Address     string         `json:"address" example:"purworejo, jawa tengah, indonesia"`
-	Age         int8           `json:"age" example:"24"`
-	PhoneNumber string         `json:"phoneNumber" example:"+6286566783401"`
-	CreatedAt   time.Time      `json:"createdAt" example:"2024-07-10T04:24:55.405915+07:00"`
-	UpdatedAt   time.Time      `json:"updatedAt" example:"2024-07-10T04:24:55.405915+07:00"`
-	DeletedAt   gorm.DeletedAt `json:"deletedAt"`
+	ID uint `json:"id" gorm:"primaryKey" example:"1"`
+	// ExternalID is the ID a client keeps for the user, nil unless the user was upserted by it
+	ExternalID  *string `json:"externalId" example:"crm-000123"`
+	Name        string  `json:"name" example:"testName"`
+	Email       string  `json:"email" example:"testName@gmail.com"`
+	Address     string  `json:"address" example:"purworejo, jawa tengah, indonesia"`
+	Age         int8    `json:"age" example:"24"`
+	PhoneNumber string  `json:"phoneNumber" example:"+6286566783401"`
+	// EmailVerifiedAt is when the user proved owning Email, nil until then
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt" example:"2024-07-10T04:30:12.105915+07:00"`
+	// PhoneVerifiedAt is when the user proved owning PhoneNumber, nil until then
+	PhoneVerifiedAt *time.Time `json:"phoneVerifiedAt" example:"2024-07-10T04:31:40.105915+07:00"`
+	// PasswordHash is never serialised, empty when the user can't log in
+	PasswordHash string `json:"-" swaggerignore:"true"`
+	// Version starts at 1 and is bumped by every update, it is the ETag of the user
+	Version   uint           `json:"version" example:"1"`
+	CreatedAt time.Time      `json:"createdAt" example:"2024-07-10T04:24:55.405915+07:00"`
+	UpdatedAt time.Time      `json:"updatedAt" example:"2024-07-10T04:24:55.405915+07:00"`
+	DeletedAt gorm.DeletedAt `json:"deletedAt"`
 }
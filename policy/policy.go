@@ -0,0 +1,144 @@
+// Package policy decides who may do what with users, independently of HTTP.
+package policy
+
+import "errors"
+
+// Role is granted to callers through the roles claim of their token.
+type Role string
+
+const (
+	// Admin may do anything
+	Admin Role = "admin"
+	// Operator manages users day to day: list, read, create and update them
+	Operator Role = "operator"
+	// Self is held by every caller that is a user, over their own record only.
+	// It doesn't need to be granted.
+	Self Role = "self"
+)
+
+// Action is an operation on users.
+type Action string
+
+const (
+	ListUsers        Action = "users:list"
+	ReadUser         Action = "users:read"
+	ReadUserHistory  Action = "users:read_history"
+	CreateUser       Action = "users:create"
+	UpdateUser       Action = "users:update"
+	ChangePassword   Action = "users:change_password"
+	VerifyEmail      Action = "users:verify_email"
+	VerifyPhone      Action = "users:verify_phone"
+	DeleteUser       Action = "users:delete"
+	ListDeletedUsers Action = "users:list_deleted"
+	RestoreUser      Action = "users:restore"
+	HardDeleteUser   Action = "users:hard_delete"
+	PurgeUsers       Action = "users:purge"
+
+	ListSessions   Action = "sessions:list"
+	RevokeSessions Action = "sessions:revoke"
+
+	ManageAPIKeys Action = "api_keys:manage"
+)
+
+// Scope is a permission held by an API key. Keys hold no roles, their
+// scopes alone decide what they may do.
+type Scope string
+
+const (
+	// ReadUsers lists and reads users, deleted ones included
+	ReadUsers Scope = "users:read"
+	// WriteUsers creates and updates users. Deleting and restoring them is
+	// left to administrators, no scope grants it.
+	WriteUsers Scope = "users:write"
+)
+
+// Scopes lists every scope an API key can be granted.
+var Scopes = []Scope{ReadUsers, WriteUsers}
+
+// scopes maps the actions API keys may perform to the scope they need.
+// Actions missing here are never allowed to API keys.
+var scopes = map[Action]Scope{
+	ListUsers:        ReadUsers,
+	ReadUser:         ReadUsers,
+	ReadUserHistory:  ReadUsers,
+	ListDeletedUsers: ReadUsers,
+	CreateUser:       WriteUsers,
+	UpdateUser:       WriteUsers,
+}
+
+// ErrForbidden is returned by Authorize when the principal may not perform the action.
+var ErrForbidden = errors.New("forbidden")
+
+// rules lists the roles besides Admin allowed to perform each action.
+// Actions missing here are for administrators only.
+var rules = map[Action][]Role{
+	ListUsers: {Operator},
+	ReadUser:  {Operator, Self},
+	// Who changed what is for the staff, not the users
+	ReadUserHistory: {Operator},
+	CreateUser:      {Operator},
+	UpdateUser:      {Operator, Self},
+	// Knowing the current password is required too, so only the user can
+	ChangePassword: {Self},
+	VerifyEmail:    {Operator, Self},
+	// Codes are texted to the user, who has to enter them
+	VerifyPhone:    {Self},
+	ListSessions:   {Self},
+	RevokeSessions: {Self},
+}
+
+// Principal is the caller of a request. UserID is 0 when the caller isn't a
+// user, like another service, in which case it never holds Self. APIKeyID is
+// set when the caller used an API key, which limits it to Scopes.
+type Principal struct {
+	UserID   uint
+	Roles    []Role
+	APIKeyID uint
+	Scopes   []Scope
+}
+
+// Has reports whether the principal was granted role.
+func (p Principal) Has(role Role) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the principal was granted scope.
+func (p Principal) HasScope(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize returns ErrForbidden unless principal may perform action on the
+// user with the target ID. Target is 0 for actions that don't name a user.
+func Authorize(principal Principal, action Action, target uint) error {
+	if principal.APIKeyID != 0 {
+		if scope, ok := scopes[action]; ok && principal.HasScope(scope) {
+			return nil
+		}
+		return ErrForbidden
+	}
+	if principal.Has(Admin) {
+		return nil
+	}
+	for _, role := range rules[action] {
+		if role == Self {
+			if principal.UserID != 0 && principal.UserID == target {
+				return nil
+			}
+			continue
+		}
+		if principal.Has(role) {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
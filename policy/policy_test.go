@@ -0,0 +1,87 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	admin := Principal{UserID: 1, Roles: []Role{Admin}}
+	operator := Principal{UserID: 2, Roles: []Role{Operator}}
+	user := Principal{UserID: 3}
+	service := Principal{}
+	reader := Principal{APIKeyID: 1, Scopes: []Scope{ReadUsers}}
+	writer := Principal{APIKeyID: 2, Scopes: []Scope{ReadUsers, WriteUsers}}
+
+	tests := []struct {
+		principal Principal
+		action    Action
+		target    uint
+		allowed   bool
+	}{
+		{admin, PurgeUsers, 0, true},
+		{admin, HardDeleteUser, 3, true},
+		{admin, Action("users:unknown"), 0, true},
+		{admin, ManageAPIKeys, 0, true},
+
+		{operator, ListUsers, 0, true},
+		{operator, ReadUser, 3, true},
+		{operator, CreateUser, 0, true},
+		{operator, UpdateUser, 3, true},
+		{operator, DeleteUser, 3, false},
+		{operator, ListDeletedUsers, 0, false},
+		{operator, RestoreUser, 3, false},
+		{operator, HardDeleteUser, 3, false},
+		{operator, PurgeUsers, 0, false},
+		{operator, ChangePassword, 3, false},
+		{operator, ManageAPIKeys, 0, false},
+		{operator, ReadUserHistory, 3, true},
+
+		{user, ReadUser, 3, true},
+		{user, UpdateUser, 3, true},
+		{user, ReadUser, 4, false},
+		{user, UpdateUser, 4, false},
+		{user, ChangePassword, 3, true},
+		{user, ChangePassword, 4, false},
+		{user, ListSessions, 3, true},
+		{user, VerifyEmail, 3, true},
+		{user, VerifyEmail, 4, false},
+		{user, VerifyPhone, 3, true},
+		{operator, VerifyPhone, 3, false},
+		{user, RevokeSessions, 4, false},
+		{operator, RevokeSessions, 3, false},
+		{user, ListUsers, 0, false},
+		{user, CreateUser, 0, false},
+		{user, DeleteUser, 3, false},
+		{user, ReadUserHistory, 3, false},
+
+		// A caller that isn't a user owns no record
+		{service, ReadUser, 0, false},
+		// API keys are limited to their scopes, whatever else they claim
+		{reader, ListUsers, 0, true},
+		{reader, ReadUser, 3, true},
+		{reader, UpdateUser, 3, false},
+		{reader, ReadUserHistory, 3, true},
+		{writer, CreateUser, 0, true},
+		{writer, UpdateUser, 3, true},
+		{writer, DeleteUser, 3, false},
+		{writer, RestoreUser, 3, false},
+		{writer, HardDeleteUser, 3, false},
+		{writer, ChangePassword, 3, false},
+		{writer, ManageAPIKeys, 0, false},
+		{Principal{APIKeyID: 3, Roles: []Role{Admin}}, PurgeUsers, 0, false},
+
+		// Unknown actions are refused
+		{operator, Action("users:unknown"), 0, false},
+	}
+
+	for _, tt := range tests {
+		err := Authorize(tt.principal, tt.action, tt.target)
+		if tt.allowed {
+			assert.NoError(t, err, "%+v %s %d", tt.principal, tt.action, tt.target)
+		} else {
+			assert.ErrorIs(t, err, ErrForbidden, "%+v %s %d", tt.principal, tt.action, tt.target)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package purge
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"crud/user/config"
+	"crud/user/repository"
+)
+
+// Config tunes the Worker. A zero Interval disables the schedule,
+// RunOnce can still be called by hand.
+type Config struct {
+	Retention time.Duration
+	Interval  time.Duration
+	BatchSize int
+}
+
+// Worker hard-deletes users that stayed soft-deleted longer than the retention window.
+type Worker struct {
+	users  repository.UserRepository
+	config Config
+	logger *log.Logger
+	now    func() time.Time
+
+	// run serialises scheduled and manual runs
+	run    sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewWorker(users repository.UserRepository, cfg Config, logger *log.Logger) *Worker {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = config.Default().Purge.BatchSize
+	}
+	return &Worker{users: users, config: cfg, logger: logger, now: time.Now}
+}
+
+// RunOnce purges every expired user, one batch at a time, and returns how many were removed.
+func (w *Worker) RunOnce(ctx context.Context) (int64, error) {
+	w.run.Lock()
+	defer w.run.Unlock()
+
+	cutoff := w.now().Add(-w.config.Retention)
+	var total int64
+	for {
+		n, err := w.users.PurgeDeleted(ctx, cutoff, w.config.BatchSize)
+		total += n
+		if err != nil {
+			w.logger.Printf("purge: removed %d users deleted before %s, then failed: %v", total, cutoff.Format(time.RFC3339), err)
+			return total, err
+		}
+		if n < int64(w.config.BatchSize) {
+			break
+		}
+	}
+
+	w.logger.Printf("purge: removed %d users deleted before %s", total, cutoff.Format(time.RFC3339))
+	return total, nil
+}
+
+// Start runs RunOnce every Interval in the background until Stop is called.
+func (w *Worker) Start(ctx context.Context) {
+	if w.config.Interval <= 0 {
+		return
+	}
+
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Failures are logged by RunOnce, the next tick retries
+				_, _ = w.RunOnce(ctx)
+			}
+		}
+	}()
+}
+
+// Stop cancels a running purge and waits for the background loop to exit.
+func (w *Worker) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
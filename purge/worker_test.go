@@ -0,0 +1,72 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"crud/user/config"
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// seed creates count users and soft-deletes the first deleted of them
+func seed(t *testing.T, repo *repository.MemoryUserRepository, count, deleted int) {
+	for i := 0; i < count; i++ {
+		user := models.User{Name: fmt.Sprintf("user %d", i), Email: fmt.Sprintf("user%d@gmail.com", i), PhoneNumber: fmt.Sprintf("+622345678%02d", i)}
+		assert.NoError(t, repo.Create(context.Background(), &user))
+		if i < deleted {
+			assert.NoError(t, repo.SoftDelete(context.Background(), user.ID))
+		}
+	}
+}
+
+func TestRunOncePurgesInBatches(t *testing.T) {
+	repo := repository.NewMemoryUserRepository()
+	seed(t, repo, 10, 7)
+
+	worker := NewWorker(repo, Config{Retention: time.Hour, BatchSize: 3}, log.New(io.Discard, "", 0))
+
+	// Nothing is old enough yet
+	purged, err := worker.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), purged)
+
+	worker.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	purged, err = worker.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), purged)
+
+	trash, err := repo.ListDeleted(context.Background(), repository.UserQuery{})
+	assert.NoError(t, err)
+	assert.Empty(t, trash.Users)
+
+	live, err := repo.List(context.Background(), repository.UserQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, live.Users, 3)
+}
+
+func TestStartAndStop(t *testing.T) {
+	repo := repository.NewMemoryUserRepository()
+	seed(t, repo, 2, 2)
+
+	worker := NewWorker(repo, Config{Retention: -time.Hour, Interval: time.Millisecond, BatchSize: 10}, log.New(io.Discard, "", 0))
+	worker.Start(context.Background())
+
+	assert.Eventually(t, func() bool {
+		trash, err := repo.ListDeleted(context.Background(), repository.UserQuery{})
+		return err == nil && len(trash.Users) == 0
+	}, time.Second, time.Millisecond)
+
+	worker.Stop()
+}
+
+func TestNewWorkerDefaultsBatchSize(t *testing.T) {
+	worker := NewWorker(repository.NewMemoryUserRepository(), Config{Retention: time.Hour}, log.New(io.Discard, "", 0))
+	assert.Equal(t, config.Default().Purge.BatchSize, worker.config.BatchSize)
+}
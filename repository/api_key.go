@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"crud/user/models"
+)
+
+// APIKeyRepository stores API keys and the hashes they are checked against.
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	// List returns every key, revoked ones included, oldest first.
+	List(ctx context.Context) ([]models.APIKey, error)
+	// FindByPrefix finds the key that isn't revoked with prefix.
+	FindByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	// Rotate replaces the prefix and hash of a key that isn't revoked, so only
+	// the new key works, and returns it.
+	Rotate(ctx context.Context, id uint, prefix, keyHash string) (*models.APIKey, error)
+	// Revoke stops a key from working, ErrNotFound if it already doesn't.
+	Revoke(ctx context.Context, id uint) error
+	// Touch records that the key was used at.
+	Touch(ctx context.Context, id uint, at time.Time) error
+}
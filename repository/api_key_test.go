@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crud/user/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func mockAPIKeyRepository(t *testing.T) (*GormAPIKeyRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, mock.ExpectationsWereMet())
+		db.Close()
+	})
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db, PreferSimpleProtocol: true}), &gorm.Config{})
+	assert.NoError(t, err)
+	return NewGormAPIKeyRepository(gormDB), mock
+}
+
+var apiKeyColumns = []string{"id", "name", "prefix", "key_hash", "scopes", "created_at", "last_used_at", "revoked_at"}
+
+func TestGormAPIKeyCreate(t *testing.T) {
+	repo, mock := mockAPIKeyRepository(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^INSERT INTO "api_keys" \("name","prefix","key_hash","scopes","created_at","last_used_at","revoked_at"\)`).
+		WithArgs("export", "3f9a1c0d7b2e", "hash", "users:read users:write", now, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	key := models.APIKey{Name: "export", Prefix: "3f9a1c0d7b2e", KeyHash: "hash", Scopes: models.ScopeList{"users:read", "users:write"}, CreatedAt: now}
+	assert.NoError(t, repo.Create(context.Background(), &key))
+	assert.Equal(t, uint(1), key.ID)
+}
+
+func TestGormAPIKeyFindByPrefix(t *testing.T) {
+	repo, mock := mockAPIKeyRepository(t)
+
+	mock.ExpectQuery(`^SELECT \* FROM "api_keys" WHERE prefix = \$1 AND revoked_at IS NULL LIMIT \$2`).
+		WithArgs("3f9a1c0d7b2e", 1).
+		WillReturnRows(sqlmock.NewRows(apiKeyColumns).
+			AddRow(1, "export", "3f9a1c0d7b2e", "hash", "users:read users:write", time.Now(), nil, nil))
+
+	key, err := repo.FindByPrefix(context.Background(), "3f9a1c0d7b2e")
+	assert.NoError(t, err)
+	assert.Equal(t, models.ScopeList{"users:read", "users:write"}, key.Scopes)
+}
+
+func TestGormAPIKeyRevokeNotFound(t *testing.T) {
+	repo, mock := mockAPIKeyRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "api_keys" SET "revoked_at"=\$1 WHERE id = \$2 AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	assert.ErrorIs(t, repo.Revoke(context.Background(), 9), ErrNotFound)
+}
+
+func TestMemoryAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryAPIKeyRepository()
+
+	key := models.APIKey{Name: "export", Prefix: "old", KeyHash: "old-hash"}
+	assert.NoError(t, repo.Create(ctx, &key))
+	var conflict *ConflictError
+	assert.ErrorAs(t, repo.Create(ctx, &models.APIKey{Prefix: "old"}), &conflict)
+
+	rotated, err := repo.Rotate(ctx, key.ID, "new", "new-hash")
+	assert.NoError(t, err)
+	assert.Equal(t, "new-hash", rotated.KeyHash)
+	_, err = repo.FindByPrefix(ctx, "old")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	now := time.Now()
+	assert.NoError(t, repo.Touch(ctx, key.ID, now))
+	found, err := repo.FindByPrefix(ctx, "new")
+	assert.NoError(t, err)
+	assert.Equal(t, now, *found.LastUsedAt)
+
+	assert.NoError(t, repo.Revoke(ctx, key.ID))
+	assert.ErrorIs(t, repo.Revoke(ctx, key.ID), ErrNotFound)
+	_, err = repo.FindByPrefix(ctx, "new")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.Rotate(ctx, key.ID, "newer", "newer-hash")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	keys, _ := repo.List(ctx)
+	assert.Len(t, keys, 1)
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"crud/user/models"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrConflict matches every ConflictError.
+	ErrConflict = errors.New("conflict")
+	// ErrTimeout is wrapped around errors of statements that ran out of time.
+	ErrTimeout = errors.New("database timeout")
+	// ErrUnavailable is wrapped around errors of statements that couldn't reach the database.
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// ConflictError is returned when a write clashes with existing rows, most often
+// by duplicating a unique field of another live user. Field is the JSON name
+// of that field, empty when the constraint doesn't guard a single field.
+type ConflictError struct {
+	Field      string
+	Constraint string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Field == "" {
+		return "conflicts with existing data"
+	}
+	return fmt.Sprintf("%s is already in use", e.Field)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// uniqueFields maps the unique indexes on users to the field they guard.
+var uniqueFields = map[string]string{
+	models.UserEmailIndex:       "email",
+	models.UserPhoneNumberIndex: "phoneNumber",
+	models.UserExternalIDIndex:  "externalId",
+}
+
+const (
+	pgUniqueViolation    = "23505"
+	pgExclusionViolation = "23P01"
+	pgQueryCanceled      = "57014"
+	pgLockNotAvailable   = "55P03"
+	pgAdminShutdown      = "57P01"
+	pgCrashShutdown      = "57P02"
+	pgCannotConnectNow   = "57P03"
+	pgTooManyConnections = "53300"
+	pgConnectionFailures = "08"
+)
+
+// translateError turns database errors into the repository errors callers act on:
+// ErrNotFound, ConflictError, ErrTimeout and ErrUnavailable. Anything else is returned as is,
+// other integrity violations too: a missing value or a failed check is a bug, not a conflict.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == pgUniqueViolation:
+			return &ConflictError{Field: uniqueFields[pgErr.ConstraintName], Constraint: pgErr.ConstraintName}
+		case pgErr.Code == pgExclusionViolation:
+			return &ConflictError{Constraint: pgErr.ConstraintName}
+		case pgErr.Code == pgQueryCanceled, pgErr.Code == pgLockNotAvailable:
+			return fmt.Errorf("%w: %w", ErrTimeout, err)
+		case strings.HasPrefix(pgErr.Code, pgConnectionFailures),
+			pgErr.Code == pgAdminShutdown, pgErr.Code == pgCrashShutdown,
+			pgErr.Code == pgCannotConnectNow, pgErr.Code == pgTooManyConnections:
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+		return err
+	}
+
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	case errors.As(err, &connectErr), errors.As(err, &netErr),
+		errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"crud/user/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormAPIKeyRepository is the APIKeyRepository backed by Postgres.
+type GormAPIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewGormAPIKeyRepository(db *gorm.DB) *GormAPIKeyRepository {
+	return &GormAPIKeyRepository{db: db}
+}
+
+func (r *GormAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	return translateError(r.db.WithContext(ctx).Create(key).Error)
+}
+
+func (r *GormAPIKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	if err := r.db.WithContext(ctx).Order("id").Find(&keys).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return keys, nil
+}
+
+func (r *GormAPIKeyRepository) FindByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.WithContext(ctx).Where("prefix = ? AND revoked_at IS NULL", prefix).Take(&key).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &key, nil
+}
+
+func (r *GormAPIKeyRepository) Rotate(ctx context.Context, id uint, prefix, keyHash string) (*models.APIKey, error) {
+	var key models.APIKey
+	result := r.db.WithContext(ctx).Model(&key).Clauses(clause.Returning{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{"prefix": prefix, "key_hash": keyHash})
+	if result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &key, nil
+}
+
+func (r *GormAPIKeyRepository) Revoke(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Model(&models.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *GormAPIKeyRepository) Touch(ctx context.Context, id uint, at time.Time) error {
+	return translateError(r.db.WithContext(ctx).Model(&models.APIKey{}).
+		Where("id = ?", id).
+		UpdateColumn("last_used_at", at).Error)
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+
+	"crud/user/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormIdempotencyKeyRepository is the IdempotencyKeyRepository backed by Postgres.
+type GormIdempotencyKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewGormIdempotencyKeyRepository(db *gorm.DB) *GormIdempotencyKeyRepository {
+	return &GormIdempotencyKeyRepository{db: db}
+}
+
+func (r *GormIdempotencyKeyRepository) Reserve(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	var taken *models.IdempotencyKey
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at <= ?", key.CreatedAt).Delete(&models.IdempotencyKey{}).Error; err != nil {
+			return err
+		}
+		// A concurrent request with the key waits here until the first one
+		// commits, and then finds the key taken
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+		if result.Error != nil || result.RowsAffected == 1 {
+			return result.Error
+		}
+
+		result = tx.Model(&models.IdempotencyKey{}).
+			Where("scope = ? AND key = ? AND fingerprint = ? AND status_code = 0 AND locked_until <= ?",
+				key.Scope, key.Key, key.Fingerprint, key.CreatedAt).
+			Updates(map[string]interface{}{"locked_until": key.LockedUntil, "expires_at": key.ExpiresAt})
+		if result.Error != nil || result.RowsAffected == 1 {
+			return result.Error
+		}
+
+		taken = &models.IdempotencyKey{}
+		return tx.Where("scope = ? AND key = ?", key.Scope, key.Key).Take(taken).Error
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return taken, nil
+}
+
+func (r *GormIdempotencyKeyRepository) Complete(ctx context.Context, key *models.IdempotencyKey) error {
+	result := r.held(ctx, key).Updates(map[string]interface{}{"status_code": key.StatusCode, "headers": key.Headers, "response": key.Response})
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *GormIdempotencyKeyRepository) Release(ctx context.Context, key *models.IdempotencyKey) error {
+	return translateError(r.held(ctx, key).Delete(&models.IdempotencyKey{}).Error)
+}
+
+// held scopes to key while the request that reserved it still holds it.
+func (r *GormIdempotencyKeyRepository) held(ctx context.Context, key *models.IdempotencyKey) *gorm.DB {
+	return r.db.WithContext(ctx).Model(&models.IdempotencyKey{}).
+		Where("scope = ? AND key = ? AND status_code = 0 AND locked_until = ?", key.Scope, key.Key, key.LockedUntil)
+}
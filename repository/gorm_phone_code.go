@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+
+	"crud/user/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormPhoneCodeRepository is the PhoneCodeRepository backed by Postgres.
+type GormPhoneCodeRepository struct {
+	db *gorm.DB
+}
+
+func NewGormPhoneCodeRepository(db *gorm.DB) *GormPhoneCodeRepository {
+	return &GormPhoneCodeRepository{db: db}
+}
+
+func (r *GormPhoneCodeRepository) Find(ctx context.Context, userID uint) (*models.PhoneCode, error) {
+	var code models.PhoneCode
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Take(&code).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &code, nil
+}
+
+func (r *GormPhoneCodeRepository) Save(ctx context.Context, code *models.PhoneCode) error {
+	return translateError(r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		UpdateAll: true,
+	}).Create(code).Error)
+}
+
+func (r *GormPhoneCodeRepository) Attempt(ctx context.Context, userID uint) (*models.PhoneCode, error) {
+	// Counted in the database, so parallel guesses can't share an attempt
+	var code models.PhoneCode
+	result := r.db.WithContext(ctx).Model(&code).Clauses(clause.Returning{}).
+		Where("user_id = ? AND code_hash <> ''", userID).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &code, nil
+}
+
+func (r *GormPhoneCodeRepository) Delete(ctx context.Context, userID uint) error {
+	return translateError(r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.PhoneCode{}).Error)
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"crud/user/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormSessionRepository is the SessionRepository backed by Postgres.
+type GormSessionRepository struct {
+	db *gorm.DB
+}
+
+func NewGormSessionRepository(db *gorm.DB) *GormSessionRepository {
+	return &GormSessionRepository{db: db}
+}
+
+func (r *GormSessionRepository) Create(ctx context.Context, session *models.Session, tokenHash string) error {
+	return translateError(r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.RefreshToken{TokenHash: tokenHash, SessionID: session.ID, CreatedAt: session.CreatedAt}).Error
+	}))
+}
+
+func (r *GormSessionRepository) FindByToken(ctx context.Context, tokenHash string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.WithContext(ctx).
+		Where("id = (SELECT session_id FROM refresh_tokens WHERE token_hash = ?)", tokenHash).
+		First(&session).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &session, nil
+}
+
+func (r *GormSessionRepository) Rotate(ctx context.Context, tokenHash, nextHash string, expiresAt time.Time) error {
+	now := time.Now()
+	reused := false
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Locking the token makes concurrent refreshes with it wait, and then see it used
+		var token models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+			return err
+		}
+		var session models.Session
+		if err := tx.Where("id = ?", token.SessionID).First(&session).Error; err != nil {
+			return err
+		}
+		if !session.Active(now) {
+			return ErrNotFound
+		}
+
+		if token.UsedAt != nil {
+			reused = true
+			return tx.Model(&session).Update("revoked_at", now).Error
+		}
+
+		if err := tx.Model(&token).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.RefreshToken{TokenHash: nextHash, SessionID: session.ID, CreatedAt: now}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&session).Updates(map[string]interface{}{"last_used_at": now, "expires_at": expiresAt}).Error
+	})
+	if err != nil {
+		return translateError(err)
+	}
+	if reused {
+		return ErrTokenReused
+	}
+	return nil
+}
+
+func (r *GormSessionRepository) List(ctx context.Context, userID uint) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC, id DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return sessions, nil
+}
+
+func (r *GormSessionRepository) Revoke(ctx context.Context, userID, sessionID uint) error {
+	result := r.active(ctx, userID).Where("id = ?", sessionID).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *GormSessionRepository) RevokeAll(ctx context.Context, userID uint) (int64, error) {
+	result := r.active(ctx, userID).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return 0, translateError(result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+func (r *GormSessionRepository) RevokeOthers(ctx context.Context, userID, keepID uint) (int64, error) {
+	result := r.active(ctx, userID).Where("id <> ?", keepID).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return 0, translateError(result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+func (r *GormSessionRepository) active(ctx context.Context, userID uint) *gorm.DB {
+	return r.db.WithContext(ctx).Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now())
+}
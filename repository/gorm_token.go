@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"crud/user/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormTokenRepository is the TokenRepository backed by Postgres.
+type GormTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewGormTokenRepository(db *gorm.DB) *GormTokenRepository {
+	return &GormTokenRepository{db: db}
+}
+
+func (r *GormTokenRepository) Issue(ctx context.Context, token *models.OneTimeToken) error {
+	return translateError(r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.OneTimeToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", token.CreatedAt).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	}))
+}
+
+func (r *GormTokenRepository) Consume(ctx context.Context, purpose, tokenHash string) (*models.OneTimeToken, error) {
+	// A single conditional update, so two requests racing with one token can't both win
+	var token models.OneTimeToken
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&token).Clauses(clause.Returning{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &token, nil
+}
+
+func (r *GormTokenRepository) Restore(ctx context.Context, token *models.OneTimeToken) error {
+	return translateError(r.db.WithContext(ctx).Model(&models.OneTimeToken{}).
+		Where("token_hash = ? AND used_at = ?", token.TokenHash, token.UsedAt).
+		Where("NOT EXISTS (SELECT 1 FROM one_time_tokens AS newer WHERE newer.user_id = ? AND newer.purpose = ? AND newer.created_at > ?)",
+			token.UserID, token.Purpose, token.CreatedAt).
+		Update("used_at", nil).Error)
+}
@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"crud/user/audit"
+	"crud/user/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormUserRepository is the UserRepository backed by Postgres.
+type GormUserRepository struct {
+	db *gorm.DB
+}
+
+func NewGormUserRepository(db *gorm.DB) *GormUserRepository {
+	return &GormUserRepository{db: db}
+}
+
+func (r *GormUserRepository) Find(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&user).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).Where("lower(email) = lower(?)", email).First(&user).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepository) FindByExternalID(ctx context.Context, externalID string) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).Where("external_id = ?", externalID).First(&user).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepository) List(ctx context.Context, query UserQuery) (*UserPage, error) {
+	plan, err := newListPlan(query, false)
+	if err != nil {
+		return nil, err
+	}
+	return r.list(r.db.WithContext(ctx), plan)
+}
+
+func (r *GormUserRepository) ListDeleted(ctx context.Context, query UserQuery) (*UserPage, error) {
+	plan, err := newListPlan(query, true)
+	if err != nil {
+		return nil, err
+	}
+	return r.list(r.db.WithContext(ctx).Unscoped().Where("deleted_at IS NOT NULL"), plan)
+}
+
+func (r *GormUserRepository) list(db *gorm.DB, plan *listPlan) (*UserPage, error) {
+	users := make([]models.User, 0, plan.Limit+1)
+	if err := db.Scopes(plan.scope).Find(&users).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return plan.page(users), nil
+}
+
+func (r *GormUserRepository) Create(ctx context.Context, user *models.User) error {
+	if user.Version == 0 {
+		user.Version = 1
+	}
+	return r.transaction(ctx, func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return record(ctx, tx, models.AuditCreate, nil, user)
+	})
+}
+
+func (r *GormUserRepository) Update(ctx context.Context, user *models.User) error {
+	return r.transaction(ctx, func(tx *gorm.DB) error {
+		var before models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", user.ID).Take(&before).Error; err != nil {
+			return err
+		}
+		if before.Version != user.Version {
+			return ErrVersionMismatch
+		}
+		user.Version++
+		result := tx.Model(user).Select("*").Omit("id", "created_at", "deleted_at").Updates(user)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		after := *user
+		after.CreatedAt, after.DeletedAt = before.CreatedAt, before.DeletedAt
+		return record(ctx, tx, models.AuditUpdate, &before, &after)
+	})
+}
+
+func (r *GormUserRepository) SoftDelete(ctx context.Context, id uint) error {
+	return r.transaction(ctx, func(tx *gorm.DB) error {
+		var before models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).Take(&before).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		result := tx.Model(&models.User{}).Where("id = ?", id).UpdateColumn("deleted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		after := before
+		after.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+		return record(ctx, tx, models.AuditDelete, &before, &after)
+	})
+}
+
+func (r *GormUserRepository) Restore(ctx context.Context, id uint) error {
+	return r.transaction(ctx, func(tx *gorm.DB) error {
+		var before models.User
+		err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND deleted_at IS NOT NULL", id).
+			Take(&before).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Unscoped().Model(&models.User{}).
+			Where("id = ? AND deleted_at IS NOT NULL", id).
+			Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		after := before
+		after.DeletedAt = gorm.DeletedAt{}
+		return record(ctx, tx, models.AuditRestore, &before, &after)
+	})
+}
+
+func (r *GormUserRepository) HardDelete(ctx context.Context, id uint) error {
+	return r.transaction(ctx, func(tx *gorm.DB) error {
+		var before models.User
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).Take(&before).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Delete(&models.User{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return record(ctx, tx, models.AuditHardDelete, &before, nil)
+	})
+}
+
+func (r *GormUserRepository) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var purged int64
+	// Purging in bounded batches keeps each transaction, and its locks, short
+	err := r.transaction(ctx, func(tx *gorm.DB) error {
+		var users []models.User
+		err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("deleted_at < ?", before).
+			Order("deleted_at, id").
+			Limit(limit).
+			Find(&users).Error
+		if err != nil || len(users) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(users))
+		for i := range users {
+			ids[i] = users[i].ID
+			if err := record(ctx, tx, models.AuditHardDelete, &users[i], nil); err != nil {
+				return err
+			}
+		}
+		result := tx.Unscoped().Where("id IN ?", ids).Delete(&models.User{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+func (r *GormUserRepository) History(ctx context.Context, id uint, query HistoryQuery) (*HistoryPage, error) {
+	plan, err := newHistoryPlan(query)
+	if err != nil {
+		return nil, err
+	}
+
+	db := r.db.WithContext(ctx).Where("user_id = ?", id)
+	if plan.Before != 0 {
+		db = db.Where("id < ?", plan.Before)
+	}
+	entries := make([]models.AuditEntry, 0, plan.Limit+1)
+	if err := db.Order("id DESC").Limit(plan.Limit + 1).Find(&entries).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return plan.page(entries), nil
+}
+
+// transaction runs fn in a transaction, so a change and its audit entry are
+// saved together or not at all.
+func (r *GormUserRepository) transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	return translateError(r.db.WithContext(ctx).Transaction(fn))
+}
+
+// record appends the change of a user from before to after to its audit log.
+func record(ctx context.Context, tx *gorm.DB, action string, before, after *models.User) error {
+	entry, err := audit.Entry(ctx, action, before, after)
+	if err != nil {
+		return err
+	}
+	return tx.Create(entry).Error
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// scope applies the filters, keyset condition, ordering and limit of the plan.
+// One row more than the limit is read to know whether another page exists.
+func (plan *listPlan) scope(db *gorm.DB) *gorm.DB {
+	if plan.Name != "" {
+		db = db.Where("name ILIKE ?", "%"+escapeLike(plan.Name)+"%")
+	}
+	if plan.Email != "" {
+		db = db.Where("lower(email) = lower(?)", plan.Email)
+	}
+	if plan.MinAge != nil {
+		db = db.Where("age >= ?", *plan.MinAge)
+	}
+	if plan.MaxAge != nil {
+		db = db.Where("age <= ?", *plan.MaxAge)
+	}
+	if plan.CreatedFrom != nil {
+		db = db.Where("created_at >= ?", *plan.CreatedFrom)
+	}
+	if plan.CreatedTo != nil {
+		db = db.Where("created_at < ?", *plan.CreatedTo)
+	}
+
+	op, dir := ">", "ASC"
+	if plan.scanDesc() {
+		op, dir = "<", "DESC"
+	}
+	if plan.inclusive() {
+		op += "="
+	}
+
+	if plan.cursor != nil {
+		if plan.column == "id" {
+			db = db.Where("id "+op+" ?", plan.value)
+		} else {
+			db = db.Where("("+plan.column+", id) "+op+" (?, ?)", plan.value, plan.cursor.ID)
+		}
+	}
+
+	if plan.column == "id" {
+		db = db.Order("id " + dir)
+	} else {
+		db = db.Order(plan.column + " " + dir + ", id " + dir)
+	}
+
+	return db.Limit(plan.Limit + 1)
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"fmt"
+
+	"crud/user/models"
+)
+
+// historySort is recorded in history cursors, entries are always listed newest first.
+const historySort = "-id"
+
+// HistoryQuery selects one page of the audit log of a user for History.
+// Cursor is the NextCursor of a previous page.
+type HistoryQuery struct {
+	Limit  int
+	Cursor string
+}
+
+// HistoryPage is one page of audit entries returned by History, newest first.
+type HistoryPage struct {
+	Entries    []models.AuditEntry
+	Limit      int
+	NextCursor string
+	HasNext    bool
+}
+
+// historyPlan is a validated HistoryQuery. Before is the ID entries are older than, 0 on the first page.
+type historyPlan struct {
+	Limit  int
+	Before uint
+}
+
+func newHistoryPlan(query HistoryQuery) (*historyPlan, error) {
+	if query.Limit == 0 {
+		query.Limit = DefaultPageLimit
+	}
+	if query.Limit < 1 || query.Limit > MaxPageLimit {
+		return nil, &InvalidQueryError{Reason: fmt.Sprintf("limit should be between 1 and %d", MaxPageLimit)}
+	}
+
+	plan := &historyPlan{Limit: query.Limit}
+	if query.Cursor != "" {
+		cur, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cur.Sort != historySort || cur.ID == 0 {
+			return nil, &InvalidQueryError{Reason: "cursor is malformed"}
+		}
+		plan.Before = cur.ID
+	}
+	return plan, nil
+}
+
+// page trims the extra entry read past the limit and builds the cursor.
+func (plan *historyPlan) page(entries []models.AuditEntry) *HistoryPage {
+	page := &HistoryPage{Limit: plan.Limit, HasNext: len(entries) > plan.Limit}
+	if page.HasNext {
+		entries = entries[:plan.Limit]
+		page.NextCursor = encodeCursor(cursor{Sort: historySort, ID: entries[len(entries)-1].ID})
+	}
+	page.Entries = entries
+	return page
+}
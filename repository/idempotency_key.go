@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+
+	"crud/user/models"
+)
+
+// IdempotencyKeyRepository stores the responses to requests sent with an
+// Idempotency-Key, until the key expires.
+type IdempotencyKeyRepository interface {
+	// Reserve takes key for a request, which is then running until
+	// key.LockedUntil. When the key is taken already and hasn't expired,
+	// nothing is stored and the key as stored is returned instead, unless it
+	// was taken by the same request whose lock has run out: the request is
+	// taken over. Expired keys are deleted along the way.
+	Reserve(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error)
+	// Complete stores the status code, headers and response of key, reserved by Reserve.
+	// It is ErrNotFound once another request took the key over.
+	Complete(ctx context.Context, key *models.IdempotencyKey) error
+	// Release frees a key reserved by Reserve, so the request can be retried.
+	Release(ctx context.Context, key *models.IdempotencyKey) error
+}
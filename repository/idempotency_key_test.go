@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crud/user/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var idempotencyKeyColumns = []string{"scope", "key", "fingerprint", "status_code", "headers", "response", "created_at", "locked_until", "expires_at"}
+
+type GormIdempotencyKeyTestSuite struct {
+	suite.Suite
+	DB   *gorm.DB
+	mock sqlmock.Sqlmock
+	repo *GormIdempotencyKeyRepository
+}
+
+func (suite *GormIdempotencyKeyTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New()
+	assert.NoError(suite.T(), err)
+
+	suite.DB, err = gorm.Open(postgres.New(postgres.Config{
+		DSN:                  "sqlmock_db_0",
+		DriverName:           "postgres",
+		Conn:                 db,
+		PreferSimpleProtocol: true,
+	}), &gorm.Config{})
+	assert.NoError(suite.T(), err)
+
+	suite.mock = mock
+	suite.repo = NewGormIdempotencyKeyRepository(suite.DB)
+}
+
+func (suite *GormIdempotencyKeyTestSuite) TearDownTest() {
+	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
+	sqlDB, err := suite.DB.DB()
+	assert.NoError(suite.T(), err)
+	sqlDB.Close()
+}
+
+func TestGormIdempotencyKeyTestSuite(t *testing.T) {
+	suite.Run(t, new(GormIdempotencyKeyTestSuite))
+}
+
+func newIdempotencyKey() *models.IdempotencyKey {
+	now := time.Now()
+	return &models.IdempotencyKey{
+		Scope:       "user:1",
+		Key:         "retry-me",
+		Fingerprint: "abc",
+		CreatedAt:   now,
+		LockedUntil: now.Add(time.Minute),
+		ExpiresAt:   now.Add(time.Hour),
+	}
+}
+
+func (suite *GormIdempotencyKeyTestSuite) expectReserve(inserted int64) {
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectExec(`^DELETE FROM "idempotency_keys" WHERE expires_at <= \$1`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	suite.mock.ExpectExec(`^INSERT INTO "idempotency_keys" \("scope","key","fingerprint","status_code","headers","response","created_at","locked_until","expires_at"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6,\$7,\$8,\$9\) ON CONFLICT DO NOTHING`).
+		WithArgs("user:1", "retry-me", "abc", 0, "{}", []byte(nil), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, inserted))
+}
+
+func (suite *GormIdempotencyKeyTestSuite) expectTakeOver(key *models.IdempotencyKey, updated int64) {
+	suite.mock.ExpectExec(`^UPDATE "idempotency_keys" SET "expires_at"=\$1,"locked_until"=\$2 WHERE scope = \$3 AND key = \$4 AND fingerprint = \$5 AND status_code = 0 AND locked_until <= \$6`).
+		WithArgs(key.ExpiresAt, key.LockedUntil, "user:1", "retry-me", "abc", key.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(0, updated))
+}
+
+func (suite *GormIdempotencyKeyTestSuite) TestReserve() {
+	suite.expectReserve(1)
+	suite.mock.ExpectCommit()
+
+	taken, err := suite.repo.Reserve(context.Background(), newIdempotencyKey())
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), taken)
+}
+
+func (suite *GormIdempotencyKeyTestSuite) TestReserveTaken() {
+	key := newIdempotencyKey()
+	suite.expectReserve(0)
+	suite.expectTakeOver(key, 0)
+	suite.mock.ExpectQuery(`^SELECT \* FROM "idempotency_keys" WHERE scope = \$1 AND key = \$2 LIMIT \$3`).
+		WithArgs("user:1", "retry-me", 1).
+		WillReturnRows(sqlmock.NewRows(idempotencyKeyColumns).
+			AddRow("user:1", "retry-me", "abc", 200, []byte(`{"ETag":"\"1\""}`), []byte(`{"data":{}}`), time.Now(), time.Now(), time.Now().Add(time.Hour)))
+	suite.mock.ExpectCommit()
+
+	taken, err := suite.repo.Reserve(context.Background(), key)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), taken.Done())
+	assert.Equal(suite.T(), `{"data":{}}`, string(taken.Response))
+	assert.Equal(suite.T(), models.ResponseHeaders{"ETag": `"1"`}, taken.Headers)
+}
+
+func (suite *GormIdempotencyKeyTestSuite) TestReserveTakesOverLapsedLock() {
+	key := newIdempotencyKey()
+	suite.expectReserve(0)
+	suite.expectTakeOver(key, 1)
+	suite.mock.ExpectCommit()
+
+	taken, err := suite.repo.Reserve(context.Background(), key)
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), taken)
+}
+
+func (suite *GormIdempotencyKeyTestSuite) TestComplete() {
+	key := newIdempotencyKey()
+	key.StatusCode, key.Response = 200, []byte(`{"data":{}}`)
+	key.Headers = models.ResponseHeaders{"ETag": `"1"`}
+
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectExec(`^UPDATE "idempotency_keys" SET "headers"=\$1,"response"=\$2,"status_code"=\$3 WHERE scope = \$4 AND key = \$5 AND status_code = 0 AND locked_until = \$6`).
+		WithArgs(`{"ETag":"\"1\""}`, []byte(`{"data":{}}`), 200, "user:1", "retry-me", key.LockedUntil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectCommit()
+
+	assert.NoError(suite.T(), suite.repo.Complete(context.Background(), key))
+}
+
+func (suite *GormIdempotencyKeyTestSuite) TestCompleteTakenOver() {
+	key := newIdempotencyKey()
+	key.StatusCode = 200
+
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectExec(`^UPDATE "idempotency_keys"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.mock.ExpectCommit()
+
+	assert.ErrorIs(suite.T(), suite.repo.Complete(context.Background(), key), ErrNotFound)
+}
+
+func (suite *GormIdempotencyKeyTestSuite) TestRelease() {
+	key := newIdempotencyKey()
+
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectExec(`^DELETE FROM "idempotency_keys" WHERE scope = \$1 AND key = \$2 AND status_code = 0 AND locked_until = \$3`).
+		WithArgs("user:1", "retry-me", key.LockedUntil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectCommit()
+
+	assert.NoError(suite.T(), suite.repo.Release(context.Background(), key))
+}
+
+func TestMemoryIdempotencyKeys(t *testing.T) {
+	repo := NewMemoryIdempotencyKeyRepository()
+	ctx := context.Background()
+
+	first := newIdempotencyKey()
+	taken, err := repo.Reserve(ctx, first)
+	assert.NoError(t, err)
+	assert.Nil(t, taken)
+
+	// Running requests can be released, done ones are kept
+	taken, _ = repo.Reserve(ctx, newIdempotencyKey())
+	assert.False(t, taken.Done())
+	first.StatusCode, first.Response = 200, []byte(`{}`)
+	assert.NoError(t, repo.Complete(ctx, first))
+	assert.NoError(t, repo.Release(ctx, first))
+	taken, _ = repo.Reserve(ctx, newIdempotencyKey())
+	assert.Equal(t, 200, taken.StatusCode)
+
+	// Other callers have keys of their own
+	other := newIdempotencyKey()
+	other.Scope = "user:2"
+	taken, _ = repo.Reserve(ctx, other)
+	assert.Nil(t, taken)
+
+	// Expired keys are free again
+	later := newIdempotencyKey()
+	later.CreatedAt = later.CreatedAt.Add(2 * time.Hour)
+	taken, _ = repo.Reserve(ctx, later)
+	assert.Nil(t, taken)
+
+	first.Scope = "user:3"
+	assert.ErrorIs(t, repo.Complete(ctx, first), ErrNotFound)
+}
+
+func TestMemoryIdempotencyKeyLockLapses(t *testing.T) {
+	repo := NewMemoryIdempotencyKeyRepository()
+	ctx := context.Background()
+
+	died := newIdempotencyKey()
+	_, _ = repo.Reserve(ctx, died)
+
+	// Another request can't take the key over
+	other := newIdempotencyKey()
+	other.CreatedAt = died.LockedUntil
+	other.Fingerprint = "def"
+	taken, _ := repo.Reserve(ctx, other)
+	assert.NotNil(t, taken)
+
+	// A retry of the same request does once the lock lapsed
+	retry := newIdempotencyKey()
+	retry.CreatedAt = died.LockedUntil.Add(-time.Second)
+	retry.LockedUntil = retry.CreatedAt.Add(time.Minute)
+	taken, _ = repo.Reserve(ctx, retry)
+	assert.NotNil(t, taken)
+	retry.CreatedAt = died.LockedUntil
+	taken, _ = repo.Reserve(ctx, retry)
+	assert.Nil(t, taken)
+
+	// The request that held the key before can't store its response any more
+	died.StatusCode = 200
+	assert.ErrorIs(t, repo.Complete(ctx, died), ErrNotFound)
+	assert.NoError(t, repo.Release(ctx, died))
+	retry.StatusCode = 201
+	assert.NoError(t, repo.Complete(ctx, retry))
+	taken, _ = repo.Reserve(ctx, newIdempotencyKey())
+	assert.Equal(t, 201, taken.StatusCode)
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"crud/user/models"
+)
+
+// MemoryAPIKeyRepository is an in-process APIKeyRepository for tests and local runs.
+type MemoryAPIKeyRepository struct {
+	mu     sync.Mutex
+	keys   map[uint]models.APIKey
+	nextID uint
+}
+
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{keys: map[uint]models.APIKey{}, nextID: 1}
+}
+
+func (r *MemoryAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, other := range r.keys {
+		if other.Prefix == key.Prefix {
+			return &ConflictError{Constraint: "idx_api_keys_prefix"}
+		}
+	}
+	key.ID = r.nextID
+	r.nextID++
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	r.keys[key.ID] = *key
+	return nil
+}
+
+func (r *MemoryAPIKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := []models.APIKey{}
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (r *MemoryAPIKeyRepository) FindByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range r.keys {
+		if key.Prefix == prefix && key.RevokedAt == nil {
+			return &key, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryAPIKeyRepository) Rotate(ctx context.Context, id uint, prefix, keyHash string) (*models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok || key.RevokedAt != nil {
+		return nil, ErrNotFound
+	}
+	key.Prefix, key.KeyHash = prefix, keyHash
+	r.keys[id] = key
+	return &key, nil
+}
+
+func (r *MemoryAPIKeyRepository) Revoke(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok || key.RevokedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	r.keys[id] = key
+	return nil
+}
+
+func (r *MemoryAPIKeyRepository) Touch(ctx context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	key.LastUsedAt = &at
+	r.keys[id] = key
+	return nil
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"crud/user/models"
+)
+
+// MemoryIdempotencyKeyRepository is an in-process IdempotencyKeyRepository for tests and local runs.
+type MemoryIdempotencyKeyRepository struct {
+	mu   sync.Mutex
+	keys map[[2]string]models.IdempotencyKey
+}
+
+func NewMemoryIdempotencyKeyRepository() *MemoryIdempotencyKeyRepository {
+	return &MemoryIdempotencyKeyRepository{keys: map[[2]string]models.IdempotencyKey{}}
+}
+
+func (r *MemoryIdempotencyKeyRepository) Reserve(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, stored := range r.keys {
+		if !stored.ExpiresAt.After(key.CreatedAt) {
+			delete(r.keys, id)
+		}
+	}
+	taken, ok := r.keys[[2]string{key.Scope, key.Key}]
+	if ok && (taken.Done() || taken.Fingerprint != key.Fingerprint || taken.LockedUntil.After(key.CreatedAt)) {
+		return &taken, nil
+	}
+	if ok {
+		taken.LockedUntil, taken.ExpiresAt = key.LockedUntil, key.ExpiresAt
+		r.keys[[2]string{key.Scope, key.Key}] = taken
+		return nil, nil
+	}
+	r.keys[[2]string{key.Scope, key.Key}] = *key
+	return nil, nil
+}
+
+func (r *MemoryIdempotencyKeyRepository) Complete(ctx context.Context, key *models.IdempotencyKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.held(key)
+	if !ok {
+		return ErrNotFound
+	}
+	stored.StatusCode, stored.Headers, stored.Response = key.StatusCode, key.Headers, key.Response
+	r.keys[[2]string{key.Scope, key.Key}] = stored
+	return nil
+}
+
+func (r *MemoryIdempotencyKeyRepository) Release(ctx context.Context, key *models.IdempotencyKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.held(key); ok {
+		delete(r.keys, [2]string{key.Scope, key.Key})
+	}
+	return nil
+}
+
+// held finds key while the request that reserved it still holds it.
+func (r *MemoryIdempotencyKeyRepository) held(key *models.IdempotencyKey) (models.IdempotencyKey, bool) {
+	stored, ok := r.keys[[2]string{key.Scope, key.Key}]
+	if !ok || stored.Done() || !stored.LockedUntil.Equal(key.LockedUntil) {
+		return models.IdempotencyKey{}, false
+	}
+	return stored, true
+}
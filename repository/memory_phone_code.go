@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"crud/user/models"
+)
+
+// MemoryPhoneCodeRepository is an in-process PhoneCodeRepository for tests and local runs.
+type MemoryPhoneCodeRepository struct {
+	mu    sync.Mutex
+	codes map[uint]models.PhoneCode
+}
+
+func NewMemoryPhoneCodeRepository() *MemoryPhoneCodeRepository {
+	return &MemoryPhoneCodeRepository{codes: map[uint]models.PhoneCode{}}
+}
+
+func (r *MemoryPhoneCodeRepository) Find(ctx context.Context, userID uint) (*models.PhoneCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &code, nil
+}
+
+func (r *MemoryPhoneCodeRepository) Save(ctx context.Context, code *models.PhoneCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.codes[code.UserID] = *code
+	return nil
+}
+
+func (r *MemoryPhoneCodeRepository) Attempt(ctx context.Context, userID uint) (*models.PhoneCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[userID]
+	if !ok || code.CodeHash == "" {
+		return nil, ErrNotFound
+	}
+	code.Attempts++
+	r.codes[userID] = code
+	return &code, nil
+}
+
+func (r *MemoryPhoneCodeRepository) Delete(ctx context.Context, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.codes, userID)
+	return nil
+}
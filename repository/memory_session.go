@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"crud/user/models"
+)
+
+// MemorySessionRepository is an in-process SessionRepository for tests and local runs.
+type MemorySessionRepository struct {
+	mu       sync.Mutex
+	sessions map[uint]models.Session
+	tokens   map[string]models.RefreshToken
+	nextID   uint
+}
+
+func NewMemorySessionRepository() *MemorySessionRepository {
+	return &MemorySessionRepository{sessions: map[uint]models.Session{}, tokens: map[string]models.RefreshToken{}, nextID: 1}
+}
+
+func (r *MemorySessionRepository) Create(ctx context.Context, session *models.Session, tokenHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	session.ID = r.nextID
+	r.nextID++
+	r.sessions[session.ID] = *session
+	r.tokens[tokenHash] = models.RefreshToken{TokenHash: tokenHash, SessionID: session.ID, CreatedAt: session.CreatedAt}
+	return nil
+}
+
+func (r *MemorySessionRepository) FindByToken(ctx context.Context, tokenHash string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	session := r.sessions[token.SessionID]
+	return &session, nil
+}
+
+func (r *MemorySessionRepository) Rotate(ctx context.Context, tokenHash, nextHash string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	token, ok := r.tokens[tokenHash]
+	if !ok {
+		return ErrNotFound
+	}
+	session := r.sessions[token.SessionID]
+	if !session.Active(now) {
+		return ErrNotFound
+	}
+
+	if token.UsedAt != nil {
+		session.RevokedAt = &now
+		r.sessions[session.ID] = session
+		return ErrTokenReused
+	}
+
+	token.UsedAt = &now
+	r.tokens[tokenHash] = token
+	r.tokens[nextHash] = models.RefreshToken{TokenHash: nextHash, SessionID: session.ID, CreatedAt: now}
+	session.LastUsedAt, session.ExpiresAt = now, expiresAt
+	r.sessions[session.ID] = session
+	return nil
+}
+
+func (r *MemorySessionRepository) List(ctx context.Context, userID uint) ([]models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	sessions := []models.Session{}
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.Active(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsedAt.Equal(sessions[j].LastUsedAt) {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (r *MemorySessionRepository) Revoke(ctx context.Context, userID, sessionID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	session, ok := r.sessions[sessionID]
+	if !ok || session.UserID != userID || !session.Active(now) {
+		return ErrNotFound
+	}
+	session.RevokedAt = &now
+	r.sessions[sessionID] = session
+	return nil
+}
+
+func (r *MemorySessionRepository) RevokeAll(ctx context.Context, userID uint) (int64, error) {
+	return r.RevokeOthers(ctx, userID, 0)
+}
+
+func (r *MemorySessionRepository) RevokeOthers(ctx context.Context, userID, keepID uint) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var revoked int64
+	for id, session := range r.sessions {
+		if session.UserID == userID && id != keepID && session.Active(now) {
+			session.RevokedAt = &now
+			r.sessions[id] = session
+			revoked++
+		}
+	}
+	return revoked, nil
+}
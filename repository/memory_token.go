@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"crud/user/models"
+)
+
+// MemoryTokenRepository is an in-process TokenRepository for tests and local runs.
+type MemoryTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]models.OneTimeToken
+}
+
+func NewMemoryTokenRepository() *MemoryTokenRepository {
+	return &MemoryTokenRepository{tokens: map[string]models.OneTimeToken{}}
+}
+
+func (r *MemoryTokenRepository) Issue(ctx context.Context, token *models.OneTimeToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	for hash, other := range r.tokens {
+		if other.UserID == token.UserID && other.Purpose == token.Purpose && other.UsedAt == nil {
+			other.UsedAt = &token.CreatedAt
+			r.tokens[hash] = other
+		}
+	}
+	r.tokens[token.TokenHash] = *token
+	return nil
+}
+
+func (r *MemoryTokenRepository) Consume(ctx context.Context, purpose, tokenHash string) (*models.OneTimeToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !now.Before(token.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	token.UsedAt = &now
+	r.tokens[tokenHash] = token
+	return &token, nil
+}
+
+func (r *MemoryTokenRepository) Restore(ctx context.Context, token *models.OneTimeToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.tokens[token.TokenHash]
+	if !ok || stored.UsedAt == nil || token.UsedAt == nil || !stored.UsedAt.Equal(*token.UsedAt) {
+		return nil
+	}
+	for _, newer := range r.tokens {
+		if newer.UserID == stored.UserID && newer.Purpose == stored.Purpose && newer.CreatedAt.After(stored.CreatedAt) {
+			return nil
+		}
+	}
+	stored.UsedAt = nil
+	r.tokens[token.TokenHash] = stored
+	return nil
+}
@@ -0,0 +1,369 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"crud/user/audit"
+	"crud/user/models"
+
+	"gorm.io/gorm"
+)
+
+// MemoryUserRepository is an in-process UserRepository for tests and local runs.
+type MemoryUserRepository struct {
+	mu      sync.Mutex
+	users   map[uint]models.User
+	nextID  uint
+	entries []models.AuditEntry
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[uint]models.User{}, nextID: 1}
+}
+
+func (r *MemoryUserRepository) Find(ctx context.Context, id uint) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if !user.DeletedAt.Valid && strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryUserRepository) FindByExternalID(ctx context.Context, externalID string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if !user.DeletedAt.Valid && user.ExternalID != nil && *user.ExternalID == externalID {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryUserRepository) List(ctx context.Context, query UserQuery) (*UserPage, error) {
+	plan, err := newListPlan(query, false)
+	if err != nil {
+		return nil, err
+	}
+	return r.list(plan), nil
+}
+
+func (r *MemoryUserRepository) ListDeleted(ctx context.Context, query UserQuery) (*UserPage, error) {
+	plan, err := newListPlan(query, true)
+	if err != nil {
+		return nil, err
+	}
+	return r.list(plan), nil
+}
+
+func (r *MemoryUserRepository) list(plan *listPlan) *UserPage {
+	r.mu.Lock()
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		if user.DeletedAt.Valid == plan.trash && plan.matches(user) {
+			users = append(users, user)
+		}
+	}
+	r.mu.Unlock()
+
+	desc := plan.scanDesc()
+	sort.Slice(users, func(i, j int) bool {
+		cmp := plan.compare(users[i], sortKey(plan.column, users[j]), users[j].ID)
+		if desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	if plan.cursor != nil {
+		start := sort.Search(len(users), func(i int) bool {
+			cmp := plan.compare(users[i], plan.value, plan.cursor.ID)
+			if cmp == 0 {
+				return plan.inclusive()
+			}
+			if desc {
+				return cmp < 0
+			}
+			return cmp > 0
+		})
+		users = users[start:]
+	}
+	if len(users) > plan.Limit+1 {
+		users = users[:plan.Limit+1]
+	}
+
+	return plan.page(users)
+}
+
+func (r *MemoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkUnique(*user); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if user.Version == 0 {
+		user.Version = 1
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = now
+	}
+	user.ID = r.nextID
+	r.nextID++
+	r.users[user.ID] = *user
+	return r.record(ctx, models.AuditCreate, nil, user)
+}
+
+func (r *MemoryUserRepository) Update(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.users[user.ID]
+	if !ok || current.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if current.Version != user.Version {
+		return ErrVersionMismatch
+	}
+	if err := r.checkUnique(*user); err != nil {
+		return err
+	}
+	user.Version++
+	user.CreatedAt = current.CreatedAt
+	user.DeletedAt = current.DeletedAt
+	user.UpdatedAt = time.Now()
+	r.users[user.ID] = *user
+	return r.record(ctx, models.AuditUpdate, &current, user)
+}
+
+func (r *MemoryUserRepository) SoftDelete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	before := user
+	user.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	r.users[id] = user
+	return r.record(ctx, models.AuditDelete, &before, &user)
+}
+
+func (r *MemoryUserRepository) Restore(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || !user.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if err := r.checkUnique(user); err != nil {
+		return err
+	}
+	before := user
+	user.DeletedAt = gorm.DeletedAt{}
+	r.users[id] = user
+	return r.record(ctx, models.AuditRestore, &before, &user)
+}
+
+func (r *MemoryUserRepository) HardDelete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(r.users, id)
+	return r.record(ctx, models.AuditHardDelete, &user, nil)
+}
+
+func (r *MemoryUserRepository) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired []models.User
+	for _, user := range r.users {
+		if user.DeletedAt.Valid && user.DeletedAt.Time.Before(before) {
+			expired = append(expired, user)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if !expired[i].DeletedAt.Time.Equal(expired[j].DeletedAt.Time) {
+			return expired[i].DeletedAt.Time.Before(expired[j].DeletedAt.Time)
+		}
+		return expired[i].ID < expired[j].ID
+	})
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+
+	for i := range expired {
+		if err := r.record(ctx, models.AuditHardDelete, &expired[i], nil); err != nil {
+			return int64(i), err
+		}
+		delete(r.users, expired[i].ID)
+	}
+	return int64(len(expired)), nil
+}
+
+func (r *MemoryUserRepository) History(ctx context.Context, id uint, query HistoryQuery) (*HistoryPage, error) {
+	plan, err := newHistoryPlan(query)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]models.AuditEntry, 0, plan.Limit+1)
+	for i := len(r.entries) - 1; i >= 0 && len(entries) <= plan.Limit; i-- {
+		entry := r.entries[i]
+		if entry.UserID == id && (plan.Before == 0 || entry.ID < plan.Before) {
+			entries = append(entries, entry)
+		}
+	}
+	return plan.page(entries), nil
+}
+
+// record appends the change of a user from before to after to its audit log.
+// The caller holds the lock.
+func (r *MemoryUserRepository) record(ctx context.Context, action string, before, after *models.User) error {
+	entry, err := audit.Entry(ctx, action, before, after)
+	if err != nil {
+		return err
+	}
+	entry.ID = uint(len(r.entries) + 1)
+	entry.CreatedAt = time.Now()
+	r.entries = append(r.entries, *entry)
+	return nil
+}
+
+// checkUnique mirrors the partial unique indexes on users: email (case insensitive),
+// phone number and external ID may not be shared with another live user.
+func (r *MemoryUserRepository) checkUnique(user models.User) error {
+	for _, other := range r.users {
+		if other.ID == user.ID || other.DeletedAt.Valid {
+			continue
+		}
+		if strings.EqualFold(other.Email, user.Email) {
+			return &ConflictError{Field: "email"}
+		}
+		if other.PhoneNumber == user.PhoneNumber {
+			return &ConflictError{Field: "phoneNumber"}
+		}
+		if other.ExternalID != nil && user.ExternalID != nil && *other.ExternalID == *user.ExternalID {
+			return &ConflictError{Field: "externalId"}
+		}
+	}
+	return nil
+}
+
+// matches applies the filters of the plan the way the Postgres query does.
+func (plan *listPlan) matches(user models.User) bool {
+	if plan.Name != "" && !strings.Contains(strings.ToLower(user.Name), strings.ToLower(plan.Name)) {
+		return false
+	}
+	if plan.Email != "" && !strings.EqualFold(user.Email, plan.Email) {
+		return false
+	}
+	if plan.MinAge != nil && int(user.Age) < *plan.MinAge {
+		return false
+	}
+	if plan.MaxAge != nil && int(user.Age) > *plan.MaxAge {
+		return false
+	}
+	if plan.CreatedFrom != nil && user.CreatedAt.Before(*plan.CreatedFrom) {
+		return false
+	}
+	if plan.CreatedTo != nil && !user.CreatedAt.Before(*plan.CreatedTo) {
+		return false
+	}
+	return true
+}
+
+// compare orders user against the (sort key, id) position given.
+func (plan *listPlan) compare(user models.User, key interface{}, id uint) int {
+	if cmp := compareKeys(sortKey(plan.column, user), key); cmp != 0 || plan.column == "id" {
+		return cmp
+	}
+	switch {
+	case user.ID < id:
+		return -1
+	case user.ID > id:
+		return 1
+	}
+	return 0
+}
+
+// sortKey returns the sort column of user as the type cursorValue produces.
+func sortKey(column string, user models.User) interface{} {
+	switch column {
+	case "id":
+		return uint64(user.ID)
+	case "name":
+		return user.Name
+	case "email":
+		return user.Email
+	case "age":
+		return int64(user.Age)
+	case "created_at":
+		return user.CreatedAt
+	case "deleted_at":
+		return user.DeletedAt.Time
+	default:
+		return user.UpdatedAt
+	}
+}
+
+func compareKeys(a, b interface{}) int {
+	switch a := a.(type) {
+	case uint64:
+		b := b.(uint64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case int64:
+		b := b.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	return 0
+}
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+
+	"crud/user/models"
+)
+
+// PhoneCodeRepository stores the one-time code last texted to each user.
+type PhoneCodeRepository interface {
+	// Find returns the code of the user, ErrNotFound if none was sent.
+	Find(ctx context.Context, userID uint) (*models.PhoneCode, error)
+	// Save stores code, replacing the one the user had.
+	Save(ctx context.Context, code *models.PhoneCode) error
+	// Attempt counts one more guess at the code of the user and returns it,
+	// ErrNotFound if there is no code to guess.
+	Attempt(ctx context.Context, userID uint) (*models.PhoneCode, error)
+	// Delete forgets the code of the user.
+	Delete(ctx context.Context, userID uint) error
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crud/user/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func mockPhoneCodeRepository(t *testing.T) (*GormPhoneCodeRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, mock.ExpectationsWereMet())
+		db.Close()
+	})
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db, PreferSimpleProtocol: true}), &gorm.Config{})
+	assert.NoError(t, err)
+	return NewGormPhoneCodeRepository(gormDB), mock
+}
+
+var phoneCodeColumns = []string{"user_id", "phone_number", "code_hash", "attempts", "created_at", "expires_at", "locked_until"}
+
+func TestGormPhoneCodeSave(t *testing.T) {
+	repo, mock := mockPhoneCodeRepository(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^INSERT INTO "phone_codes" \("user_id","phone_number","code_hash","attempts","created_at","expires_at","locked_until"\) VALUES .* ON CONFLICT \("user_id"\) DO UPDATE SET`).
+		WithArgs(1, "+62234567890", "hash", 0, now, now.Add(time.Minute), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := repo.Save(context.Background(), &models.PhoneCode{
+		UserID: 1, PhoneNumber: "+62234567890", CodeHash: "hash", CreatedAt: now, ExpiresAt: now.Add(time.Minute),
+	})
+	assert.NoError(t, err)
+}
+
+func TestGormPhoneCodeAttempt(t *testing.T) {
+	repo, mock := mockPhoneCodeRepository(t)
+	query := `^UPDATE "phone_codes" SET "attempts"=attempts \+ 1 WHERE user_id = \$1 AND code_hash <> '' RETURNING \*`
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(phoneCodeColumns).
+			AddRow(1, "+62234567890", "hash", 3, time.Now(), time.Now().Add(time.Minute), nil))
+	mock.ExpectCommit()
+
+	code, err := repo.Attempt(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, code.Attempts)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(phoneCodeColumns))
+	mock.ExpectCommit()
+
+	_, err = repo.Attempt(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGormPhoneCodeFindNotFound(t *testing.T) {
+	repo, mock := mockPhoneCodeRepository(t)
+
+	mock.ExpectQuery(`^SELECT \* FROM "phone_codes" WHERE user_id = \$1 LIMIT \$2`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows(phoneCodeColumns))
+
+	_, err := repo.Find(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemoryPhoneCodes(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryPhoneCodeRepository()
+
+	_, err := repo.Attempt(ctx, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, repo.Save(ctx, &models.PhoneCode{UserID: 1, CodeHash: "hash"}))
+	for want := 1; want <= 2; want++ {
+		code, err := repo.Attempt(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, want, code.Attempts)
+	}
+
+	// A locked out user has no code left to guess
+	until := time.Now().Add(time.Minute)
+	assert.NoError(t, repo.Save(ctx, &models.PhoneCode{UserID: 1, LockedUntil: &until}))
+	_, err = repo.Attempt(ctx, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+	code, err := repo.Find(ctx, 1)
+	assert.NoError(t, err)
+	assert.True(t, code.Locked(time.Now()))
+
+	assert.NoError(t, repo.Delete(ctx, 1))
+	_, err = repo.Find(ctx, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,260 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"crud/user/models"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+	DefaultUserSort  = "-createdAt"
+	DefaultTrashSort = "-deletedAt"
+)
+
+// sortColumns whitelists the fields users can be sorted by,
+// keyed by their JSON name.
+var sortColumns = map[string]string{
+	"id":        "id",
+	"name":      "name",
+	"email":     "email",
+	"age":       "age",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+}
+
+// trashSortColumns adds deletion time to sortColumns for soft-deleted users.
+var trashSortColumns = map[string]string{
+	"id":        "id",
+	"name":      "name",
+	"email":     "email",
+	"age":       "age",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+	"deletedAt": "deleted_at",
+}
+
+// UserQuery selects one page of users for List and ListDeleted.
+// Sort is a JSON field name, prefixed with "-" for descending order.
+// Cursor is the NextCursor or PrevCursor of a previous page.
+type UserQuery struct {
+	Limit  int
+	Sort   string
+	Cursor string
+
+	Name        string
+	Email       string
+	MinAge      *int
+	MaxAge      *int
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+// UserPage is one page of users returned by List and ListDeleted.
+type UserPage struct {
+	Users      []models.User
+	Limit      int
+	Sort       string
+	NextCursor string
+	PrevCursor string
+	HasNext    bool
+	HasPrev    bool
+}
+
+// InvalidQueryError is returned by List and ListDeleted when the sort or cursor of a UserQuery can't be used.
+type InvalidQueryError struct {
+	Reason string
+}
+
+func (e *InvalidQueryError) Error() string {
+	return e.Reason
+}
+
+// cursor is the opaque position handed to clients as NextCursor/PrevCursor.
+// It records the sort it was issued for so it can't be replayed against another one.
+// Pages start after the row at the position, or with it when Inclusive.
+type cursor struct {
+	Sort      string `json:"s"`
+	Value     string `json:"v"`
+	ID        uint   `json:"i"`
+	Backward  bool   `json:"b,omitempty"`
+	Inclusive bool   `json:"n,omitempty"`
+}
+
+// listPlan is a validated UserQuery, shared by the repository implementations.
+// A trash plan lists soft-deleted users instead of live ones.
+type listPlan struct {
+	UserQuery
+	trash  bool
+	column string
+	desc   bool
+	cursor *cursor
+	value  interface{}
+}
+
+func encodeCursor(cur cursor) string {
+	raw, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, &InvalidQueryError{Reason: "cursor is malformed"}
+	}
+	var cur cursor
+	if err := json.Unmarshal(raw, &cur); err != nil {
+		return nil, &InvalidQueryError{Reason: "cursor is malformed"}
+	}
+	return &cur, nil
+}
+
+func newListPlan(query UserQuery, trash bool) (*listPlan, error) {
+	if query.Limit == 0 {
+		query.Limit = DefaultPageLimit
+	}
+	if query.Limit < 1 || query.Limit > MaxPageLimit {
+		return nil, &InvalidQueryError{Reason: fmt.Sprintf("limit should be between 1 and %d", MaxPageLimit)}
+	}
+
+	columns, defaultSort := sortColumns, DefaultUserSort
+	if trash {
+		columns, defaultSort = trashSortColumns, DefaultTrashSort
+	}
+	if query.Sort == "" {
+		query.Sort = defaultSort
+	}
+
+	plan := &listPlan{UserQuery: query, trash: trash}
+
+	field := strings.TrimPrefix(query.Sort, "-")
+	column, ok := columns[field]
+	if !ok {
+		return nil, &InvalidQueryError{Reason: fmt.Sprintf("sort by %q is not supported", field)}
+	}
+	plan.column = column
+	plan.desc = strings.HasPrefix(query.Sort, "-")
+
+	if query.Cursor != "" {
+		cur, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cur.Sort != query.Sort {
+			return nil, &InvalidQueryError{Reason: "cursor does not match sort"}
+		}
+		value, err := cursorValue(column, cur.Value)
+		if err != nil {
+			return nil, &InvalidQueryError{Reason: "cursor is malformed"}
+		}
+		plan.cursor = cur
+		plan.value = value
+	}
+
+	return plan, nil
+}
+
+// backward reports whether the plan walks towards the previous page.
+func (plan *listPlan) backward() bool {
+	return plan.cursor != nil && plan.cursor.Backward
+}
+
+// inclusive reports whether the row at the cursor belongs to the page.
+func (plan *listPlan) inclusive() bool {
+	return plan.cursor != nil && plan.cursor.Inclusive
+}
+
+// scanDesc is the direction rows are read in. Walking backwards flips it,
+// the page is reversed again afterwards.
+func (plan *listPlan) scanDesc() bool {
+	if plan.backward() {
+		return !plan.desc
+	}
+	return plan.desc
+}
+
+// cursorValue converts the string stored in a cursor back into the type of the sort column.
+func cursorValue(column, value string) (interface{}, error) {
+	switch column {
+	case "id":
+		return strconv.ParseUint(value, 10, 64)
+	case "age":
+		return strconv.ParseInt(value, 10, 8)
+	case "created_at", "updated_at", "deleted_at":
+		return time.Parse(time.RFC3339Nano, value)
+	default:
+		return value, nil
+	}
+}
+
+// sortValue returns the value of the sort column of user, formatted for a cursor.
+func sortValue(column string, user models.User) string {
+	switch column {
+	case "id":
+		return strconv.FormatUint(uint64(user.ID), 10)
+	case "name":
+		return user.Name
+	case "email":
+		return user.Email
+	case "age":
+		return strconv.Itoa(int(user.Age))
+	case "created_at":
+		return user.CreatedAt.Format(time.RFC3339Nano)
+	case "deleted_at":
+		return user.DeletedAt.Time.Format(time.RFC3339Nano)
+	default:
+		return user.UpdatedAt.Format(time.RFC3339Nano)
+	}
+}
+
+// page trims the extra row read past the limit and builds the cursors.
+func (plan *listPlan) page(users []models.User) *UserPage {
+	page := &UserPage{Limit: plan.Limit, Sort: plan.Sort}
+
+	more := len(users) > plan.Limit
+	if more {
+		users = users[:plan.Limit]
+	}
+
+	if plan.backward() {
+		for i, j := 0, len(users)-1; i < j; i, j = i+1, j-1 {
+			users[i], users[j] = users[j], users[i]
+		}
+		page.HasPrev = more
+		page.HasNext = true
+	} else {
+		page.HasPrev = plan.cursor != nil
+		page.HasNext = more
+	}
+
+	switch {
+	case len(users) > 0:
+		first, last := users[0], users[len(users)-1]
+		if page.HasNext {
+			page.NextCursor = encodeCursor(cursor{Sort: plan.Sort, Value: sortValue(plan.column, last), ID: last.ID})
+		}
+		if page.HasPrev {
+			page.PrevCursor = encodeCursor(cursor{Sort: plan.Sort, Value: sortValue(plan.column, first), ID: first.ID, Backward: true})
+		}
+	case plan.cursor != nil:
+		// The rows past the cursor are gone, like when they were deleted. The
+		// way back starts with the row the cursor was taken from.
+		turned := *plan.cursor
+		turned.Backward = !turned.Backward
+		turned.Inclusive = true
+		if plan.backward() {
+			page.NextCursor = encodeCursor(turned)
+		} else {
+			page.PrevCursor = encodeCursor(turned)
+		}
+	}
+
+	page.Users = users
+	return page
+}
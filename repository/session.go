@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"crud/user/models"
+)
+
+// ErrTokenReused is returned by Rotate when a refresh token that was already
+// traded is presented again. Either the client or an attacker holds a stolen
+// copy, so the whole session has been revoked.
+var ErrTokenReused = errors.New("refresh token was already used")
+
+// SessionRepository stores sessions and the hashes of their refresh tokens.
+type SessionRepository interface {
+	// Create stores a new session with its first refresh token.
+	Create(ctx context.Context, session *models.Session, tokenHash string) error
+	// FindByToken finds the session a refresh token belongs to, whether the
+	// token was used already or not, and whether the session is active or not.
+	FindByToken(ctx context.Context, tokenHash string) (*models.Session, error)
+	// Rotate trades the refresh token for nextHash, extending the session until expiresAt.
+	// A revoked or expired session, or an unknown token, is ErrNotFound.
+	Rotate(ctx context.Context, tokenHash, nextHash string, expiresAt time.Time) error
+	// List returns the active sessions of a user, most recently used first.
+	List(ctx context.Context, userID uint) ([]models.Session, error)
+	// Revoke ends one active session of a user.
+	Revoke(ctx context.Context, userID, sessionID uint) error
+	// RevokeAll ends every active session of a user and reports how many there were.
+	RevokeAll(ctx context.Context, userID uint) (int64, error)
+	// RevokeOthers ends every active session of a user but keepID, and reports how many there were.
+	RevokeOthers(ctx context.Context, userID, keepID uint) (int64, error)
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crud/user/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var (
+	sessionColumns = []string{"id", "user_id", "user_agent", "ip_address", "created_at", "last_used_at", "expires_at", "revoked_at"}
+	tokenColumns   = []string{"token_hash", "session_id", "created_at", "used_at"}
+)
+
+type GormSessionTestSuite struct {
+	suite.Suite
+	DB   *gorm.DB
+	mock sqlmock.Sqlmock
+	repo *GormSessionRepository
+}
+
+func (suite *GormSessionTestSuite) SetupTest() {
+	db, mock, err := sqlmock.New()
+	assert.NoError(suite.T(), err)
+
+	suite.DB, err = gorm.Open(postgres.New(postgres.Config{
+		DSN:                  "sqlmock_db_0",
+		DriverName:           "postgres",
+		Conn:                 db,
+		PreferSimpleProtocol: true,
+	}), &gorm.Config{})
+	assert.NoError(suite.T(), err)
+
+	suite.mock = mock
+	suite.repo = NewGormSessionRepository(suite.DB)
+}
+
+func (suite *GormSessionTestSuite) TearDownTest() {
+	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
+	sqlDB, err := suite.DB.DB()
+	assert.NoError(suite.T(), err)
+	sqlDB.Close()
+}
+
+func TestGormSessionTestSuite(t *testing.T) {
+	suite.Run(t, new(GormSessionTestSuite))
+}
+
+func (suite *GormSessionTestSuite) expectToken(usedAt interface{}) {
+	suite.mock.ExpectQuery(`^SELECT \* FROM "refresh_tokens" WHERE token_hash = \$1 ORDER BY "refresh_tokens"."token_hash" LIMIT \$2 FOR UPDATE`).
+		WithArgs("old", 1).
+		WillReturnRows(sqlmock.NewRows(tokenColumns).AddRow("old", 7, time.Now(), usedAt))
+	suite.mock.ExpectQuery(`^SELECT \* FROM "sessions" WHERE id = \$1 ORDER BY "sessions"."id" LIMIT \$2`).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows(sessionColumns).AddRow(7, 1, "curl", "127.0.0.1", time.Now(), time.Now(), time.Now().Add(time.Hour), nil))
+}
+
+func (suite *GormSessionTestSuite) TestRotate() {
+	expiresAt := time.Now().Add(24 * time.Hour)
+
+	suite.mock.ExpectBegin()
+	suite.expectToken(nil)
+	suite.mock.ExpectExec(`^UPDATE "refresh_tokens" SET "used_at"=\$1 WHERE "token_hash" = \$2`).
+		WithArgs(sqlmock.AnyArg(), "old").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectExec(`^INSERT INTO "refresh_tokens" \("token_hash","session_id","created_at","used_at"\) VALUES \(\$1,\$2,\$3,\$4\)`).
+		WithArgs("new", 7, sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectExec(`^UPDATE "sessions" SET "expires_at"=\$1,"last_used_at"=\$2 WHERE "id" = \$3`).
+		WithArgs(expiresAt, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectCommit()
+
+	assert.NoError(suite.T(), suite.repo.Rotate(context.Background(), "old", "new", expiresAt))
+}
+
+func (suite *GormSessionTestSuite) TestRotateReusedTokenRevokesSession() {
+	suite.mock.ExpectBegin()
+	suite.expectToken(time.Now().Add(-time.Minute))
+	suite.mock.ExpectExec(`^UPDATE "sessions" SET "revoked_at"=\$1 WHERE "id" = \$2`).
+		WithArgs(sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The revocation is committed even though the refresh fails
+	suite.mock.ExpectCommit()
+
+	err := suite.repo.Rotate(context.Background(), "old", "new", time.Now().Add(time.Hour))
+	assert.ErrorIs(suite.T(), err, ErrTokenReused)
+}
+
+func (suite *GormSessionTestSuite) TestRevokeNotFound() {
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectExec(`^UPDATE "sessions" SET "revoked_at"=\$1 WHERE \(user_id = \$2 AND revoked_at IS NULL AND expires_at > \$3\) AND id = \$4`).
+		WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.mock.ExpectCommit()
+
+	assert.ErrorIs(suite.T(), suite.repo.Revoke(context.Background(), 1, 9), ErrNotFound)
+}
+
+func (suite *GormSessionTestSuite) TestRevokeOthers() {
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectExec(`^UPDATE "sessions" SET "revoked_at"=\$1 WHERE \(user_id = \$2 AND revoked_at IS NULL AND expires_at > \$3\) AND id <> \$4`).
+		WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	suite.mock.ExpectCommit()
+
+	revoked, err := suite.repo.RevokeOthers(context.Background(), 1, 9)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(2), revoked)
+}
+
+func TestMemorySessionRotation(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemorySessionRepository()
+
+	session := models.Session{UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	assert.NoError(t, repo.Create(ctx, &session, "first"))
+	other := models.Session{UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	assert.NoError(t, repo.Create(ctx, &other, "other"))
+
+	assert.NoError(t, repo.Rotate(ctx, "first", "second", time.Now().Add(2*time.Hour)))
+	found, err := repo.FindByToken(ctx, "second")
+	assert.NoError(t, err)
+	assert.Equal(t, session.ID, found.ID)
+
+	// Replaying the first token revokes its session, and the token it was traded for with it
+	assert.ErrorIs(t, repo.Rotate(ctx, "first", "third", time.Now().Add(time.Hour)), ErrTokenReused)
+	assert.ErrorIs(t, repo.Rotate(ctx, "second", "third", time.Now().Add(time.Hour)), ErrNotFound)
+	assert.ErrorIs(t, repo.Rotate(ctx, "unknown", "third", time.Now().Add(time.Hour)), ErrNotFound)
+
+	// Other sessions are untouched
+	sessions, err := repo.List(ctx, 1)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, other.ID, sessions[0].ID)
+
+	assert.ErrorIs(t, repo.Revoke(ctx, 2, other.ID), ErrNotFound) // Not theirs
+	revoked, err := repo.RevokeOthers(ctx, 1, other.ID)
+	assert.NoError(t, err)
+	assert.Zero(t, revoked)
+	revoked, err = repo.RevokeAll(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), revoked)
+	sessions, _ = repo.List(ctx, 1)
+	assert.Empty(t, sessions)
+}
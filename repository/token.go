@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"context"
+
+	"crud/user/models"
+)
+
+// TokenRepository stores the hashes of one-time tokens.
+type TokenRepository interface {
+	// Issue stores token. Unused tokens the user holds for the same purpose stop
+	// working, so only the latest email sent counts.
+	Issue(ctx context.Context, token *models.OneTimeToken) error
+	// Consume marks the unused and unexpired token with hash for purpose as used
+	// and returns it. Any other token is ErrNotFound.
+	Consume(ctx context.Context, purpose, tokenHash string) (*models.OneTimeToken, error)
+	// Restore makes a token returned by Consume usable again, when what it was
+	// consumed for failed. It stays used once another token was issued to the
+	// user for the same purpose, which would have retired it anyway.
+	Restore(ctx context.Context, token *models.OneTimeToken) error
+}
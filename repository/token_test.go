@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crud/user/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func mockTokenRepository(t *testing.T) (*GormTokenRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, mock.ExpectationsWereMet())
+		db.Close()
+	})
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db, PreferSimpleProtocol: true}), &gorm.Config{})
+	assert.NoError(t, err)
+	return NewGormTokenRepository(gormDB), mock
+}
+
+func TestGormTokenIssue(t *testing.T) {
+	repo, mock := mockTokenRepository(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "one_time_tokens" SET "used_at"=\$1 WHERE user_id = \$2 AND purpose = \$3 AND used_at IS NULL`).
+		WithArgs(now, 1, models.VerifyEmailPurpose).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^INSERT INTO "one_time_tokens"`).
+		WithArgs("hash", 1, models.VerifyEmailPurpose, "test@gmail.com", now, now.Add(time.Hour), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := repo.Issue(context.Background(), &models.OneTimeToken{
+		TokenHash: "hash", UserID: 1, Purpose: models.VerifyEmailPurpose, Target: "test@gmail.com",
+		CreatedAt: now, ExpiresAt: now.Add(time.Hour),
+	})
+	assert.NoError(t, err)
+}
+
+func TestGormTokenConsume(t *testing.T) {
+	repo, mock := mockTokenRepository(t)
+	query := `^UPDATE "one_time_tokens" SET "used_at"=\$1 WHERE token_hash = \$2 AND purpose = \$3 AND used_at IS NULL AND expires_at > \$4 RETURNING \*`
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).
+		WithArgs(sqlmock.AnyArg(), "hash", models.VerifyEmailPurpose, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"token_hash", "user_id", "purpose", "target", "created_at", "expires_at", "used_at"}).
+			AddRow("hash", 1, models.VerifyEmailPurpose, "test@gmail.com", time.Now(), time.Now().Add(time.Hour), time.Now()))
+	mock.ExpectCommit()
+
+	token, err := repo.Consume(context.Background(), models.VerifyEmailPurpose, "hash")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), token.UserID)
+	assert.Equal(t, "test@gmail.com", token.Target)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).
+		WithArgs(sqlmock.AnyArg(), "used", models.VerifyEmailPurpose, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"token_hash"}))
+	mock.ExpectCommit()
+
+	_, err = repo.Consume(context.Background(), models.VerifyEmailPurpose, "used")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGormTokenRestore(t *testing.T) {
+	repo, mock := mockTokenRepository(t)
+	now := time.Now()
+	token := &models.OneTimeToken{TokenHash: "hash", UserID: 1, Purpose: models.VerifyEmailPurpose, CreatedAt: now.Add(-time.Minute), UsedAt: &now}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "one_time_tokens" SET "used_at"=\$1 WHERE \(token_hash = \$2 AND used_at = \$3\) AND \(NOT EXISTS \(SELECT 1 FROM one_time_tokens AS newer WHERE newer.user_id = \$4 AND newer.purpose = \$5 AND newer.created_at > \$6\)\)`).
+		WithArgs(nil, "hash", now, 1, models.VerifyEmailPurpose, token.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, repo.Restore(context.Background(), token))
+}
+
+func TestMemoryTokens(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryTokenRepository()
+	issue := func(hash string, expiresIn time.Duration) {
+		assert.NoError(t, repo.Issue(ctx, &models.OneTimeToken{TokenHash: hash, UserID: 1, Purpose: models.VerifyEmailPurpose, ExpiresAt: time.Now().Add(expiresIn)}))
+	}
+
+	issue("expired", -time.Minute)
+	_, err := repo.Consume(ctx, models.VerifyEmailPurpose, "expired")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Issuing again retires the previous token
+	issue("first", time.Hour)
+	issue("second", time.Hour)
+	_, err = repo.Consume(ctx, models.VerifyEmailPurpose, "first")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = repo.Consume(ctx, "reset_password", "second")
+	assert.ErrorIs(t, err, ErrNotFound)
+	token, err := repo.Consume(ctx, models.VerifyEmailPurpose, "second")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), token.UserID)
+	_, err = repo.Consume(ctx, models.VerifyEmailPurpose, "second")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Restoring gives the token back, until a newer one is issued
+	assert.NoError(t, repo.Restore(ctx, token))
+	token, err = repo.Consume(ctx, models.VerifyEmailPurpose, "second")
+	assert.NoError(t, err)
+	issue("third", time.Hour)
+	assert.NoError(t, repo.Restore(ctx, token))
+	_, err = repo.Consume(ctx, models.VerifyEmailPurpose, "second")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
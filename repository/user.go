@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"crud/user/models"
+)
+
+// ErrNotFound is returned when no user with the requested id can be acted on:
+// no live user for most methods, no soft-deleted user for Restore.
+var ErrNotFound = errors.New("record not found")
+
+// ErrVersionMismatch is returned by Update when the user was updated since it
+// was read, its Version isn't the stored one anymore.
+var ErrVersionMismatch = errors.New("the user was changed meanwhile")
+
+// UserRepository stores users. Soft-deleted users are invisible to every
+// method except ListDeleted, Restore and HardDelete.
+//
+// Create, Update, SoftDelete, Restore, HardDelete and PurgeDeleted append an
+// entry to the audit log of each user they change along with the change, made
+// by the actor and request of ctx, see the audit package.
+type UserRepository interface {
+	Find(ctx context.Context, id uint) (*models.User, error)
+	// FindByEmail finds the live user with email, ignoring case.
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	// FindByExternalID finds the live user with the external ID.
+	FindByExternalID(ctx context.Context, externalID string) (*models.User, error)
+	List(ctx context.Context, query UserQuery) (*UserPage, error)
+	ListDeleted(ctx context.Context, query UserQuery) (*UserPage, error)
+	Create(ctx context.Context, user *models.User) error
+	// Update saves every field of user, zero values included, and bumps its Version.
+	// It fails with ErrVersionMismatch unless user has the stored Version.
+	Update(ctx context.Context, user *models.User) error
+	SoftDelete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, id uint) error
+	// HardDelete removes the user for good, whether it was soft-deleted or not.
+	HardDelete(ctx context.Context, id uint) error
+	// PurgeDeleted hard-deletes at most limit users soft-deleted before the given time,
+	// oldest first, and reports how many it removed.
+	PurgeDeleted(ctx context.Context, before time.Time, limit int) (int64, error)
+	// History lists the audit log of the user with id, newest first. It outlives the user.
+	History(ctx context.Context, id uint, query HistoryQuery) (*HistoryPage, error)
+}
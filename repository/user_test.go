@@ -0,0 +1,600 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"crud/user/audit"
+	"crud/user/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var userColumns = []string{"id", "name", "email", "address", "age", "phone_number", "created_at", "updated_at", "deleted_at"}
+
+type GormUserTestSuite struct {
+	suite.Suite
+	DB   *gorm.DB
+	mock sqlmock.Sqlmock
+	repo *GormUserRepository
+}
+
+func (suite *GormUserTestSuite) SetupTest() {
+	var err error
+	db, mock, err := sqlmock.New()
+	assert.NoError(suite.T(), err)
+
+	dialector := postgres.New(postgres.Config{
+		DSN:                  "sqlmock_db_0",
+		DriverName:           "postgres",
+		Conn:                 db,
+		PreferSimpleProtocol: true,
+	})
+
+	suite.DB, err = gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(suite.T(), err)
+
+	suite.mock = mock
+	suite.repo = NewGormUserRepository(suite.DB)
+}
+
+func (suite *GormUserTestSuite) TearDownTest() {
+	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
+	sqlDB, err := suite.DB.DB()
+	assert.NoError(suite.T(), err)
+	sqlDB.Close()
+}
+
+func TestGormUserTestSuite(t *testing.T) {
+	suite.Run(t, new(GormUserTestSuite))
+}
+
+func (suite *GormUserTestSuite) TestFind() {
+	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE id = \\$1 AND \"users\".\"deleted_at\" IS NULL ORDER BY \"users\".\"id\" LIMIT \\$2").
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(1, "John Doe", "john@example.com", "Address 1", 30, "+1234567890", time.Now(), time.Now(), nil))
+
+	user, err := suite.repo.Find(context.Background(), 1)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "John Doe", user.Name)
+}
+
+func (suite *GormUserTestSuite) TestFindByEmail() {
+	suite.mock.ExpectQuery(`^SELECT \* FROM "users" WHERE lower\(email\) = lower\(\$1\) AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`).
+		WithArgs("John@Example.com", 1).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(1, "John Doe", "john@example.com", "Address 1", 30, "+1234567890", time.Now(), time.Now(), nil))
+
+	user, err := suite.repo.FindByEmail(context.Background(), "John@Example.com")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint(1), user.ID)
+}
+
+func (suite *GormUserTestSuite) TestFindByExternalID() {
+	suite.mock.ExpectQuery(`^SELECT \* FROM "users" WHERE external_id = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`).
+		WithArgs("crm-1", 1).
+		WillReturnRows(sqlmock.NewRows(append(userColumns, "external_id")).
+			AddRow(1, "John Doe", "john@example.com", "Address 1", 30, "+1234567890", time.Now(), time.Now(), nil, "crm-1"))
+
+	user, err := suite.repo.FindByExternalID(context.Background(), "crm-1")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "crm-1", *user.ExternalID)
+}
+
+func (suite *GormUserTestSuite) TestFindNotFound() {
+	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE id = \\$1").
+		WithArgs(999, 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	_, err := suite.repo.Find(context.Background(), 999)
+	assert.ErrorIs(suite.T(), err, ErrNotFound)
+}
+
+func (suite *GormUserTestSuite) TestList() {
+	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE \"users\".\"deleted_at\" IS NULL ORDER BY created_at DESC, id DESC LIMIT \\$1$").
+		WithArgs(DefaultPageLimit + 1).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(1, "John Doe", "john@example.com", "Address 1", 30, "+1234567890", time.Now(), time.Now(), nil))
+
+	page, err := suite.repo.List(context.Background(), UserQuery{})
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), page.Users, 1)
+	assert.Equal(suite.T(), DefaultUserSort, page.Sort)
+	assert.False(suite.T(), page.HasNext)
+	assert.False(suite.T(), page.HasPrev)
+}
+
+func (suite *GormUserTestSuite) TestListFilteredWithCursor() {
+	createdAt := time.Date(2024, 7, 10, 4, 24, 55, 0, time.UTC)
+	minAge := 18
+	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE name ILIKE \\$1 AND age >= \\$2 AND \\(age, id\\) > \\(\\$3, \\$4\\) AND \"users\".\"deleted_at\" IS NULL ORDER BY age ASC, id ASC LIMIT \\$5$").
+		WithArgs("%jo\\_hn%", 18, int64(29), 5, 3).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(4, "Jo_hn Doe", "john@example.com", "Address 1", 30, "+1234567890", createdAt, createdAt, nil).
+			AddRow(3, "Jo_hnny", "johnny@example.com", "Address 2", 31, "+1234567891", createdAt, createdAt, nil).
+			AddRow(2, "Big Jo_hn", "big.john@example.com", "Address 3", 32, "+1234567892", createdAt, createdAt, nil))
+
+	page, err := suite.repo.List(context.Background(), UserQuery{
+		Limit:  2,
+		Sort:   "age",
+		Cursor: encodeCursor(cursor{Sort: "age", Value: "29", ID: 5}),
+		Name:   "jo_hn",
+		MinAge: &minAge,
+	})
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), page.Users, 2)
+	assert.True(suite.T(), page.HasNext)
+	assert.True(suite.T(), page.HasPrev)
+
+	next, err := decodeCursor(page.NextCursor)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), cursor{Sort: "age", Value: "31", ID: 3}, *next)
+
+	prev, err := decodeCursor(page.PrevCursor)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), cursor{Sort: "age", Value: "30", ID: 4, Backward: true}, *prev)
+}
+
+func (suite *GormUserTestSuite) TestListEmptyBackwardPage() {
+	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE \\(age, id\\) < \\(\\$1, \\$2\\) AND \"users\".\"deleted_at\" IS NULL ORDER BY age DESC, id DESC LIMIT \\$3$").
+		WithArgs(int64(30), 4, 3).
+		WillReturnRows(sqlmock.NewRows(userColumns))
+
+	page, err := suite.repo.List(context.Background(), UserQuery{
+		Limit:  2,
+		Sort:   "age",
+		Cursor: encodeCursor(cursor{Sort: "age", Value: "30", ID: 4, Backward: true}),
+	})
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), page.Users)
+	assert.False(suite.T(), page.HasPrev)
+	assert.True(suite.T(), page.HasNext)
+
+	// The next page starts with the row the cursor was taken from
+	next, err := decodeCursor(page.NextCursor)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), cursor{Sort: "age", Value: "30", ID: 4, Inclusive: true}, *next)
+
+	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE \\(age, id\\) >= \\(\\$1, \\$2\\) AND \"users\".\"deleted_at\" IS NULL ORDER BY age ASC, id ASC LIMIT \\$3$").
+		WithArgs(int64(30), 4, 3).
+		WillReturnRows(sqlmock.NewRows(userColumns))
+	_, err = suite.repo.List(context.Background(), UserQuery{Limit: 2, Sort: "age", Cursor: page.NextCursor})
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *GormUserTestSuite) TestCreate() {
+	user := models.User{Name: "test", Email: "test@gmail.com", Address: "jalan 123", Age: 24, PhoneNumber: "+62234567890"}
+	ctx := audit.WithRequestID(audit.WithActor(context.Background(), audit.UserActor(9)), "req-1")
+
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectQuery(`INSERT INTO "users"`).
+		WithArgs(nil, user.Name, user.Email, user.Address, user.Age, user.PhoneNumber, nil, nil, "", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.mock.ExpectQuery(`^INSERT INTO "audit_entries" \("user_id","action","actor","request_id","changes","created_at"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\) RETURNING "id"$`).
+		WithArgs(1, models.AuditCreate, "user:9", "req-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.mock.ExpectCommit()
+
+	assert.NoError(suite.T(), suite.repo.Create(ctx, &user))
+	assert.Equal(suite.T(), uint(1), user.ID)
+}
+
+func (suite *GormUserTestSuite) TestUpdateSavesZeroValues() {
+	user := models.User{ID: 1, Name: "test", Email: "test@gmail.com", Address: "", Age: 0, PhoneNumber: "+62234567890", Version: 3}
+
+	suite.mock.ExpectBegin()
+	suite.expectLock(`WHERE id = \$1 AND "users"."deleted_at" IS NULL`, 1,
+		sqlmock.NewRows(append(userColumns, "version")).AddRow(1, "test", "test@gmail.com", "jalan 123", 24, "+62234567890", time.Now(), time.Now(), nil, 3))
+	suite.mock.ExpectExec(`^UPDATE "users" SET "external_id"=\$1,"name"=\$2,"email"=\$3,"address"=\$4,"age"=\$5,"phone_number"=\$6,"email_verified_at"=\$7,"phone_verified_at"=\$8,"password_hash"=\$9,"version"=\$10,"updated_at"=\$11 WHERE "users"."deleted_at" IS NULL AND "id" = \$12$`).
+		WithArgs(nil, user.Name, user.Email, "", 0, user.PhoneNumber, nil, nil, "", 4, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.expectAudit(1, models.AuditUpdate, models.Changes{
+		"address": {Before: json.RawMessage(`"jalan 123"`), After: json.RawMessage(`""`)},
+		"age":     {Before: json.RawMessage(`24`), After: json.RawMessage(`0`)},
+	})
+	suite.mock.ExpectCommit()
+
+	assert.NoError(suite.T(), suite.repo.Update(context.Background(), &user))
+	assert.Equal(suite.T(), uint(4), user.Version)
+}
+
+func (suite *GormUserTestSuite) TestUpdateVersionMismatch() {
+	user := models.User{ID: 1, Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890", Version: 2}
+
+	suite.mock.ExpectBegin()
+	suite.expectLock(`WHERE id = \$1 AND "users"."deleted_at" IS NULL`, 1,
+		sqlmock.NewRows(append(userColumns, "version")).AddRow(1, "test", "test@gmail.com", "jalan 123", 24, "+62234567890", time.Now(), time.Now(), nil, 3))
+	suite.mock.ExpectRollback()
+
+	assert.ErrorIs(suite.T(), suite.repo.Update(context.Background(), &user), ErrVersionMismatch)
+	assert.Equal(suite.T(), uint(2), user.Version)
+}
+
+func (suite *GormUserTestSuite) TestUpdateNotFound() {
+	suite.mock.ExpectBegin()
+	suite.expectLock(`WHERE id = \$1 AND "users"."deleted_at" IS NULL`, 999, sqlmock.NewRows(userColumns))
+	suite.mock.ExpectRollback()
+
+	assert.ErrorIs(suite.T(), suite.repo.Update(context.Background(), &models.User{ID: 999}), ErrNotFound)
+}
+
+func (suite *GormUserTestSuite) TestSoftDelete() {
+	suite.mock.ExpectBegin()
+	suite.expectLock(`WHERE id = \$1 AND "users"."deleted_at" IS NULL`, 1,
+		sqlmock.NewRows(userColumns).AddRow(1, "test", "test@gmail.com", "jalan 123", 24, "+62234567890", time.Now(), time.Now(), nil))
+	suite.mock.ExpectExec(`^UPDATE "users" SET "deleted_at"=\$1 WHERE id = \$2 AND "users"."deleted_at" IS NULL$`).
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectQuery(`^INSERT INTO "audit_entries"`).
+		WithArgs(1, models.AuditDelete, audit.System, "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.mock.ExpectCommit()
+
+	assert.NoError(suite.T(), suite.repo.SoftDelete(context.Background(), 1))
+}
+
+func (suite *GormUserTestSuite) TestSoftDeleteNotFound() {
+	suite.mock.ExpectBegin()
+	suite.expectLock(`WHERE id = \$1 AND "users"."deleted_at" IS NULL`, 999, sqlmock.NewRows(userColumns))
+	suite.mock.ExpectRollback()
+
+	assert.ErrorIs(suite.T(), suite.repo.SoftDelete(context.Background(), 999), ErrNotFound)
+}
+
+func (suite *GormUserTestSuite) TestRestore() {
+	deletedAt := time.Date(2024, 7, 10, 4, 24, 55, 0, time.UTC)
+
+	suite.mock.ExpectBegin()
+	suite.expectLock(`WHERE id = \$1 AND deleted_at IS NOT NULL`, 1,
+		sqlmock.NewRows(userColumns).AddRow(1, "test", "test@gmail.com", "jalan 123", 24, "+62234567890", time.Now(), time.Now(), deletedAt))
+	suite.mock.ExpectExec(`^UPDATE "users" SET "deleted_at"=\$1,"updated_at"=\$2 WHERE id = \$3 AND deleted_at IS NOT NULL$`).
+		WithArgs(nil, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.expectAudit(1, models.AuditRestore, models.Changes{
+		"deletedAt": {Before: json.RawMessage(`"2024-07-10T04:24:55Z"`), After: json.RawMessage(`null`)},
+	})
+	suite.mock.ExpectCommit()
+
+	assert.NoError(suite.T(), suite.repo.Restore(context.Background(), 1))
+}
+
+func (suite *GormUserTestSuite) TestListDeleted() {
+	deletedAt := time.Date(2024, 7, 10, 4, 24, 55, 0, time.UTC)
+	suite.mock.ExpectQuery("^SELECT \\* FROM \"users\" WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC LIMIT \\$1$").
+		WithArgs(DefaultPageLimit + 1).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(1, "John Doe", "john@example.com", "Address 1", 30, "+1234567890", time.Now(), time.Now(), deletedAt))
+
+	page, err := suite.repo.ListDeleted(context.Background(), UserQuery{})
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), page.Users, 1)
+	assert.Equal(suite.T(), DefaultTrashSort, page.Sort)
+}
+
+func (suite *GormUserTestSuite) TestHardDelete() {
+	suite.mock.ExpectBegin()
+	suite.expectLock(`WHERE id = \$1`, 1,
+		sqlmock.NewRows(userColumns).AddRow(1, "test", "test@gmail.com", "jalan 123", 24, "+62234567890", time.Now(), time.Now(), nil))
+	suite.mock.ExpectExec(`^DELETE FROM "users" WHERE "users"."id" = \$1$`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectQuery(`^INSERT INTO "audit_entries"`).
+		WithArgs(1, models.AuditHardDelete, audit.System, "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.mock.ExpectCommit()
+
+	assert.NoError(suite.T(), suite.repo.HardDelete(context.Background(), 1))
+}
+
+func (suite *GormUserTestSuite) TestPurgeDeleted() {
+	before := time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC)
+	deletedAt := before.Add(-time.Hour)
+
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectQuery(`^SELECT \* FROM "users" WHERE deleted_at < \$1 ORDER BY deleted_at, id LIMIT \$2 FOR UPDATE$`).
+		WithArgs(before, 500).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(4, "first", "first@gmail.com", "jalan 123", 24, "+62234567890", time.Now(), time.Now(), deletedAt).
+			AddRow(7, "second", "second@gmail.com", "jalan 456", 30, "+62234567891", time.Now(), time.Now(), deletedAt))
+	// Every purged user leaves an entry in its history
+	suite.mock.ExpectQuery(`^INSERT INTO "audit_entries"`).
+		WithArgs(4, models.AuditHardDelete, audit.System, "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	suite.mock.ExpectQuery(`^INSERT INTO "audit_entries"`).
+		WithArgs(7, models.AuditHardDelete, audit.System, "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	suite.mock.ExpectExec(`^DELETE FROM "users" WHERE id IN \(\$1,\$2\)$`).
+		WithArgs(4, 7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	suite.mock.ExpectCommit()
+
+	purged, err := suite.repo.PurgeDeleted(context.Background(), before, 500)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(2), purged)
+}
+
+func (suite *GormUserTestSuite) TestPurgeDeletedNothing() {
+	before := time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC)
+
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectQuery(`^SELECT \* FROM "users" WHERE deleted_at < \$1`).
+		WithArgs(before, 500).
+		WillReturnRows(sqlmock.NewRows(userColumns))
+	suite.mock.ExpectCommit()
+
+	purged, err := suite.repo.PurgeDeleted(context.Background(), before, 500)
+	assert.NoError(suite.T(), err)
+	assert.Zero(suite.T(), purged)
+}
+
+func TestMemoryPurgeDeletedRecordsHistory(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	ctx := context.Background()
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, repo.Create(ctx, &user))
+	assert.NoError(t, repo.SoftDelete(ctx, user.ID))
+
+	purged, err := repo.PurgeDeleted(ctx, time.Now().Add(time.Minute), 500)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	history, err := repo.History(ctx, user.ID, HistoryQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, history.Entries, 3)
+	assert.Equal(t, models.AuditHardDelete, history.Entries[0].Action)
+	assert.Equal(t, audit.System, history.Entries[0].Actor)
+	assert.Equal(t, json.RawMessage(`"test@gmail.com"`), history.Entries[0].Changes["email"].Before)
+}
+
+func (suite *GormUserTestSuite) TestCreateConflict() {
+	user := models.User{Name: "test", Email: "test@gmail.com", Address: "jalan 123", Age: 24, PhoneNumber: "+62234567890"}
+
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectQuery(`INSERT INTO "users"`).
+		WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: models.UserEmailIndex})
+	suite.mock.ExpectRollback()
+
+	err := suite.repo.Create(context.Background(), &user)
+	assert.ErrorIs(suite.T(), err, ErrConflict)
+	assert.Equal(suite.T(), &ConflictError{Field: "email", Constraint: models.UserEmailIndex}, err)
+}
+
+func (suite *GormUserTestSuite) TestHistory() {
+	cur := encodeCursor(cursor{Sort: historySort, ID: 10})
+	suite.mock.ExpectQuery(`^SELECT \* FROM "audit_entries" WHERE user_id = \$1 AND id < \$2 ORDER BY id DESC LIMIT \$3$`).
+		WithArgs(1, 10, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "action", "actor", "request_id", "changes", "created_at"}).
+			AddRow(9, 1, "update", "user:1", "req-2", `{"name":{"before":"a","after":"b"}}`, time.Now()).
+			AddRow(7, 1, "update", "user:1", "req-1", `{}`, time.Now()).
+			AddRow(3, 1, "create", "system", "", `{}`, time.Now()))
+
+	page, err := suite.repo.History(context.Background(), 1, HistoryQuery{Limit: 2, Cursor: cur})
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), page.Entries, 2)
+	assert.True(suite.T(), page.HasNext)
+	assert.Equal(suite.T(), models.Change{Before: json.RawMessage(`"a"`), After: json.RawMessage(`"b"`)}, page.Entries[0].Changes["name"])
+
+	next, err := decodeCursor(page.NextCursor)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint(7), next.ID)
+}
+
+// expectLock expects the row of the user with id to be read and locked for a change.
+func (suite *GormUserTestSuite) expectLock(where string, id uint, rows *sqlmock.Rows) {
+	suite.mock.ExpectQuery(`^SELECT \* FROM "users" `+where+` LIMIT \$2 FOR UPDATE$`).
+		WithArgs(id, 1).
+		WillReturnRows(rows)
+}
+
+// expectAudit expects the audit entry of a change made without an actor.
+func (suite *GormUserTestSuite) expectAudit(id uint, action string, changes models.Changes) {
+	raw, err := changes.Value()
+	assert.NoError(suite.T(), err)
+	suite.mock.ExpectQuery(`^INSERT INTO "audit_entries"`).
+		WithArgs(id, action, audit.System, "", raw, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+func TestMemoryUniqueAmongLiveUsers(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	first := models.User{Name: "first", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, repo.Create(context.Background(), &first))
+
+	second := models.User{Name: "second", Email: "Test@Gmail.com", PhoneNumber: "+62234567891"}
+	assert.Equal(t, &ConflictError{Field: "email"}, repo.Create(context.Background(), &second))
+
+	assert.NoError(t, repo.SoftDelete(context.Background(), first.ID))
+	assert.NoError(t, repo.Create(context.Background(), &second))
+
+	// Restoring the first user would now duplicate the email of the second
+	assert.ErrorIs(t, repo.Restore(context.Background(), first.ID), ErrConflict)
+}
+
+func TestMemoryListWalksPagesBothWays(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	start := time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		// Two users share each created_at so the id tiebreak is exercised
+		user := models.User{Name: fmt.Sprintf("user %d", i), Email: fmt.Sprintf("user%d@gmail.com", i), PhoneNumber: fmt.Sprintf("+6223456789%d", i), Age: int8(20 + i), CreatedAt: start.Add(time.Duration(i/2) * time.Hour)}
+		assert.NoError(t, repo.Create(context.Background(), &user))
+	}
+	assert.NoError(t, repo.SoftDelete(context.Background(), 4))
+
+	ids := func(page *UserPage) []uint {
+		var ids []uint
+		for _, user := range page.Users {
+			ids = append(ids, user.ID)
+		}
+		return ids
+	}
+
+	first, err := repo.List(context.Background(), UserQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{7, 6}, ids(first))
+	assert.False(t, first.HasPrev)
+
+	second, err := repo.List(context.Background(), UserQuery{Limit: 2, Cursor: first.NextCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{5, 3}, ids(second))
+
+	third, err := repo.List(context.Background(), UserQuery{Limit: 2, Cursor: second.NextCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{2, 1}, ids(third))
+	assert.False(t, third.HasNext)
+
+	back, err := repo.List(context.Background(), UserQuery{Limit: 2, Cursor: third.PrevCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{5, 3}, ids(back))
+	assert.True(t, back.HasPrev)
+	assert.True(t, back.HasNext)
+
+	// A page back that comes up empty leads to the page it was turned from
+	assert.NoError(t, repo.SoftDelete(context.Background(), 7))
+	assert.NoError(t, repo.SoftDelete(context.Background(), 6))
+	empty, err := repo.List(context.Background(), UserQuery{Limit: 2, Cursor: back.PrevCursor})
+	assert.NoError(t, err)
+	assert.Empty(t, empty.Users)
+	assert.False(t, empty.HasPrev)
+	assert.True(t, empty.HasNext)
+	ahead, err := repo.List(context.Background(), UserQuery{Limit: 2, Cursor: empty.NextCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{5, 3}, ids(ahead))
+
+	// And so does one ahead
+	assert.NoError(t, repo.SoftDelete(context.Background(), 2))
+	assert.NoError(t, repo.SoftDelete(context.Background(), 1))
+	empty, err = repo.List(context.Background(), UserQuery{Limit: 2, Cursor: back.NextCursor})
+	assert.NoError(t, err)
+	assert.Empty(t, empty.Users)
+	assert.True(t, empty.HasPrev)
+	assert.False(t, empty.HasNext)
+	behind, err := repo.List(context.Background(), UserQuery{Limit: 2, Cursor: empty.PrevCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{5, 3}, ids(behind))
+
+	_, err = repo.List(context.Background(), UserQuery{Sort: "age", Cursor: first.NextCursor})
+	var invalid *InvalidQueryError
+	assert.ErrorAs(t, err, &invalid)
+}
+
+func TestMemoryExternalIDUniqueAmongLiveUsers(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	externalID := "crm-1"
+	user := models.User{ExternalID: &externalID, Email: "a@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, repo.Create(context.Background(), &user))
+
+	found, err := repo.FindByExternalID(context.Background(), externalID)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+
+	other := models.User{ExternalID: &externalID, Email: "b@gmail.com", PhoneNumber: "+62234567891"}
+	assert.Equal(t, &ConflictError{Field: "externalId"}, repo.Create(context.Background(), &other))
+
+	assert.NoError(t, repo.SoftDelete(context.Background(), user.ID))
+	_, err = repo.FindByExternalID(context.Background(), externalID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, repo.Create(context.Background(), &other))
+}
+
+func TestMemoryUpdateChecksVersion(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, repo.Create(context.Background(), &user))
+	assert.Equal(t, uint(1), user.Version)
+
+	stale := user
+	user.Name = "first"
+	assert.NoError(t, repo.Update(context.Background(), &user))
+	assert.Equal(t, uint(2), user.Version)
+
+	stale.Name = "second"
+	assert.ErrorIs(t, repo.Update(context.Background(), &stale), ErrVersionMismatch)
+	stored, _ := repo.Find(context.Background(), user.ID)
+	assert.Equal(t, "first", stored.Name)
+}
+
+func TestMemoryHistory(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	ctx := audit.WithActor(context.Background(), audit.UserActor(9))
+
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, repo.Create(ctx, &user))
+	other := models.User{Name: "other", Email: "other@gmail.com", PhoneNumber: "+62234567891"}
+	assert.NoError(t, repo.Create(ctx, &other))
+	user.Name = "renamed"
+	assert.NoError(t, repo.Update(ctx, &user))
+	assert.NoError(t, repo.SoftDelete(context.Background(), user.ID))
+	assert.NoError(t, repo.Restore(ctx, user.ID))
+	assert.NoError(t, repo.HardDelete(ctx, user.ID))
+
+	page, err := repo.History(context.Background(), user.ID, HistoryQuery{Limit: 3})
+	assert.NoError(t, err)
+	assert.True(t, page.HasNext)
+	var actions []string
+	for _, entry := range page.Entries {
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []string{models.AuditHardDelete, models.AuditRestore, models.AuditDelete}, actions)
+	assert.Equal(t, audit.System, page.Entries[2].Actor)
+
+	page, err = repo.History(context.Background(), user.ID, HistoryQuery{Limit: 3, Cursor: page.NextCursor})
+	assert.NoError(t, err)
+	assert.False(t, page.HasNext)
+	assert.Len(t, page.Entries, 2)
+	update, create := page.Entries[0], page.Entries[1]
+	assert.Equal(t, "user:9", update.Actor)
+	assert.Equal(t, models.Changes{"name": {Before: json.RawMessage(`"test"`), After: json.RawMessage(`"renamed"`)}}, update.Changes)
+	assert.Equal(t, models.AuditCreate, create.Action)
+	assert.Equal(t, json.RawMessage(`null`), create.Changes["email"].Before)
+
+	_, err = repo.History(context.Background(), user.ID, HistoryQuery{Cursor: encodeCursor(cursor{Sort: "name", ID: 1})})
+	assert.IsType(t, &InvalidQueryError{}, err)
+}
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		Err      error
+		Expected error
+	}{
+		{Err: gorm.ErrRecordNotFound, Expected: ErrNotFound},
+		{Err: &pgconn.PgError{Code: "23505", ConstraintName: models.UserPhoneNumberIndex}, Expected: &ConflictError{Field: "phoneNumber", Constraint: models.UserPhoneNumberIndex}},
+		{Err: &pgconn.PgError{Code: "23P01", ConstraintName: "sessions_no_overlap"}, Expected: &ConflictError{Constraint: "sessions_no_overlap"}},
+		{Err: &pgconn.PgError{Code: "57014"}, Expected: ErrTimeout},
+		{Err: context.DeadlineExceeded, Expected: ErrTimeout},
+		{Err: &pgconn.PgError{Code: "08006"}, Expected: ErrUnavailable},
+		{Err: &pgconn.PgError{Code: "57P01"}, Expected: ErrUnavailable},
+		{Err: driver.ErrBadConn, Expected: ErrUnavailable},
+		{Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, Expected: ErrUnavailable},
+	}
+
+	for _, test := range tests {
+		err := translateError(test.Err)
+		if conflict, ok := test.Expected.(*ConflictError); ok {
+			assert.Equal(t, conflict, err)
+			continue
+		}
+		assert.ErrorIs(t, err, test.Expected, test.Err.Error())
+	}
+
+	syntaxErr := &pgconn.PgError{Code: "42601"}
+	assert.Equal(t, syntaxErr, translateError(syntaxErr))
+
+	// Integrity violations other than duplicates are no conflict with existing data
+	for _, code := range []string{"23502", "23503", "23514"} {
+		integrityErr := &pgconn.PgError{Code: code, ConstraintName: "users_age_check"}
+		assert.Equal(t, integrityErr, translateError(integrityErr), code)
+		assert.NotErrorIs(t, translateError(integrityErr), ErrConflict, code)
+	}
+}
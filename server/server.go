@@ -0,0 +1,59 @@
+// Package server runs the HTTP server until it is told to stop, then drains it.
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"crud/user/health"
+)
+
+// Config controls how the server shuts down.
+type Config struct {
+	// DrainDelay is how long the server keeps serving after readiness starts failing,
+	// giving load balancers time to stop routing new requests to it
+	DrainDelay time.Duration
+	// ShutdownTimeout bounds how long in-flight requests get to finish
+	ShutdownTimeout time.Duration
+}
+
+// Run serves srv on ln until ctx is done, then reports readiness as draining,
+// waits DrainDelay, and shuts srv down, letting in-flight requests finish within
+// ShutdownTimeout. It returns nil after a clean shutdown.
+func Run(ctx context.Context, srv *http.Server, ln net.Listener, readiness *health.Readiness, config Config, logger *log.Logger) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+	readiness.Serving()
+	logger.Printf("server: listening on %s", ln.Addr())
+
+	select {
+	case err := <-served:
+		// Serve only returns early when the listener broke
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Printf("server: shutting down, draining for %s", config.DrainDelay)
+	readiness.Drain()
+	time.Sleep(config.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Requests still running are cut off
+		_ = srv.Close()
+		return err
+	}
+
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Printf("server: stopped")
+	return nil
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"crud/user/health"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDrainsInFlightRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		_, _ = io.WriteString(w, "done")
+	})}
+
+	readiness := health.NewReadiness()
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- Run(ctx, srv, ln, readiness, Config{DrainDelay: 10 * time.Millisecond, ShutdownTimeout: time.Second}, log.New(io.Discard, "", 0))
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+		body <- string(raw)
+	}()
+
+	<-started
+	assert.True(t, readiness.Ready())
+	stop()
+
+	assert.Equal(t, "done", <-body)
+	assert.NoError(t, <-stopped)
+	assert.Equal(t, "draining", readiness.States()["server"].Message)
+
+	_, err = http.Get("http://" + ln.Addr().String())
+	assert.Error(t, err, "no new connections after shutdown")
+}
+
+func TestRunCutsOffSlowRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- Run(ctx, srv, ln, health.NewReadiness(), Config{ShutdownTimeout: 20 * time.Millisecond}, log.New(io.Discard, "", 0))
+	}()
+
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	<-started
+	stop()
+	assert.ErrorIs(t, <-stopped, context.DeadlineExceeded)
+}
@@ -0,0 +1,35 @@
+// Package sms texts users, like the codes verifying their phone number.
+//
+// Providers implement Sender. ConsoleSender prints messages instead, so local
+// runs don't need an account with one.
+package sms
+
+import (
+	"context"
+	"log"
+)
+
+// Message is a text message to a phone number in E.164 format.
+type Message struct {
+	To   string
+	Body string
+}
+
+// Sender sends text messages.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ConsoleSender prints every message to a logger.
+type ConsoleSender struct {
+	logger *log.Logger
+}
+
+func NewConsoleSender(logger *log.Logger) *ConsoleSender {
+	return &ConsoleSender{logger: logger}
+}
+
+func (s *ConsoleSender) Send(ctx context.Context, msg Message) error {
+	s.logger.Printf("sms: to %s: %s", msg.To, msg.Body)
+	return nil
+}
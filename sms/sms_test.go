@@ -0,0 +1,19 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsoleSender(t *testing.T) {
+	var out bytes.Buffer
+	sender := NewConsoleSender(log.New(&out, "", 0))
+
+	err := sender.Send(context.Background(), Message{To: "+62234567890", Body: "Your code is 123456"})
+	assert.NoError(t, err)
+	assert.Equal(t, "sms: to +62234567890: Your code is 123456\n", out.String())
+}
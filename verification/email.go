@@ -0,0 +1,174 @@
+// Package verification proves users own the contact details they gave, and
+// lets them regain access through those when they forget their password.
+package verification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"crud/user/auth"
+	"crud/user/mail"
+	"crud/user/models"
+	"crud/user/repository"
+)
+
+var (
+	// ErrInvalidToken is returned by Confirm for unknown, used or expired tokens,
+	// and for tokens sent to an email address the user no longer has.
+	ErrInvalidToken = errors.New("the verification token is invalid or expired")
+	// ErrAlreadyVerified is returned by Send when there is nothing to verify.
+	ErrAlreadyVerified = errors.New("the email address is verified already")
+)
+
+// EmailVerifier mails users a single-use token proving they own their email address.
+type EmailVerifier struct {
+	users  repository.UserRepository
+	tokens repository.TokenRepository
+	mailer mail.Mailer
+	ttl    time.Duration
+	// link is the page the token is sent to, empty to mail the bare token
+	link string
+}
+
+func NewEmailVerifier(users repository.UserRepository, tokens repository.TokenRepository, mailer mail.Mailer, ttl time.Duration, link string) *EmailVerifier {
+	return &EmailVerifier{users: users, tokens: tokens, mailer: mailer, ttl: ttl, link: link}
+}
+
+// Send mails a verification token for the current email address of user.
+// Tokens sent before stop working.
+func (v *EmailVerifier) Send(ctx context.Context, user *models.User) error {
+	if user.EmailVerifiedAt != nil {
+		return ErrAlreadyVerified
+	}
+
+	token, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = v.tokens.Issue(ctx, &models.OneTimeToken{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		Purpose:   models.VerifyEmailPurpose,
+		Target:    user.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(v.ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := v.mailer.Send(ctx, v.message(user, token)); err != nil {
+		return fmt.Errorf("mail verification to user %d: %w", user.ID, err)
+	}
+	return nil
+}
+
+func (v *EmailVerifier) message(user *models.User, token string) mail.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\n", user.Name)
+	if v.link != "" {
+		fmt.Fprintf(&body, "please confirm this is your email address by opening\n\n%s?token=%s\n\n", v.link, url.QueryEscape(token))
+	} else {
+		fmt.Fprintf(&body, "please confirm this is your email address with this token\n\n%s\n\n", token)
+	}
+	fmt.Fprintf(&body, "It works once, within %s. If you didn't sign up, ignore this email.\n", humanDuration(v.ttl))
+
+	return mail.Message{To: user.Email, Subject: "Confirm your email address", Body: body.String()}
+}
+
+// Confirm uses token and marks the email address it was sent to as verified.
+// The token stays usable when that fails for reasons of the service's own.
+func (v *EmailVerifier) Confirm(ctx context.Context, token string) (*models.User, error) {
+	issued, err := v.tokens.Consume(ctx, models.VerifyEmailPurpose, auth.HashToken(token))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := updateTarget(ctx, v.users, issued, ErrInvalidToken, func(user *models.User) bool {
+		if user.EmailVerifiedAt != nil {
+			return false
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		return true
+	})
+	if err != nil {
+		return nil, restoreToken(ctx, v.tokens, issued, err, ErrInvalidToken)
+	}
+	return user, nil
+}
+
+// updateAttempts bounds how often updateTarget starts over after losing a race
+// with another update of the user.
+const updateAttempts = 3
+
+// updateTarget applies change to the user the token was issued to, and saves
+// them unless change reports there was nothing to do. Users that are gone, or
+// no longer have the address the token was sent to, are invalid.
+func updateTarget(ctx context.Context, users repository.UserRepository, issued *models.OneTimeToken, invalid error, change func(*models.User) bool) (*models.User, error) {
+	for attempt := 1; ; attempt++ {
+		user, err := users.Find(ctx, issued.UserID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, invalid
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The address changed since the token was sent
+		if !strings.EqualFold(user.Email, issued.Target) {
+			return nil, invalid
+		}
+
+		if !change(user) {
+			return user, nil
+		}
+		err = users.Update(ctx, user)
+		// Someone else updated the user meanwhile, start over from what they stored
+		if errors.Is(err, repository.ErrVersionMismatch) && attempt < updateAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+}
+
+// restoreToken gives back the token Consume took when using it failed with err,
+// so the link mailed to the user works again. Errors telling the token is
+// invalid keep it used.
+func restoreToken(ctx context.Context, tokens repository.TokenRepository, issued *models.OneTimeToken, err, invalid error) error {
+	if errors.Is(err, invalid) {
+		return err
+	}
+	// Even when the request was canceled
+	if restoreErr := tokens.Restore(context.WithoutCancel(ctx), issued); restoreErr != nil {
+		return errors.Join(err, fmt.Errorf("restore token: %w", restoreErr))
+	}
+	return err
+}
+
+// humanDuration spells out d for an email, like "24 hours" rather than "24h0m0s".
+func humanDuration(d time.Duration) string {
+	unit, size := "minute", time.Minute
+	switch {
+	case d%(24*time.Hour) == 0:
+		unit, size = "day", 24*time.Hour
+	case d%time.Hour == 0:
+		unit, size = "hour", time.Hour
+	case d%time.Minute != 0:
+		return d.String()
+	}
+	if n := d / size; n != 1 {
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	return "1 " + unit
+}
@@ -0,0 +1,140 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"crud/user/mail"
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mailbox keeps the messages sent to it
+type mailbox struct {
+	messages []mail.Message
+}
+
+func (m *mailbox) Send(ctx context.Context, msg mail.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+var tokenInLink = regexp.MustCompile(`\?token=([A-Za-z0-9_-]+)`)
+
+func (m *mailbox) lastToken(t *testing.T) string {
+	match := tokenInLink.FindStringSubmatch(m.messages[len(m.messages)-1].Body)
+	assert.NotNil(t, match)
+	return match[1]
+}
+
+func TestEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserRepository()
+	inbox := &mailbox{}
+	v := NewEmailVerifier(users, repository.NewMemoryTokenRepository(), inbox, time.Hour, "https://app.example.com/verify-email")
+
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, users.Create(ctx, &user))
+
+	assert.NoError(t, v.Send(ctx, &user))
+	assert.Equal(t, "test@gmail.com", inbox.messages[0].To)
+	assert.Contains(t, inbox.messages[0].Body, "https://app.example.com/verify-email?token=")
+	first := inbox.lastToken(t)
+
+	// Resending retires the first token
+	assert.NoError(t, v.Send(ctx, &user))
+	second := inbox.lastToken(t)
+	_, err := v.Confirm(ctx, first)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	verified, err := v.Confirm(ctx, second)
+	assert.NoError(t, err)
+	assert.NotNil(t, verified.EmailVerifiedAt)
+	stored, _ := users.Find(ctx, user.ID)
+	assert.NotNil(t, stored.EmailVerifiedAt)
+
+	// Tokens are single-use, and there is nothing left to send
+	_, err = v.Confirm(ctx, second)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.ErrorIs(t, v.Send(ctx, stored), ErrAlreadyVerified)
+}
+
+func TestEmailVerificationFollowsTheAddress(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserRepository()
+	inbox := &mailbox{}
+	v := NewEmailVerifier(users, repository.NewMemoryTokenRepository(), inbox, time.Hour, "https://app.example.com/verify-email")
+
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, users.Create(ctx, &user))
+	assert.NoError(t, v.Send(ctx, &user))
+
+	// A token sent to the old address doesn't verify the new one
+	user.Email = "new@gmail.com"
+	assert.NoError(t, users.Update(ctx, &user))
+	_, err := v.Confirm(ctx, inbox.lastToken(t))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+// racingUsers fails the next updates with errs, before passing them on
+type racingUsers struct {
+	*repository.MemoryUserRepository
+	errs []error
+}
+
+func (r *racingUsers) Update(ctx context.Context, user *models.User) error {
+	if len(r.errs) > 0 {
+		err := r.errs[0]
+		r.errs = r.errs[1:]
+		return err
+	}
+	return r.MemoryUserRepository.Update(ctx, user)
+}
+
+func TestEmailVerificationKeepsTheTokenOnFailure(t *testing.T) {
+	ctx := context.Background()
+	users := &racingUsers{MemoryUserRepository: repository.NewMemoryUserRepository()}
+	inbox := &mailbox{}
+	v := NewEmailVerifier(users, repository.NewMemoryTokenRepository(), inbox, time.Hour, "https://app.example.com/verify-email")
+
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, users.Create(ctx, &user))
+	assert.NoError(t, v.Send(ctx, &user))
+	token := inbox.lastToken(t)
+
+	// The database failing doesn't spend the token
+	unavailable := errors.New("database unavailable")
+	users.errs = []error{unavailable}
+	_, err := v.Confirm(ctx, token)
+	assert.ErrorIs(t, err, unavailable)
+
+	// Losing a race with another update is retried
+	users.errs = []error{repository.ErrVersionMismatch}
+	verified, err := v.Confirm(ctx, token)
+	assert.NoError(t, err)
+	assert.NotNil(t, verified.EmailVerifiedAt)
+
+	_, err = v.Confirm(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestEmailVerificationWithoutLink(t *testing.T) {
+	inbox := &mailbox{}
+	v := NewEmailVerifier(repository.NewMemoryUserRepository(), repository.NewMemoryTokenRepository(), inbox, 24*time.Hour, "")
+
+	assert.NoError(t, v.Send(context.Background(), &models.User{ID: 1, Name: "test", Email: "test@gmail.com"}))
+	assert.NotContains(t, inbox.messages[0].Body, "?token=")
+	assert.Contains(t, inbox.messages[0].Body, "within 1 day")
+}
+
+func TestHumanDuration(t *testing.T) {
+	assert.Equal(t, "2 days", humanDuration(48*time.Hour))
+	assert.Equal(t, "1 hour", humanDuration(time.Hour))
+	assert.Equal(t, "30 minutes", humanDuration(30*time.Minute))
+	assert.Equal(t, "1m30s", humanDuration(90*time.Second))
+}
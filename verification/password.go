@@ -0,0 +1,190 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"crud/user/auth"
+	"crud/user/mail"
+	"crud/user/models"
+	"crud/user/repository"
+)
+
+// ErrInvalidResetToken is returned by Reset for unknown, used or expired tokens,
+// and for tokens sent to an email address the user no longer has.
+var ErrInvalidResetToken = errors.New("the password reset token is invalid or expired")
+
+// PasswordResetter mails users a single-use token to choose a new password with.
+type PasswordResetter struct {
+	users     repository.UserRepository
+	tokens    repository.TokenRepository
+	sessions  repository.SessionRepository
+	passwords *auth.Passwords
+	mailer    mail.Mailer
+	ttl       time.Duration
+	// link is the page the token is sent to, empty to mail the bare token
+	link string
+}
+
+func NewPasswordResetter(users repository.UserRepository, tokens repository.TokenRepository, sessions repository.SessionRepository,
+	passwords *auth.Passwords, mailer mail.Mailer, ttl time.Duration, link string) *PasswordResetter {
+	return &PasswordResetter{users: users, tokens: tokens, sessions: sessions, passwords: passwords, mailer: mailer, ttl: ttl, link: link}
+}
+
+// Request mails a reset token to the user with email. Unknown emails are
+// ignored, so callers can't tell which ones exist. Tokens sent before stop working.
+func (r *PasswordResetter) Request(ctx context.Context, email string) error {
+	user, err := r.users.FindByEmail(ctx, email)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = r.tokens.Issue(ctx, &models.OneTimeToken{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		Purpose:   models.ResetPasswordPurpose,
+		Target:    user.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(r.ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := r.mailer.Send(ctx, r.message(user, token)); err != nil {
+		return fmt.Errorf("mail password reset to user %d: %w", user.ID, err)
+	}
+	return nil
+}
+
+// resetTimeout bounds each request run by a ResetQueue.
+const resetTimeout = 30 * time.Second
+
+// ResetQueue runs the password reset requests of forgot-password in the
+// background, so answering takes as long whether or not a user has the email,
+// and failures only a known email can cause never reach the caller. They are
+// logged instead. Requests arriving while the queue is full are dropped.
+type ResetQueue struct {
+	resets *PasswordResetter
+	logger *log.Logger
+	emails chan string
+
+	// mu guards stopped, Enqueue can't send on the closed channel
+	mu      sync.Mutex
+	stopped bool
+	done    chan struct{}
+}
+
+func NewResetQueue(resets *PasswordResetter, size int, logger *log.Logger) *ResetQueue {
+	return &ResetQueue{resets: resets, logger: logger, emails: make(chan string, size), done: make(chan struct{})}
+}
+
+// Start handles the queued requests in the background until Stop is called.
+func (q *ResetQueue) Start(ctx context.Context) {
+	go func() {
+		defer close(q.done)
+		for email := range q.emails {
+			ctx, cancel := context.WithTimeout(ctx, resetTimeout)
+			if err := q.resets.Request(ctx, email); err != nil {
+				q.logger.Printf("password reset: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// Enqueue queues a password reset request for email without waiting for it.
+func (q *ResetQueue) Enqueue(email string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped {
+		return
+	}
+	select {
+	case q.emails <- email:
+	default:
+		q.logger.Print("password reset: queue is full, dropped a request")
+	}
+}
+
+// Stop waits for the queued requests to be handled. Later ones are ignored.
+func (q *ResetQueue) Stop() {
+	q.mu.Lock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.emails)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
+
+func (r *PasswordResetter) message(user *models.User, token string) mail.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\n", user.Name)
+	if r.link != "" {
+		fmt.Fprintf(&body, "choose a new password by opening\n\n%s?token=%s\n\n", r.link, url.QueryEscape(token))
+	} else {
+		fmt.Fprintf(&body, "choose a new password with this token\n\n%s\n\n", token)
+	}
+	fmt.Fprintf(&body, "It works once, within %s. If you didn't ask to reset your password, ignore this email.\n", humanDuration(r.ttl))
+
+	return mail.Message{To: user.Email, Subject: "Reset your password", Body: body.String()}
+}
+
+// Reset uses token to set the password of its user, who is logged out everywhere.
+// Receiving the token proves owning the email address, so it counts as verified.
+// The token stays usable when resetting fails for reasons of the service's own.
+func (r *PasswordResetter) Reset(ctx context.Context, token, password string) (*models.User, error) {
+	issued, err := r.tokens.Consume(ctx, models.ResetPasswordPurpose, auth.HashToken(token))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := r.reset(ctx, issued, password)
+	if err != nil {
+		return nil, restoreToken(ctx, r.tokens, issued, err, ErrInvalidResetToken)
+	}
+	return user, nil
+}
+
+func (r *PasswordResetter) reset(ctx context.Context, issued *models.OneTimeToken, password string) (*models.User, error) {
+	hash, err := r.passwords.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	user, err := updateTarget(ctx, r.users, issued, ErrInvalidResetToken, func(user *models.User) bool {
+		user.PasswordHash = hash
+		if user.EmailVerifiedAt == nil {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Whoever knew the old password is logged out
+	if _, err := r.sessions.RevokeAll(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
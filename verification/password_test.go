@@ -0,0 +1,147 @@
+package verification
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"crud/user/auth"
+	"crud/user/mail"
+	"crud/user/models"
+	"crud/user/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserRepository()
+	sessions := repository.NewMemorySessionRepository()
+	passwords, _ := auth.NewPasswords(4)
+	inbox := &mailbox{}
+	r := NewPasswordResetter(users, repository.NewMemoryTokenRepository(), sessions, passwords, inbox, time.Hour, "https://app.example.com/reset-password")
+
+	hash, _ := passwords.Hash("correct-Horse-battery")
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890", PasswordHash: hash}
+	assert.NoError(t, users.Create(ctx, &user))
+	for _, device := range []string{"phone", "laptop"} {
+		session := models.Session{UserID: user.ID, UserAgent: device, LastUsedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		assert.NoError(t, sessions.Create(ctx, &session, device))
+	}
+
+	// Unknown emails are ignored without an error
+	assert.NoError(t, r.Request(ctx, "nobody@gmail.com"))
+	assert.Empty(t, inbox.messages)
+
+	assert.NoError(t, r.Request(ctx, "TEST@gmail.com"))
+	assert.Equal(t, "test@gmail.com", inbox.messages[0].To)
+	assert.Contains(t, inbox.messages[0].Body, "https://app.example.com/reset-password?token=")
+	token := inbox.lastToken(t)
+
+	reset, err := r.Reset(ctx, token, "new-Horse-battery")
+	assert.NoError(t, err)
+	assert.NotNil(t, reset.EmailVerifiedAt)
+	stored, _ := users.Find(ctx, user.ID)
+	assert.NoError(t, passwords.Check(stored.PasswordHash, "new-Horse-battery"))
+	active, _ := sessions.List(ctx, user.ID)
+	assert.Empty(t, active)
+
+	// Tokens are single-use, and email verification tokens don't reset passwords
+	_, err = r.Reset(ctx, token, "other-Horse-battery")
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+	emails := NewEmailVerifier(users, repository.NewMemoryTokenRepository(), inbox, time.Hour, "https://app.example.com/verify-email")
+	stored.EmailVerifiedAt = nil
+	assert.NoError(t, emails.Send(ctx, stored))
+	_, err = r.Reset(ctx, inbox.lastToken(t), "other-Horse-battery")
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+}
+
+func TestPasswordResetFollowsTheAddress(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserRepository()
+	passwords, _ := auth.NewPasswords(4)
+	inbox := &mailbox{}
+	r := NewPasswordResetter(users, repository.NewMemoryTokenRepository(), repository.NewMemorySessionRepository(), passwords, inbox, time.Hour, "https://app.example.com/reset-password")
+
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, users.Create(ctx, &user))
+	assert.NoError(t, r.Request(ctx, user.Email))
+
+	// A token sent to the old address doesn't reset the password any more
+	user.Email = "new@gmail.com"
+	assert.NoError(t, users.Update(ctx, &user))
+	_, err := r.Reset(ctx, inbox.lastToken(t), "new-Horse-battery")
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+}
+
+func TestPasswordResetKeepsTheTokenOnFailure(t *testing.T) {
+	ctx := context.Background()
+	users := &racingUsers{MemoryUserRepository: repository.NewMemoryUserRepository()}
+	passwords, _ := auth.NewPasswords(4)
+	inbox := &mailbox{}
+	r := NewPasswordResetter(users, repository.NewMemoryTokenRepository(), repository.NewMemorySessionRepository(), passwords, inbox, time.Hour, "https://app.example.com/reset-password")
+
+	hash, _ := passwords.Hash("correct-Horse-battery")
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890", PasswordHash: hash}
+	assert.NoError(t, users.Create(ctx, &user))
+	assert.NoError(t, r.Request(ctx, user.Email))
+	token := inbox.lastToken(t)
+
+	// The database failing doesn't spend the token, nor change the password
+	unavailable := errors.New("database unavailable")
+	users.errs = []error{unavailable}
+	_, err := r.Reset(ctx, token, "new-Horse-battery")
+	assert.ErrorIs(t, err, unavailable)
+	stored, _ := users.Find(ctx, user.ID)
+	assert.NoError(t, passwords.Check(stored.PasswordHash, "correct-Horse-battery"))
+
+	// Losing a race with another update is retried
+	users.errs = []error{repository.ErrVersionMismatch}
+	_, err = r.Reset(ctx, token, "new-Horse-battery")
+	assert.NoError(t, err)
+	stored, _ = users.Find(ctx, user.ID)
+	assert.NoError(t, passwords.Check(stored.PasswordHash, "new-Horse-battery"))
+
+	_, err = r.Reset(ctx, token, "other-Horse-battery")
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+}
+
+// stuckMailer waits for release before failing every message.
+type stuckMailer struct {
+	release chan struct{}
+}
+
+func (m *stuckMailer) Send(ctx context.Context, msg mail.Message) error {
+	<-m.release
+	return errors.New("connection refused")
+}
+
+func TestResetQueue(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserRepository()
+	passwords, _ := auth.NewPasswords(4)
+	mailer := &stuckMailer{release: make(chan struct{})}
+	r := NewPasswordResetter(users, repository.NewMemoryTokenRepository(), repository.NewMemorySessionRepository(), passwords, mailer, time.Hour, "")
+	user := models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, users.Create(ctx, &user))
+
+	var logged bytes.Buffer
+	q := NewResetQueue(r, 1, log.New(&logged, "", 0))
+	q.Start(ctx)
+
+	// Enqueuing doesn't wait for the mail, the first request is stuck sending it
+	q.Enqueue(user.Email)
+	assert.Eventually(t, func() bool { return len(q.emails) == 0 }, time.Second, time.Millisecond)
+	q.Enqueue(user.Email)
+	q.Enqueue("nobody@gmail.com")
+	assert.Contains(t, logged.String(), "queue is full")
+
+	close(mailer.release)
+	q.Stop()
+	q.Enqueue(user.Email)
+	assert.Equal(t, 2, strings.Count(logged.String(), "connection refused"))
+}
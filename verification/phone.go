@@ -0,0 +1,191 @@
+package verification
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"crud/user/auth"
+	"crud/user/models"
+	"crud/user/repository"
+	"crud/user/sms"
+)
+
+// CodeLength is the number of digits in a texted code.
+const CodeLength = 6
+
+var (
+	// ErrInvalidCode is returned by Confirm for wrong or expired codes, and for
+	// codes sent to a phone number the user no longer has.
+	ErrInvalidCode = errors.New("the code is wrong or expired")
+	// ErrPhoneAlreadyVerified is returned by Send when there is nothing to verify.
+	ErrPhoneAlreadyVerified = errors.New("the phone number is verified already")
+)
+
+// LockedError is returned while a user who entered too many wrong codes is locked out.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many wrong codes, try again after %s", e.Until.Format(time.RFC3339))
+}
+
+// PhoneConfig limits texted codes. They work for TTL, and entering MaxAttempts
+// wrong ones locks the user out for Lockout.
+type PhoneConfig struct {
+	TTL         time.Duration
+	MaxAttempts int
+	Lockout     time.Duration
+}
+
+// PhoneVerifier texts users a one-time code proving they own their phone number.
+type PhoneVerifier struct {
+	users  repository.UserRepository
+	codes  repository.PhoneCodeRepository
+	sender sms.Sender
+	config PhoneConfig
+}
+
+func NewPhoneVerifier(users repository.UserRepository, codes repository.PhoneCodeRepository, sender sms.Sender, config PhoneConfig) *PhoneVerifier {
+	return &PhoneVerifier{users: users, codes: codes, sender: sender, config: config}
+}
+
+// Send texts a code to the current phone number of user. The code sent before
+// stops working, but its wrong attempts still count.
+func (v *PhoneVerifier) Send(ctx context.Context, user *models.User) error {
+	if user.PhoneVerifiedAt != nil {
+		return ErrPhoneAlreadyVerified
+	}
+
+	now := time.Now()
+	attempts := 0
+	previous, err := v.codes.Find(ctx, user.ID)
+	switch {
+	case err == nil:
+		if previous.Locked(now) {
+			return &LockedError{Until: *previous.LockedUntil}
+		}
+		attempts = previous.Attempts
+	case !errors.Is(err, repository.ErrNotFound):
+		return err
+	}
+
+	code, err := newCode()
+	if err != nil {
+		return err
+	}
+	err = v.codes.Save(ctx, &models.PhoneCode{
+		UserID:      user.ID,
+		PhoneNumber: user.PhoneNumber,
+		CodeHash:    hashCode(user.ID, code),
+		Attempts:    attempts,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(v.config.TTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := sms.Message{
+		To:   user.PhoneNumber,
+		Body: fmt.Sprintf("Your verification code is %s. It expires in %s.", code, humanDuration(v.config.TTL)),
+	}
+	if err := v.sender.Send(ctx, msg); err != nil {
+		return fmt.Errorf("text verification code to user %d: %w", user.ID, err)
+	}
+	return nil
+}
+
+// Confirm checks code against the one last texted to the user with userID and
+// marks the phone number it was sent to as verified.
+func (v *PhoneVerifier) Confirm(ctx context.Context, userID uint, code string) (*models.User, error) {
+	now := time.Now()
+	current, err := v.codes.Find(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current.Locked(now) {
+		return nil, &LockedError{Until: *current.LockedUntil}
+	}
+
+	// Count the attempt before checking it, so parallel guesses can't exceed the limit
+	attempt, err := v.codes.Attempt(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	wrong := subtle.ConstantTimeCompare([]byte(hashCode(userID, code)), []byte(attempt.CodeHash)) != 1
+	if attempt.Attempts > v.config.MaxAttempts || wrong && attempt.Attempts == v.config.MaxAttempts {
+		return nil, v.lock(ctx, attempt, now)
+	}
+	if wrong || !now.Before(attempt.ExpiresAt) {
+		return nil, ErrInvalidCode
+	}
+
+	user, err := v.users.Find(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := v.codes.Delete(ctx, userID); err != nil {
+		return nil, err
+	}
+	// The number changed since the code was sent
+	if user.PhoneNumber != attempt.PhoneNumber {
+		return nil, ErrInvalidCode
+	}
+
+	if user.PhoneVerifiedAt == nil {
+		user.PhoneVerifiedAt = &now
+		if err := v.users.Update(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// lock throws the code away and keeps the user from entering another one for a while.
+func (v *PhoneVerifier) lock(ctx context.Context, code *models.PhoneCode, now time.Time) error {
+	until := now.Add(v.config.Lockout)
+	err := v.codes.Save(ctx, &models.PhoneCode{
+		UserID:      code.UserID,
+		PhoneNumber: code.PhoneNumber,
+		CreatedAt:   code.CreatedAt,
+		ExpiresAt:   code.ExpiresAt,
+		LockedUntil: &until,
+	})
+	if err != nil {
+		return err
+	}
+	return &LockedError{Until: until}
+}
+
+// newCode draws a random code of CodeLength digits.
+func newCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < CodeLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", CodeLength, n), nil
+}
+
+// hashCode ties code to the user, so equal codes sent to two users hash differently.
+func hashCode(userID uint, code string) string {
+	return auth.HashToken(fmt.Sprintf("%d:%s", userID, code))
+}
@@ -0,0 +1,135 @@
+package verification
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"crud/user/models"
+	"crud/user/repository"
+	"crud/user/sms"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// phone keeps the text messages sent to it
+type phone struct {
+	messages []sms.Message
+}
+
+func (p *phone) Send(ctx context.Context, msg sms.Message) error {
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+var codeInText = regexp.MustCompile(`code is (\d{6})\.`)
+
+func (p *phone) lastCode(t *testing.T) string {
+	match := codeInText.FindStringSubmatch(p.messages[len(p.messages)-1].Body)
+	assert.NotNil(t, match)
+	return match[1]
+}
+
+var testPhoneConfig = PhoneConfig{TTL: 10 * time.Minute, MaxAttempts: 3, Lockout: 15 * time.Minute}
+
+func newTestPhoneVerifier(t *testing.T) (*PhoneVerifier, *repository.MemoryUserRepository, *phone, *models.User) {
+	users := repository.NewMemoryUserRepository()
+	texts := &phone{}
+	user := &models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, users.Create(context.Background(), user))
+	return NewPhoneVerifier(users, repository.NewMemoryPhoneCodeRepository(), texts, testPhoneConfig), users, texts, user
+}
+
+// wrongCode returns a code that isn't code
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestPhoneVerification(t *testing.T) {
+	ctx := context.Background()
+	v, users, texts, user := newTestPhoneVerifier(t)
+
+	assert.NoError(t, v.Send(ctx, user))
+	assert.Equal(t, "+62234567890", texts.messages[0].To)
+	assert.Contains(t, texts.messages[0].Body, "expires in 10 minutes")
+	first := texts.lastCode(t)
+
+	// Resending retires the first code
+	assert.NoError(t, v.Send(ctx, user))
+	second := texts.lastCode(t)
+	if first != second {
+		_, err := v.Confirm(ctx, user.ID, first)
+		assert.ErrorIs(t, err, ErrInvalidCode)
+	}
+
+	verified, err := v.Confirm(ctx, user.ID, second)
+	assert.NoError(t, err)
+	assert.NotNil(t, verified.PhoneVerifiedAt)
+	stored, _ := users.Find(ctx, user.ID)
+	assert.NotNil(t, stored.PhoneVerifiedAt)
+
+	// Codes are single-use, and there is nothing left to send
+	_, err = v.Confirm(ctx, user.ID, second)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+	assert.ErrorIs(t, v.Send(ctx, stored), ErrPhoneAlreadyVerified)
+}
+
+func TestPhoneVerificationLocksOut(t *testing.T) {
+	ctx := context.Background()
+	v, _, texts, user := newTestPhoneVerifier(t)
+
+	assert.NoError(t, v.Send(ctx, user))
+	code := texts.lastCode(t)
+
+	// Attempts survive resending
+	_, err := v.Confirm(ctx, user.ID, wrongCode(code))
+	assert.ErrorIs(t, err, ErrInvalidCode)
+	assert.NoError(t, v.Send(ctx, user))
+	code = texts.lastCode(t)
+	_, err = v.Confirm(ctx, user.ID, wrongCode(code))
+	assert.ErrorIs(t, err, ErrInvalidCode)
+
+	_, err = v.Confirm(ctx, user.ID, wrongCode(code))
+	var locked *LockedError
+	assert.ErrorAs(t, err, &locked)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), locked.Until, time.Minute)
+
+	// Even the right code and new codes are refused while locked out
+	_, err = v.Confirm(ctx, user.ID, code)
+	assert.ErrorAs(t, err, &locked)
+	assert.ErrorAs(t, v.Send(ctx, user), &locked)
+}
+
+func TestPhoneVerificationFollowsTheNumber(t *testing.T) {
+	ctx := context.Background()
+	v, users, texts, user := newTestPhoneVerifier(t)
+	assert.NoError(t, v.Send(ctx, user))
+
+	// A code sent to the old number doesn't verify the new one
+	user.PhoneNumber = "+62234567891"
+	assert.NoError(t, users.Update(ctx, user))
+	_, err := v.Confirm(ctx, user.ID, texts.lastCode(t))
+	assert.ErrorIs(t, err, ErrInvalidCode)
+}
+
+func TestPhoneVerificationExpires(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserRepository()
+	codes := repository.NewMemoryPhoneCodeRepository()
+	user := &models.User{Name: "test", Email: "test@gmail.com", PhoneNumber: "+62234567890"}
+	assert.NoError(t, users.Create(ctx, user))
+	texts := &phone{}
+	v := NewPhoneVerifier(users, codes, texts, testPhoneConfig)
+
+	assert.NoError(t, v.Send(ctx, user))
+	code, _ := codes.Find(ctx, user.ID)
+	code.ExpiresAt = time.Now().Add(-time.Second)
+	assert.NoError(t, codes.Save(ctx, code))
+
+	_, err := v.Confirm(ctx, user.ID, texts.lastCode(t))
+	assert.ErrorIs(t, err, ErrInvalidCode)
+}